@@ -1,34 +1,53 @@
 package CryptoConditions
 
 import (
-	"encoding/binary"
-	"errors"
+	"strings"
 
+	"github.com/jtremback/crypto-conditions/ThresholdSha256"
 	"github.com/jtremback/crypto-conditions/encoding"
+	"github.com/jtremback/crypto-conditions/registry"
 )
 
+// A fulfillment or condition string, along with its weight.
+//
+// Deprecated: use ThresholdSha256.WeightedString.
 type WeightedString struct {
 	Weight uint32
 	String []byte
 }
 
+// Deprecated: use ThresholdSha256.WeightedStrings.
 type WeightedStrings []WeightedString
 
+// ParseWeightedStrings parses the entries of a threshold payload, which
+// follow the threshold.
+//
+// Deprecated: use ThresholdSha256.ParsePayload.
 func ParseWeightedStrings(b []byte) (WeightedStrings, error) {
-	bs := encoding.ParseVarray(b)
+	items, err := encoding.ParseVarray(b)
+	if err != nil {
+		return nil, err
+	}
+
 	ws := WeightedStrings{}
 
-	for _, b := range bs {
-		w, b, err := encoding.GetUvarint(b)
+	for _, item := range items {
+		r := encoding.NewReader(item)
+
+		w, err := r.ReadUvarintMax(1<<32 - 1)
 		if err != nil {
 			return nil, err
 		}
 
-		s, _, err := encoding.GetVarbyte(b)
+		s, err := r.ReadVarbyte()
 		if err != nil {
 			return nil, err
 		}
 
+		if err := r.End(); err != nil {
+			return nil, err
+		}
+
 		ws = append(ws, WeightedString{
 			Weight: uint32(w),
 			String: s,
@@ -50,134 +69,100 @@ func (a WeightedStrings) Less(i, j int) bool {
 	return len(a[i].String) < len(a[j].String)
 }
 
-func (wss *WeightedStrings) WriteTo(b []byte) {
-	i := 0
-	for _, ws := range *wss {
-		// write weight
-		i = binary.PutUvarint(b[i:], uint64(ws.Weight))
-		// prefix length
-		i = binary.PutUvarint(b[i:], uint64(len(ws.String)))
-		// write fulfillment or condition
-		b = append(b[i:], ws.String...)
-	}
-}
-
-func ParseFulfillment(b []byte) (uint16, []byte, error) {
-	typ, b, err := encoding.GetUvarint(b)
-	if err != nil {
-		return 0, []byte{}, err
-	}
-
-	payload, b, err := encoding.GetVarbyte(b)
-	if err != nil {
-		return 0, []byte{}, err
-	}
-
-	return uint16(typ), payload, nil
-}
-
+// Deprecated: use ThresholdSha256.Fulfillment.
 type ThresholdSha256Fulfillment struct {
-	Threshold       uint32
+	Threshold uint32
+	// Entries of the fulfillment, each a fulfillment or a condition string
 	SubFulfillments WeightedStrings
 }
 
+// ParseThresholdSha256Fulfillment parses the payload of a threshold
+// fulfillment, and checks that its entries are well-formed.
+//
+// Deprecated: use ThresholdSha256.ParsePayload.
 func ParseThresholdSha256Fulfillment(payload []byte) (*ThresholdSha256Fulfillment, error) {
-	threshold, b, err := encoding.GetUvarint(payload)
+	parsed, err := ThresholdSha256.ParsePayload(payload)
 	if err != nil {
 		return nil, err
 	}
 
-	f, b, err := encoding.GetVarbyte(b)
-	if err != nil {
-		return nil, err
+	ful := &ThresholdSha256Fulfillment{
+		Threshold:       parsed.Threshold,
+		SubFulfillments: WeightedStrings{},
 	}
 
-	subFulfillments, err := ParseWeightedStrings(f)
+	entries, err := parsed.Entries()
 	if err != nil {
 		return nil, err
 	}
 
-	ful := &ThresholdSha256Fulfillment{
-		Threshold:       uint32(threshold),
-		SubFulfillments: subFulfillments,
+	for _, entry := range entries {
+		ful.SubFulfillments = append(ful.SubFulfillments, WeightedString{
+			Weight: entry.Weight,
+			String: []byte(entry.String),
+		})
 	}
 
 	return ful, nil
 }
 
+// Serialize returns the payload of the fulfillment, or nil if an entry is
+// malformed.
+//
+// Deprecated: use ThresholdSha256.Fulfillment.MarshalBinary.
 func (ful *ThresholdSha256Fulfillment) Serialize() []byte {
-	b := []byte{}
-
-	// write threshold
-	i := binary.PutUvarint(b, uint64(ful.Threshold))
-
-	// write subfulfillments
-	ful.SubFulfillments.WriteTo(b[i:])
-
-	return b
+	b, _ := ful.fulfillment().MarshalBinary()
+	_, payload, _ := registry.SplitBinaryFulfillment(b)
+	return payload
 }
 
-func Validate(fulfillment []byte, message []byte) error {
-	typ, payload, err := ParseFulfillment(fulfillment)
+// Condition derives the condition of the fulfillment, or returns the zero
+// Condition if an entry is malformed.
+//
+// Deprecated: use ThresholdSha256.Fulfillment.Condition.
+func (ful *ThresholdSha256Fulfillment) Condition() Condition {
+	parsed, err := ThresholdSha256.ParsePayload(ful.Serialize())
 	if err != nil {
-		return err
-	}
-	switch typ {
-	case 2:
-		err := ThresholdSha256Validate(payload, message)
-		if err != nil {
-			return err
-		}
-		return nil
-	case 4:
-		err := Ed25519Validate(payload, message)
-		if err != nil {
-			return err
-		}
-		return nil
-	default:
-		return errors.New("Unrecognized fulfillment type")
+		return Condition{}
 	}
-}
 
-func ThresholdSha256Validate(payload []byte, message []byte) error {
-	ful, err := ParseThresholdSha256Fulfillment(payload)
+	cond, err := parsed.Condition()
 	if err != nil {
-		return err
+		return Condition{}
 	}
 
-	var fulfilled uint32
-
-	for _, sf := range ful.SubFulfillments {
-		err := Validate(sf.String, message)
-		if err != nil {
-			return err
-		}
-		fulfilled += sf.Weight
+	return Condition{
+		Type:                 ThresholdSha256.TypeID,
+		FeatureBitmask:       encoding.MakeUvarint(ThresholdSha256.FeatureBits),
+		Fingerprint:          cond.Hash[:],
+		MaxFulfillmentLength: cond.MaxFulfillmentLength,
 	}
+}
 
-	if fulfilled < ful.Threshold {
-		return errors.New("Not enough fulfillments")
+// The entries sorted into fulfillments and conditions
+func (ful *ThresholdSha256Fulfillment) fulfillment() *ThresholdSha256.Fulfillment {
+	f := &ThresholdSha256.Fulfillment{
+		Threshold:       ful.Threshold,
+		SubConditions:   ThresholdSha256.WeightedStrings{},
+		SubFulfillments: ThresholdSha256.WeightedStrings{},
 	}
 
-	return nil
-}
-
-func (ful *ThresholdSha256Fulfillment) Condition() Condition {
-	subconditions := make(WeightedStrings, len(ful.SubFulfillments))
-	for i, sf := range ful.SubFulfillments {
-		subconditions[i] = WeightedString{
-			Weight: sf.Weight,
-			String: sf.String,
+	for _, sf := range ful.SubFulfillments {
+		ws := ThresholdSha256.WeightedString{Weight: sf.Weight, String: string(sf.String)}
+		if strings.HasPrefix(ws.String, "cf:") {
+			f.SubFulfillments = append(f.SubFulfillments, ws)
+		} else {
+			f.SubConditions = append(f.SubConditions, ws)
 		}
 	}
 
-	// Still need to sort
+	return f
+}
 
-	return Condition{
-		Type:           2,
-		FeatureBitmask: []byte{0x09},
-		// Fingerprint:          sha256.Sum256()[:],
-		MaxFulfillmentLength: 96,
-	}
+// ThresholdSha256Validate checks the payload of a threshold fulfillment
+// against the message.
+//
+// Deprecated: use ThresholdSha256.Validate.
+func ThresholdSha256Validate(payload []byte, message []byte) error {
+	return ThresholdSha256.Validate(payload, message)
 }
@@ -0,0 +1,40 @@
+package ThresholdSha256
+
+import (
+	"context"
+	"runtime"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/registry"
+)
+
+// ValidateConcurrent checks the payload for validity against the message
+// like Validate, validating up to workers subfulfillments at a time, or
+// GOMAXPROCS if workers is less than 1. The workers are shared with the
+// nested thresholds. It stops once the threshold is met, or can't be met by
+// the subfulfillments left, and returns a *conderr.CanceledError if ctx is
+// done first.
+//
+// The payload starts a new fulfillment, within the other options of ctx.
+// To validate concurrently through the registry, set
+// conderr.ValidationOptions.Workers with registry.WithOptions instead.
+func ValidateConcurrent(ctx context.Context, payload []byte, message []byte, workers int) error {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	opts := registry.Options(ctx)
+	opts.Workers = workers
+	ctx = registry.WithOptions(ctx, &opts)
+
+	if err := conderr.Canceled(ctx); err != nil {
+		return err
+	}
+
+	ctx, err := registry.Nested(ctx, TypeID, payload)
+	if err != nil {
+		return err
+	}
+
+	return ValidateContext(ctx, payload, message)
+}
@@ -0,0 +1,1001 @@
+// Generates and parses Threshold-Sha256 Crypto Conditions
+package ThresholdSha256
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/der"
+	"github.com/jtremback/crypto-conditions/encoding"
+	"github.com/jtremback/crypto-conditions/registry"
+)
+
+const (
+	TypeID      = 4
+	Name        = "ThresholdSha256"
+	FeatureBits = registry.FeatureSha256 | registry.FeatureThreshold
+)
+
+func init() {
+	registry.Register(&registry.Type{
+		ID:          TypeID,
+		Name:        Name,
+		FeatureBits: FeatureBits,
+		ParseFulfillment: func(payload []byte) (registry.Fulfillment, error) {
+			return ParsePayload(payload)
+		},
+		FulfillmentToCondition: func(payload []byte) (string, error) {
+			return fulfillmentToCondition(context.Background(), payload)
+		},
+		Validate: Validate,
+		Cost:     Cost,
+		UnmarshalJSON: func(b []byte) (registry.Fulfillment, error) {
+			ful := &Fulfillment{}
+			if err := json.Unmarshal(b, ful); err != nil {
+				return nil, err
+			}
+			return ful, nil
+		},
+		ParseFulfillmentContext: func(ctx context.Context, payload []byte) (registry.Fulfillment, error) {
+			return ParsePayloadContext(ctx, payload)
+		},
+		FulfillmentToConditionContext: fulfillmentToCondition,
+		ValidateContext:               ValidateContext,
+		CostContext:                   CostContext,
+	})
+}
+
+func fulfillmentToCondition(ctx context.Context, payload []byte) (string, error) {
+	ful, err := ParsePayloadContext(ctx, payload)
+	if err != nil {
+		return "", err
+	}
+	cond, err := ful.condition(ctx)
+	if err != nil {
+		return "", err
+	}
+	return cond.Serialize(), nil
+}
+
+// A fulfillment or condition string, along with its weight
+type WeightedString struct {
+	Weight uint32
+	String string
+}
+
+// Binary form of the entry, as written in the fulfillment payload
+func (ws *WeightedString) bytes() []byte {
+	return bytes.Join([][]byte{
+		encoding.MakeUvarint(uint64(ws.Weight)),
+		encoding.MakeVarbyte([]byte(ws.String)),
+	}, []byte{})
+}
+
+type WeightedStrings []WeightedString
+
+func (a WeightedStrings) Len() int      { return len(a) }
+func (a WeightedStrings) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a WeightedStrings) Less(i, j int) bool {
+	return lessBytes(a[i].bytes(), a[j].bytes())
+}
+
+// Sorts by length, and lexicographically if the lengths are equal
+func lessBytes(a, b []byte) bool {
+	if len(a) == len(b) {
+		return bytes.Compare(a, b) < 0
+	}
+
+	return len(a) < len(b)
+}
+
+type Fulfillment struct {
+	Threshold uint32
+	// Conditions of all subfulfillments, fulfilled or not
+	SubConditions WeightedStrings
+	// Fulfillments of the subconditions that are fulfilled
+	SubFulfillments WeightedStrings
+
+	// Conditions derived from the subfulfillments while parsing, by
+	// fulfillment string, so that they are only derived once
+	derived map[string]string
+}
+
+// Derives the condition of a subfulfillment, unless it was derived while
+// parsing
+func (ful *Fulfillment) subcondition(ctx context.Context, s string) (string, error) {
+	if cond, ok := ful.derived[s]; ok {
+		return cond, nil
+	}
+
+	return registry.FulfillmentToConditionContext(ctx, s)
+}
+
+// Serializes to the Crypto Conditions Fulfillment string format, or to the
+// empty string if the Entries can't be found.
+func (ful *Fulfillment) Serialize() string {
+	payload, err := ful.payload()
+	if err != nil {
+		return ""
+	}
+
+	return "cf:1:" + registry.FormatType(TypeID) + ":" + base64.URLEncoding.EncodeToString(payload)
+}
+
+// Binary payload of the fulfillment, as found in the string and binary
+// formats
+func (ful *Fulfillment) payload() ([]byte, error) {
+	entries, err := ful.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	items := [][]byte{}
+	for _, entry := range entries {
+		items = append(items, entry.bytes())
+	}
+
+	return bytes.Join([][]byte{
+		encoding.MakeUvarint(uint64(ful.Threshold)),
+		encoding.MakeVarray(items),
+	}, []byte{}), nil
+}
+
+// Entries returns the entries of the serialized fulfillment, in order. Each
+// subcondition is written as its fulfillment if one is present, and as the
+// condition otherwise. Subfulfillments whose condition can't be derived
+// can't be matched to their subconditions, so their errors are returned,
+// positioned by their index in SubFulfillments.
+func (ful *Fulfillment) Entries() (WeightedStrings, error) {
+	entries := WeightedStrings{}
+	fulfilled := map[WeightedString]int{}
+
+	for i, sf := range ful.SubFulfillments {
+		entries = append(entries, sf)
+
+		cond, err := ful.subcondition(context.Background(), sf.String)
+		if err != nil {
+			return nil, conderr.InChild(i, err)
+		}
+		fulfilled[WeightedString{Weight: sf.Weight, String: cond}]++
+	}
+
+	for _, sc := range ful.SubConditions {
+		if fulfilled[sc] > 0 {
+			// Only skip one matching subcondition per subfulfillment
+			fulfilled[sc]--
+			continue
+		}
+		entries = append(entries, sc)
+	}
+
+	sort.Sort(entries)
+
+	return entries, nil
+}
+
+// Parses Fulfillment out of the Crypto Conditions string format, and checks
+// that the subfulfillments and subconditions are well-formed.
+func ParseFulfillment(s string) (*Fulfillment, error) {
+	typ, payload, err := registry.SplitFulfillment(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if typ != TypeID {
+		return nil, &conderr.ParseError{Type: typ, Err: conderr.ErrUnsupportedType}
+	}
+
+	return ParsePayload(payload)
+}
+
+// Parses Fulfillment out of the binary payload, and checks that the
+// subfulfillments and subconditions are well-formed. Signatures are checked
+// when validating.
+func ParsePayload(b []byte) (*Fulfillment, error) {
+	ctx, err := registry.Nested(context.Background(), TypeID, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePayloadContext(ctx, b)
+}
+
+// ParsePayloadContext is ParsePayload, within the limits of ctx and stopped
+// with a *conderr.CanceledError once ctx is done. The payload itself must
+// already be accounted for with registry.Nested, as the registry does.
+func ParsePayloadContext(ctx context.Context, b []byte) (*Fulfillment, error) {
+	threshold, entries, err := parseEntries(b, registry.Options(ctx).MaxChildren)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseFulfillment(ctx, threshold, entries)
+}
+
+// Builds the Fulfillment out of the entries of the payload, deriving the
+// condition of every subfulfillment
+func parseFulfillment(ctx context.Context, threshold uint32, entries WeightedStrings) (*Fulfillment, error) {
+	ful := &Fulfillment{
+		Threshold:       threshold,
+		SubConditions:   WeightedStrings{},
+		SubFulfillments: WeightedStrings{},
+		derived:         map[string]string{},
+	}
+
+	for i, entry := range entries {
+		if strings.HasPrefix(entry.String, "cf:") {
+			cond, err := registry.FulfillmentToConditionContext(ctx, entry.String)
+			if err != nil {
+				return nil, conderr.InChild(i, err)
+			}
+			ful.SubFulfillments = append(ful.SubFulfillments, entry)
+			ful.SubConditions = append(ful.SubConditions, WeightedString{
+				Weight: entry.Weight,
+				String: cond,
+			})
+			ful.derived[entry.String] = cond
+		} else {
+			_, err := registry.ParseCondition(entry.String)
+			if err != nil {
+				return nil, conderr.InChild(i, err)
+			}
+			ful.SubConditions = append(ful.SubConditions, entry)
+		}
+	}
+
+	return ful, nil
+}
+
+// Parses the threshold and the fulfillment and condition entries of the
+// binary payload, without looking into the entries. There may be at most
+// maxChildren entries.
+func parseEntries(payload []byte, maxChildren int) (uint32, WeightedStrings, error) {
+	r := encoding.NewReader(payload)
+
+	threshold, err := r.ReadUvarintMax(math.MaxUint32)
+	if err != nil {
+		return 0, nil, conderr.NewParseError(TypeID, 0, err)
+	}
+
+	entries := WeightedStrings{}
+
+	for r.Len() > 0 {
+		if len(entries) == maxChildren {
+			return 0, nil, conderr.NewParseError(TypeID, r.Offset(), &conderr.LimitError{Limit: conderr.LimitChildren, Max: maxChildren})
+		}
+
+		item, err := r.ReadVarbyte()
+		if err != nil {
+			return 0, nil, conderr.NewParseError(TypeID, 0, err)
+		}
+		offset := r.Offset() - len(item)
+
+		ir := encoding.NewReader(item)
+
+		weight, err := ir.ReadUvarintMax(math.MaxUint32)
+		if err != nil {
+			return 0, nil, conderr.NewParseError(TypeID, offset, err)
+		}
+
+		s, err := ir.ReadVarbyte()
+		if err != nil {
+			return 0, nil, conderr.NewParseError(TypeID, offset, err)
+		}
+
+		if err := ir.End(); err != nil {
+			return 0, nil, conderr.NewParseError(TypeID, offset, err)
+		}
+
+		if !strings.HasPrefix(string(s), "cf:") && !strings.HasPrefix(string(s), "cc:") {
+			return 0, nil, conderr.NewParseError(TypeID, offset+len(item)-len(s), &conderr.SyntaxError{Msg: "subconditions must start with \"cf\" or \"cc\""})
+		}
+
+		entries = append(entries, WeightedString{
+			Weight: uint32(weight),
+			String: string(s),
+		})
+	}
+
+	return uint32(threshold), entries, nil
+}
+
+// Returns the cost of the payload without checking any signatures. This is
+// the cost of the most expensive subfulfillments that make up the threshold,
+// plus a fixed cost for every subcondition.
+func Cost(payload []byte) (uint64, error) {
+	ctx, err := registry.Nested(context.Background(), TypeID, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	return CostContext(ctx, payload)
+}
+
+// CostContext is Cost, within the limits of ctx like ParsePayloadContext.
+func CostContext(ctx context.Context, payload []byte) (uint64, error) {
+	threshold, entries, err := parseEntries(payload, registry.Options(ctx).MaxChildren)
+	if err != nil {
+		return 0, err
+	}
+
+	weights := []uint32{}
+	costs := []uint64{}
+	for i, entry := range entries {
+		if !strings.HasPrefix(entry.String, "cf:") {
+			continue
+		}
+
+		c, err := registry.CostContext(ctx, entry.String)
+		if err != nil {
+			return 0, conderr.InChild(i, err)
+		}
+		weights = append(weights, entry.Weight)
+		costs = append(costs, c)
+	}
+
+	return thresholdCost(threshold, len(entries), weights, costs), nil
+}
+
+// Cost of the most expensive subfulfillments that make up the threshold,
+// plus a fixed cost for every subcondition
+func (ful *Fulfillment) Cost() (uint64, error) {
+	weights := []uint32{}
+	costs := []uint64{}
+	for _, sf := range ful.SubFulfillments {
+		c, err := registry.Cost(sf.String)
+		if err != nil {
+			return 0, err
+		}
+		weights = append(weights, sf.Weight)
+		costs = append(costs, c)
+	}
+
+	return thresholdCost(ful.Threshold, len(ful.SubConditions), weights, costs), nil
+}
+
+// The cost of a threshold as the DER format has it: the most expensive
+// subfulfillments whose weights add up to the threshold, plus a fixed cost
+// for each of the entries. Subfulfillments are counted from the most
+// expensive until their weights reach the threshold, or all of them if they
+// don't.
+func thresholdCost(threshold uint32, entries int, weights []uint32, costs []uint64) uint64 {
+	order := make([]int, len(costs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return costs[order[a]] > costs[order[b]] })
+
+	c := uint64(entries) * der.ThresholdSubconditionCost
+	var weight uint64
+	for _, i := range order {
+		if weight >= uint64(threshold) {
+			break
+		}
+		c = registry.AddCost(c, costs[i])
+		weight += uint64(weights[i])
+	}
+
+	return c
+}
+
+// Checks the payload for validity against the message. The weights of the
+// valid subfulfillments must add up to the threshold, and subfulfillments
+// that aren't valid are counted as unfulfilled subconditions. Every entry
+// must still be well-formed, since the condition covers all of them.
+// Subfulfillments are validated from the cheapest to the most expensive, and
+// only until the threshold is met or can no longer be met.
+func Validate(payload []byte, message []byte) error {
+	_, err := Evaluate(payload, message)
+	return err
+}
+
+// ValidateContext is Validate, within the limits of ctx like
+// ParsePayloadContext, and stopped with a *conderr.CanceledError once ctx is
+// done. If the options of ctx allow several workers, subfulfillments are
+// validated concurrently, with the same outcome.
+func ValidateContext(ctx context.Context, payload []byte, message []byte) error {
+	_, err := EvaluateContext(ctx, payload, message)
+	return err
+}
+
+// Evaluate checks the payload for validity like Validate, and returns the
+// indexes of the entries whose subfulfillments were taken into account, in
+// the order they were. The failures of a *conderr.ThresholdError are in the
+// same order.
+func Evaluate(payload []byte, message []byte) ([]int, error) {
+	ctx, err := registry.Nested(context.Background(), TypeID, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return EvaluateContext(ctx, payload, message)
+}
+
+// EvaluateContext is Evaluate, within the limits of ctx like
+// ParsePayloadContext, and stopped with a *conderr.CanceledError once ctx is
+// done.
+//
+// Subfulfillments are taken into account in the same order whether they are
+// validated concurrently or not, so the outcome doesn't depend on which ones
+// finish first. Concurrent validations that are no longer needed are
+// cancelled. Subfulfillments past the one that decides the outcome are
+// missing from the indexes, and are only validated, signatures included, if
+// another worker started on them ahead of time.
+func EvaluateContext(ctx context.Context, payload []byte, message []byte) ([]int, error) {
+	threshold, entries, err := parseEntries(payload, registry.Options(ctx).MaxChildren)
+	if err != nil {
+		return nil, err
+	}
+
+	fulfillments, remaining, err := checkEntries(ctx, threshold, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	// Validations in progress are finished before returning, once the
+	// others are cancelled
+	defer wg.Wait()
+
+	if registry.Options(ctx).Workers > 1 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+	}
+
+	results := make([]chan error, len(fulfillments))
+	// Subfulfillments before started are being validated or done
+	started := 0
+
+	// Starts validating the next subfulfillment, in a new goroutine if a
+	// worker is free, and here otherwise
+	start := func() {
+		n := started
+		started++
+		results[n] = make(chan error, 1)
+		validate := func() {
+			results[n] <- registry.ValidateContext(ctx, entries[fulfillments[n]].String, message)
+		}
+
+		wg.Add(1)
+		if !registry.Go(ctx, func() { defer wg.Done(); validate() }) {
+			wg.Done()
+			validate()
+		}
+	}
+
+	checked := []int{}
+	var fulfilled uint64
+	failed := []error{}
+
+	for k, i := range fulfillments {
+		if fulfilled >= uint64(threshold) || fulfilled+remaining < uint64(threshold) {
+			break
+		}
+
+		checked = append(checked, i)
+		remaining -= uint64(entries[i].Weight)
+
+		// Subfulfillments that come next are started while waiting for the
+		// result, so that this goroutine is one of the workers
+		var err error
+	wait:
+		for {
+			select {
+			case err = <-results[k]:
+				break wait
+			default:
+			}
+
+			if started < len(fulfillments) {
+				start()
+				continue
+			}
+
+			select {
+			case err = <-results[k]:
+			case <-ctx.Done():
+				return checked, conderr.Canceled(ctx)
+			}
+			break
+		}
+
+		if errors.Is(err, conderr.ErrCanceled) {
+			return checked, err
+		}
+		if err != nil {
+			failed = append(failed, conderr.InChild(i, err))
+			continue
+		}
+		fulfilled += uint64(entries[i].Weight)
+	}
+
+	if fulfilled < uint64(threshold) {
+		return checked, &conderr.ValidationError{
+			Type: TypeID,
+			Err: &conderr.ThresholdError{
+				Threshold: uint64(threshold),
+				Weight:    fulfilled,
+				Failed:    failed,
+			},
+		}
+	}
+
+	return checked, nil
+}
+
+// Checks that every entry is well-formed, and returns the indexes of the
+// subfulfillments from the cheapest to validate to the most expensive,
+// along with their total weight
+func checkEntries(ctx context.Context, threshold uint32, entries WeightedStrings) ([]int, uint64, error) {
+	// Parsing derives the condition of every subfulfillment once
+	if _, err := parseFulfillment(ctx, threshold, entries); err != nil {
+		return nil, 0, err
+	}
+
+	fulfillments := []int{}
+	costs := map[int]uint64{}
+	var weight uint64
+
+	for i, entry := range entries {
+		if !strings.HasPrefix(entry.String, "cf:") {
+			continue
+		}
+
+		var err error
+		costs[i], err = registry.CostContext(ctx, entry.String)
+		if err != nil {
+			return nil, 0, conderr.InChild(i, err)
+		}
+
+		fulfillments = append(fulfillments, i)
+		weight += uint64(entry.Weight)
+	}
+
+	// Subfulfillments of the same cost stay in entry order
+	sort.SliceStable(fulfillments, func(a, b int) bool {
+		return costs[fulfillments[a]] < costs[fulfillments[b]]
+	})
+
+	return fulfillments, weight, nil
+}
+
+// What Minimize minimizes
+type Metric int
+
+const (
+	// The cost of validating the fulfillment
+	MinCost Metric = iota
+	// The length of the serialized fulfillment
+	MinSize
+)
+
+// A subset of the subfulfillments considered by Minimize
+type selection struct {
+	cost uint64
+	size int64
+	// Whether each subfulfillment, in serialization order, is in the subset
+	chosen []bool
+}
+
+// Adds the next subfulfillment to the selection, or leaves it out
+func (sel *selection) extend(take bool, cost uint64, size int64) *selection {
+	next := &selection{
+		cost:   sel.cost,
+		size:   sel.size,
+		chosen: append(append([]bool{}, sel.chosen...), take),
+	}
+
+	if take {
+		next.cost = registry.AddCost(next.cost, cost)
+		next.size += size
+	}
+
+	return next
+}
+
+// Orders selections by the metric, then by the other metric, then by
+// preferring the subfulfillments that come first in serialization order.
+// This is a total order, so the best selection is always the same.
+func (sel *selection) less(other *selection, metric Metric) bool {
+	if metric == MinSize && sel.size != other.size {
+		return sel.size < other.size
+	}
+
+	if sel.cost != other.cost {
+		return sel.cost < other.cost
+	}
+
+	if sel.size != other.size {
+		return sel.size < other.size
+	}
+
+	for i := range sel.chosen {
+		if sel.chosen[i] != other.chosen[i] {
+			return sel.chosen[i]
+		}
+	}
+
+	return false
+}
+
+// Minimize keeps the subset of the subfulfillments whose weights add up to
+// the threshold at the lowest cost or size, and turns the others into
+// subconditions. The choice doesn't depend on the order of SubFulfillments,
+// so every holder of the same subfulfillments serializes the same
+// fulfillment. The subfulfillments are assumed to be valid.
+func (ful *Fulfillment) Minimize(metric Metric) error {
+	candidates := append(WeightedStrings{}, ful.SubFulfillments...)
+	sort.Sort(candidates)
+
+	costs := []uint64{}
+	sizes := []int64{}
+	conditions := WeightedStrings{}
+
+	for _, sf := range candidates {
+		cond, err := ful.subcondition(context.Background(), sf.String)
+		if err != nil {
+			return err
+		}
+
+		cost, err := registry.Cost(sf.String)
+		if err != nil {
+			return err
+		}
+
+		costs = append(costs, cost)
+		// The fulfillment takes the place of its condition
+		sizes = append(sizes, int64(len(encoding.MakeVarbyte([]byte(sf.String))))-int64(len(encoding.MakeVarbyte([]byte(cond)))))
+		conditions = append(conditions, WeightedString{Weight: sf.Weight, String: cond})
+	}
+
+	// Best selection reaching each weight, with weights above the threshold
+	// counted as the threshold
+	threshold := uint64(ful.Threshold)
+	best := map[uint64]*selection{0: &selection{chosen: []bool{}}}
+
+	for i, sf := range candidates {
+		next := map[uint64]*selection{}
+		keep := func(weight uint64, sel *selection) {
+			if weight > threshold {
+				weight = threshold
+			}
+			if cur, ok := next[weight]; !ok || sel.less(cur, metric) {
+				next[weight] = sel
+			}
+		}
+
+		for weight, sel := range best {
+			keep(weight, sel.extend(false, 0, 0))
+			keep(weight+uint64(sf.Weight), sel.extend(true, costs[i], sizes[i]))
+		}
+
+		best = next
+	}
+
+	sel, ok := best[threshold]
+	if !ok {
+		var weight uint64
+		for _, sf := range candidates {
+			weight += uint64(sf.Weight)
+		}
+
+		return &conderr.ValidationError{
+			Type: TypeID,
+			Err: &conderr.ThresholdError{
+				Threshold: threshold,
+				Weight:    weight,
+			},
+		}
+	}
+
+	// Every subfulfillment keeps its subcondition, whether it is chosen or
+	// not
+	unmatched := map[WeightedString]int{}
+	for _, sc := range ful.SubConditions {
+		unmatched[sc]++
+	}
+	for _, cond := range conditions {
+		if unmatched[cond] > 0 {
+			unmatched[cond]--
+			continue
+		}
+		ful.SubConditions = append(ful.SubConditions, cond)
+	}
+
+	ful.SubFulfillments = WeightedStrings{}
+	for i, chosen := range sel.chosen {
+		if chosen {
+			ful.SubFulfillments = append(ful.SubFulfillments, candidates[i])
+		}
+	}
+
+	return nil
+}
+
+// Turns an in-memory Fulfillment to an in-memory Condition. Every
+// subfulfillment must match one of the subconditions.
+func (ful *Fulfillment) Condition() (Condition, error) {
+	return ful.condition(context.Background())
+}
+
+func (ful *Fulfillment) condition(ctx context.Context) (Condition, error) {
+	unmatched := map[WeightedString]int{}
+	for _, sc := range ful.SubConditions {
+		unmatched[sc]++
+	}
+
+	for _, sf := range ful.SubFulfillments {
+		cond, err := ful.subcondition(ctx, sf.String)
+		if err != nil {
+			return Condition{}, err
+		}
+
+		ws := WeightedString{Weight: sf.Weight, String: cond}
+		if unmatched[ws] == 0 {
+			return Condition{}, &conderr.ValidationError{Type: TypeID, Err: conderr.ErrConditionMismatch}
+		}
+		unmatched[ws]--
+	}
+
+	subconditions := [][]byte{}
+	length := uint64(len(encoding.MakeUvarint(uint64(ful.Threshold))))
+
+	for _, sc := range ful.SubConditions {
+		cond, err := registry.ParseCondition(sc.String)
+		if err != nil {
+			return Condition{}, err
+		}
+
+		subconditions = append(subconditions, bytes.Join([][]byte{
+			encoding.MakeUvarint(uint64(sc.Weight)),
+			cond.Binary(),
+		}, []byte{}))
+
+		// Each entry is at most as long as the longer of its condition and
+		// its largest fulfillment
+		entry := uint64(len(sc.String))
+		if cond.MaxFulfillmentLength > entry {
+			entry = cond.MaxFulfillmentLength
+		}
+		entry += uint64(len(encoding.MakeUvarint(uint64(sc.Weight)))) +
+			uint64(len(encoding.MakeUvarint(entry)))
+		length += uint64(len(encoding.MakeUvarint(entry))) + entry
+	}
+
+	sort.Slice(subconditions, func(i, j int) bool {
+		return lessBytes(subconditions[i], subconditions[j])
+	})
+
+	hash := sha256.Sum256(bytes.Join([][]byte{
+		encoding.MakeUvarint(uint64(ful.Threshold)),
+		encoding.MakeVarray(subconditions),
+	}, []byte{}))
+
+	// The fulfillment is serialized as a base64 string with a header
+	length = uint64(len("cf:1:"+registry.FormatType(TypeID)+":")) + uint64(base64.URLEncoding.EncodedLen(int(length)))
+
+	return Condition{
+		Hash:                 hash,
+		MaxFulfillmentLength: length,
+	}, nil
+}
+
+type Condition struct {
+	Hash                 [32]byte
+	MaxFulfillmentLength uint64
+}
+
+// Serializes to the Crypto Conditions string format.
+func (cond *Condition) Serialize() string {
+	return "cc:1:" + registry.FormatType(TypeID) + ":" + base64.URLEncoding.EncodeToString(cond.Hash[:]) + ":" + strconv.FormatUint(cond.MaxFulfillmentLength, 10)
+}
+
+func FulfillmentToCondition(s string) (string, error) {
+	ful, err := ParseFulfillment(s)
+	if err != nil {
+		return "", err
+	}
+
+	cond, err := ful.Condition()
+	if err != nil {
+		return "", err
+	}
+
+	condString := cond.Serialize()
+	return condString, nil
+}
+
+// JSON representation of the Fulfillment. The entries are in the order of
+// the string format, each holding either a fulfillment or a condition in
+// its own JSON representation.
+type jsonFulfillment struct {
+	Type            string      `json:"type"`
+	Threshold       uint32      `json:"threshold"`
+	SubFulfillments []jsonEntry `json:"subfulfillments"`
+}
+
+type jsonEntry struct {
+	Weight      uint32          `json:"weight"`
+	Fulfillment json.RawMessage `json:"fulfillment,omitempty"`
+	Condition   json.RawMessage `json:"condition,omitempty"`
+}
+
+func (ful *Fulfillment) MarshalJSON() ([]byte, error) {
+	aux := &jsonFulfillment{
+		Type:            Name,
+		Threshold:       ful.Threshold,
+		SubFulfillments: []jsonEntry{},
+	}
+
+	entries, err := ful.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, entry := range entries {
+		e := jsonEntry{Weight: entry.Weight}
+
+		if strings.HasPrefix(entry.String, "cf:") {
+			b, err := registry.MarshalFulfillmentJSON(entry.String)
+			if err != nil {
+				return nil, conderr.InChild(i, err)
+			}
+			e.Fulfillment = b
+		} else {
+			cond, err := registry.ParseCondition(entry.String)
+			if err != nil {
+				return nil, conderr.InChild(i, err)
+			}
+			b, err := json.Marshal(cond)
+			if err != nil {
+				return nil, err
+			}
+			e.Condition = b
+		}
+
+		aux.SubFulfillments = append(aux.SubFulfillments, e)
+	}
+
+	return json.Marshal(aux)
+}
+
+func (ful *Fulfillment) UnmarshalJSON(b []byte) error {
+	aux := &jsonFulfillment{}
+	if err := json.Unmarshal(b, aux); err != nil {
+		return err
+	}
+
+	if err := registry.CheckTypeName(aux.Type, TypeID); err != nil {
+		return err
+	}
+
+	ful.Threshold = aux.Threshold
+	ful.SubConditions = WeightedStrings{}
+	ful.SubFulfillments = WeightedStrings{}
+	ful.derived = map[string]string{}
+
+	for i, e := range aux.SubFulfillments {
+		switch {
+		case e.Fulfillment != nil && e.Condition == nil:
+			sf, err := registry.UnmarshalFulfillmentJSON(e.Fulfillment)
+			if err != nil {
+				return conderr.InChild(i, err)
+			}
+			sc, err := registry.FulfillmentToCondition(sf)
+			if err != nil {
+				return conderr.InChild(i, err)
+			}
+			ful.SubFulfillments = append(ful.SubFulfillments, WeightedString{Weight: e.Weight, String: sf})
+			ful.SubConditions = append(ful.SubConditions, WeightedString{Weight: e.Weight, String: sc})
+			ful.derived[sf] = sc
+
+		case e.Condition != nil && e.Fulfillment == nil:
+			cond := &registry.Condition{}
+			if err := json.Unmarshal(e.Condition, cond); err != nil {
+				return conderr.InChild(i, err)
+			}
+			ful.SubConditions = append(ful.SubConditions, WeightedString{Weight: e.Weight, String: cond.Serialize()})
+
+		default:
+			return conderr.InChild(i, &conderr.ParseError{Type: TypeID, Err: &conderr.SyntaxError{Msg: "entries must have either a fulfillment or a condition"}})
+		}
+	}
+
+	return nil
+}
+
+// The condition is represented like any condition in the string format.
+func (cond *Condition) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&registry.Condition{
+		Type:                 TypeID,
+		Fingerprint:          cond.Hash[:],
+		MaxFulfillmentLength: cond.MaxFulfillmentLength,
+	})
+}
+
+func (cond *Condition) UnmarshalJSON(b []byte) error {
+	aux := &registry.Condition{}
+	if err := json.Unmarshal(b, aux); err != nil {
+		return err
+	}
+
+	if aux.Type != TypeID {
+		return &conderr.ParseError{Type: aux.Type, Err: conderr.ErrUnsupportedType}
+	}
+
+	if err := encoding.Bytes(aux.Fingerprint).Array(cond.Hash[:]); err != nil {
+		return err
+	}
+	cond.MaxFulfillmentLength = aux.MaxFulfillmentLength
+	return nil
+}
+
+// MarshalBinary writes the fulfillment in the binary format.
+func (ful *Fulfillment) MarshalBinary() ([]byte, error) {
+	payload, err := ful.payload()
+	if err != nil {
+		return nil, err
+	}
+
+	return registry.MakeBinaryFulfillment(TypeID, payload), nil
+}
+
+// UnmarshalBinary reads the fulfillment out of the binary format.
+func (ful *Fulfillment) UnmarshalBinary(b []byte) error {
+	typ, payload, err := registry.SplitBinaryFulfillment(b)
+	if err != nil {
+		return err
+	}
+
+	if typ != TypeID {
+		return &conderr.ParseError{Type: typ, Err: conderr.ErrUnsupportedType}
+	}
+
+	// The fulfillment must not refer to b
+	parsed, err := ParsePayload(append([]byte{}, payload...))
+	if err != nil {
+		return err
+	}
+
+	*ful = *parsed
+	return nil
+}
+
+// MarshalBinary writes the condition in the binary condition format.
+func (cond *Condition) MarshalBinary() ([]byte, error) {
+	c := &registry.Condition{
+		Type:                 TypeID,
+		Fingerprint:          cond.Hash[:],
+		MaxFulfillmentLength: cond.MaxFulfillmentLength,
+	}
+	return c.Binary(), nil
+}
+
+// UnmarshalBinary reads the condition out of the binary condition format.
+func (cond *Condition) UnmarshalBinary(b []byte) error {
+	c, err := registry.ParseBinaryCondition(b)
+	if err != nil {
+		return err
+	}
+
+	if c.Type != TypeID {
+		return &conderr.ParseError{Type: c.Type, Err: conderr.ErrUnsupportedType}
+	}
+
+	if err := encoding.Bytes(c.Fingerprint).Array(cond.Hash[:]); err != nil {
+		return err
+	}
+	cond.MaxFulfillmentLength = c.MaxFulfillmentLength
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jtremback/crypto-conditions/signer"
+)
+
+// Runs cc with the arguments and standard input, and returns the exit code
+// and the output
+func runCC(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code := run(args, strings.NewReader(stdin), stdout, stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestPrint(t *testing.T) {
+	code, ful, _ := runCC(t, "", "generate", "preimage", "-preimage", "hello")
+	if code != exitOK {
+		t.Fatal("generate failed", code)
+	}
+	ful = strings.TrimSpace(ful)
+
+	code, out, _ := runCC(t, "", "print", ful)
+	if code != exitOK || !strings.Contains(out, "Sha256 fulfillment") {
+		t.Fatal("print failed", code, out)
+	}
+
+	code, out, _ = runCC(t, "anything", "print", "-message", "-", ful)
+	if code != exitOK || !strings.Contains(out, "Sha256 fulfillment") {
+		t.Fatal("print with a message failed", code, out)
+	}
+
+	// Malformed fulfillments are reported, with or without a message
+	for _, args := range [][]string{
+		{"print", "cf:1:zz:AAAA"},
+		{"print", "-message", "-", "cf:1:zz:AAAA"},
+	} {
+		code, _, stderr := runCC(t, "", args...)
+		if code != exitInvalid || !strings.Contains(stderr, "cc:") {
+			t.Fatal("malformed fulfillment not reported", args, code, stderr)
+		}
+	}
+}
+
+// Writes a new Ed25519 key to a PEM file, and returns its path and contents
+func keyFile(t *testing.T) (string, string) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := signer.WriteKeyFile(path, key); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path, string(b)
+}
+
+func TestGenerate(t *testing.T) {
+	path, pem := keyFile(t)
+
+	for _, c := range []struct {
+		stdin  string
+		args   []string
+		prefix string
+	}{
+		{"", []string{"generate", "preimage", "-preimage", "hello"}, "cf:1:1:"},
+		{"", []string{"generate", "preimage", "-preimage-hex", "2a"}, "cf:1:1:"},
+		{"", []string{"generate", "ed25519", "-key-file", path, "-message", "hello"}, "cf:1:8:"},
+		{pem, []string{"generate", "ed25519", "-key-file", "-", "-message", "hello"}, "cf:1:8:"},
+		{`{"type":"preimage","preimage":"hello"}`, []string{"generate", "-json", "-"}, "cf:1:1:"},
+		{`{"type":"threshold","threshold":1,"entries":[{"weight":1,"type":"preimage","preimage":"hello"}]}`, []string{"generate", "-json", "-"}, "cf:1:4:"},
+	} {
+		code, out, stderr := runCC(t, c.stdin, c.args...)
+		if code != exitOK || !strings.HasPrefix(out, c.prefix) {
+			t.Fatal("generate failed", c.args, code, out, stderr)
+		}
+
+		// Generated fulfillments are valid
+		code, _, stderr = runCC(t, "", "validate", strings.TrimSpace(out))
+		if code != exitOK {
+			t.Fatal("generated fulfillment not valid", c.args, code, stderr)
+		}
+	}
+
+	// The same key signs the same fulfillment from a file and from stdin
+	_, fromFile, _ := runCC(t, "", "generate", "ed25519", "-key-file", path, "-message", "hello")
+	_, fromStdin, _ := runCC(t, pem, "generate", "ed25519", "-key-file", "-", "-message", "hello")
+	if fromFile != fromStdin {
+		t.Fatal("key file and stdin disagree", fromFile, fromStdin)
+	}
+
+	_, preimage, _ := runCC(t, "", "generate", "preimage", "-preimage", "hello")
+	code, out, stderr := runCC(t, "", "generate", "threshold", "-threshold", "1", "1:"+strings.TrimSpace(preimage))
+	if code != exitOK || !strings.HasPrefix(out, "cf:1:4:") {
+		t.Fatal("generate threshold failed", code, out, stderr)
+	}
+
+	for _, args := range [][]string{
+		{"generate"},
+		{"generate", "unknown"},
+		{"generate", "ed25519", "-key", "2a", "-message", "hello"},
+		{"generate", "threshold", "-threshold", "1", "hello"},
+	} {
+		if code, _, _ := runCC(t, "", args...); code != exitUsage {
+			t.Fatal("bad command line accepted", args, code)
+		}
+	}
+
+	// Keys are only read from files
+	for _, c := range []struct {
+		stdin string
+		args  []string
+	}{
+		{"", []string{"generate", "ed25519", "-message", "hello"}},
+		{"", []string{"generate", "ed25519", "-key-file", "-", "-message", "hello"}},
+		{`{"type":"ed25519","key":"2a","message":"hello"}`, []string{"generate", "-json", "-"}},
+	} {
+		if code, _, _ := runCC(t, c.stdin, c.args...); code != exitInvalid {
+			t.Fatal("fulfillment generated without a key file", c.args, code)
+		}
+	}
+}
+
+func TestCondition(t *testing.T) {
+	_, ful, _ := runCC(t, "", "generate", "preimage", "-preimage", "hello")
+	ful = strings.TrimSpace(ful)
+
+	code, cond, stderr := runCC(t, "", "condition", ful)
+	if code != exitOK || !strings.HasPrefix(cond, "cc:1:1:") {
+		t.Fatal("condition failed", code, cond, stderr)
+	}
+
+	if code, _, _ := runCC(t, "", "condition", "cf:1:zz:AAAA"); code != exitInvalid {
+		t.Fatal("malformed fulfillment accepted", code)
+	}
+	if code, _, _ := runCC(t, "", "condition"); code != exitUsage {
+		t.Fatal("missing fulfillment accepted", code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	_, ful, _ := runCC(t, "", "generate", "preimage", "-preimage", "hello")
+	ful = strings.TrimSpace(ful)
+	_, cond, _ := runCC(t, "", "condition", ful)
+	cond = strings.TrimSpace(cond)
+	_, other, _ := runCC(t, "", "generate", "preimage", "-preimage", "other")
+	other = strings.TrimSpace(other)
+
+	for _, args := range [][]string{
+		{"validate", ful},
+		{"validate", "-condition", cond, ful},
+		{"validate", "-max-cost", "5", ful},
+		{"validate", "-message", "-", ful},
+	} {
+		code, out, stderr := runCC(t, "message", args...)
+		if code != exitOK || out != "valid\n" {
+			t.Fatal("validate failed", args, code, out, stderr)
+		}
+	}
+
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"validate", "-condition", cond, other}, "fingerprint"},
+		{[]string{"validate", "-max-cost", "4", ful}, "cost"},
+		{[]string{"validate", "cf:1:zz:AAAA"}, "cc:"},
+	} {
+		code, _, stderr := runCC(t, "", c.args...)
+		if code != exitInvalid || !strings.Contains(stderr, c.err) {
+			t.Fatal("invalid fulfillment accepted", c.args, code, stderr)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"validate"},
+		{"validate", "-condition", cond, "-max-cost", "5", ful},
+	} {
+		if code, _, _ := runCC(t, "", args...); code != exitUsage {
+			t.Fatal("bad command line accepted", args, code)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/jtremback/crypto-conditions/ThresholdSha256"
+	"github.com/jtremback/crypto-conditions/ed25519sha256"
+	"github.com/jtremback/crypto-conditions/registry"
+	"github.com/jtremback/crypto-conditions/sha256"
+	"github.com/jtremback/crypto-conditions/signer"
+)
+
+// Description of a fulfillment to generate, as read by generate -json.
+// Threshold entries hold either a description, or an existing fulfillment
+// or condition string.
+type Spec struct {
+	Type string `json:"type"`
+
+	// Preimage
+	Preimage    string `json:"preimage,omitempty"`
+	PreimageHex string `json:"preimageHex,omitempty"`
+
+	// Ed25519, with the key in a PKCS #8 PEM file, read from standard input
+	// when it is "-"
+	KeyFile   string `json:"keyFile,omitempty"`
+	Message   string `json:"message,omitempty"`
+	MessageID string `json:"messageId,omitempty"`
+
+	// Threshold, keeping every subfulfillment unless a metric to minimize
+	// is given
+	Threshold uint32       `json:"threshold,omitempty"`
+	Minimize  string       `json:"minimize,omitempty"`
+	Entries   []*EntrySpec `json:"entries,omitempty"`
+}
+
+type EntrySpec struct {
+	Weight      uint32 `json:"weight"`
+	Fulfillment string `json:"fulfillment,omitempty"`
+	Condition   string `json:"condition,omitempty"`
+	*Spec
+}
+
+// Generates a fulfillment, and writes it to stdout
+func generate(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return &usageError{"expected a fulfillment type or -json"}
+	}
+
+	s := &Spec{Type: args[0]}
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	var threshold uint
+	var jsonFile string
+
+	switch s.Type {
+	case "preimage":
+		fs.StringVar(&s.Preimage, "preimage", "", "")
+		fs.StringVar(&s.PreimageHex, "preimage-hex", "", "")
+	case "ed25519":
+		fs.StringVar(&s.KeyFile, "key-file", "", "")
+		fs.StringVar(&s.Message, "message", "", "")
+		fs.StringVar(&s.MessageID, "message-id", "", "")
+	case "threshold":
+		fs.UintVar(&threshold, "threshold", 0, "")
+		fs.StringVar(&s.Minimize, "minimize", "", "")
+	default:
+		// The fulfillment is described in a JSON file instead
+		fs.StringVar(&jsonFile, "json", "", "")
+		s.Type = ""
+	}
+
+	if s.Type != "" {
+		args = args[1:]
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return &usageError{err.Error()}
+	}
+
+	if s.Type == "threshold" {
+		s.Threshold = uint32(threshold)
+		for _, arg := range fs.Args() {
+			e, err := parseEntry(arg)
+			if err != nil {
+				return err
+			}
+			s.Entries = append(s.Entries, e)
+		}
+	} else if fs.NArg() != 0 {
+		return &usageError{"unexpected argument " + fs.Arg(0)}
+	}
+
+	if s.Type == "" {
+		if jsonFile == "" {
+			return &usageError{"unknown fulfillment type " + args[0]}
+		}
+
+		b, err := readInput(jsonFile, stdin)
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal(b, s); err != nil {
+			return err
+		}
+	}
+
+	ful, err := s.build(stdin)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(stdout, ful.Serialize())
+	return nil
+}
+
+// Parses a WEIGHT:STRING threshold entry
+func parseEntry(arg string) (*EntrySpec, error) {
+	parts := strings.SplitN(arg, ":", 2)
+	if len(parts) != 2 {
+		return nil, &usageError{"threshold entries must be WEIGHT:STRING"}
+	}
+
+	weight, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return nil, &usageError{"bad weight " + parts[0]}
+	}
+
+	e := &EntrySpec{Weight: uint32(weight)}
+	if strings.HasPrefix(parts[1], "cc:") {
+		e.Condition = parts[1]
+	} else {
+		e.Fulfillment = parts[1]
+	}
+
+	return e, nil
+}
+
+// Builds the described fulfillment, reading key files of "-" from stdin
+func (s *Spec) build(stdin io.Reader) (registry.Fulfillment, error) {
+	switch s.Type {
+	case "preimage":
+		preimage := []byte(s.Preimage)
+		if s.PreimageHex != "" {
+			b, err := hex.DecodeString(s.PreimageHex)
+			if err != nil {
+				return nil, err
+			}
+			preimage = b
+		}
+
+		return &Sha256.Fulfillment{Preimage: preimage}, nil
+
+	case "ed25519":
+		ful := &Ed25519Sha256.Fulfillment{
+			MessageId:    []byte(s.MessageID),
+			FixedMessage: []byte(s.Message),
+		}
+
+		if s.KeyFile == "" {
+			return nil, errors.New("ed25519 fulfillments need a key file")
+		}
+
+		b, err := readInput(s.KeyFile, stdin)
+		if err != nil {
+			return nil, err
+		}
+		defer zero(b)
+
+		key, err := signer.ParseKey(b)
+		if err != nil {
+			return nil, err
+		}
+		defer zero(key)
+
+		if err := ful.SignWith(key); err != nil {
+			return nil, err
+		}
+
+		return ful, nil
+
+	case "threshold":
+		ful := &ThresholdSha256.Fulfillment{
+			Threshold: s.Threshold,
+		}
+
+		for _, e := range s.Entries {
+			ws, err := e.build(stdin)
+			if err != nil {
+				return nil, err
+			}
+
+			if strings.HasPrefix(ws.String, "cc:") {
+				ful.SubConditions = append(ful.SubConditions, ws)
+			} else {
+				ful.SubFulfillments = append(ful.SubFulfillments, ws)
+			}
+		}
+
+		switch s.Minimize {
+		case "":
+		case "cost":
+			err := ful.Minimize(ThresholdSha256.MinCost)
+			if err != nil {
+				return nil, err
+			}
+		case "size":
+			err := ful.Minimize(ThresholdSha256.MinSize)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.New("can only minimize cost or size")
+		}
+
+		return ful, nil
+
+	default:
+		return nil, errors.New("unknown fulfillment type " + s.Type)
+	}
+}
+
+// Turns the entry into the string it stands for
+func (e *EntrySpec) build(stdin io.Reader) (ThresholdSha256.WeightedString, error) {
+	ws := ThresholdSha256.WeightedString{Weight: e.Weight}
+
+	switch {
+	case e.Condition != "":
+		if _, err := registry.ParseCondition(e.Condition); err != nil {
+			return ws, err
+		}
+		ws.String = e.Condition
+	case e.Fulfillment != "":
+		if _, err := registry.FulfillmentToCondition(e.Fulfillment); err != nil {
+			return ws, err
+		}
+		ws.String = e.Fulfillment
+	case e.Spec != nil:
+		ful, err := e.Spec.build(stdin)
+		if err != nil {
+			return ws, err
+		}
+		ws.String = ful.Serialize()
+	default:
+		return ws, errors.New("threshold entries need a fulfillment, a condition or a type")
+	}
+
+	return ws, nil
+}
+
+// Overwrites key material once it is no longer needed
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
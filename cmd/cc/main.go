@@ -0,0 +1,123 @@
+// Command cc generates, inspects and validates Crypto Conditions in the
+// string format.
+//
+// Usage:
+//
+//	cc generate preimage -preimage TEXT | -preimage-hex HEX
+//	cc generate ed25519 -key-file FILE -message TEXT [-message-id TEXT]
+//	cc generate threshold -threshold N [-minimize cost|size] WEIGHT:STRING...
+//	cc generate -json FILE
+//	cc condition FULFILLMENT
+//	cc validate [-condition CONDITION | -max-cost N] [-message FILE] FULFILLMENT
+//	cc print [-message FILE] STRING
+//
+// FILE is read from standard input when it is "-".
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/jtremback/crypto-conditions/entry"
+)
+
+const usage = `usage:
+  cc generate preimage -preimage TEXT | -preimage-hex HEX
+  cc generate ed25519 -key-file FILE -message TEXT [-message-id TEXT]
+  cc generate threshold -threshold N [-minimize cost|size] WEIGHT:STRING...
+  cc generate -json FILE
+  cc condition FULFILLMENT
+  cc validate [-condition CONDITION | -max-cost N] [-message FILE] FULFILLMENT
+  cc print [-message FILE] STRING
+
+FILE is read from standard input when it is "-".
+`
+
+// Exit codes
+const (
+	exitOK      = 0
+	exitInvalid = 1
+	exitUsage   = 2
+)
+
+// Thrown by the subcommands for malformed command lines
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	var err error
+	switch args[0] {
+	case "generate":
+		err = generate(args[1:], stdin, stdout)
+	case "condition":
+		err = condition(args[1:], stdout)
+	case "validate":
+		err = validate(args[1:], stdin, stdout)
+	case "print":
+		err = printTree(args[1:], stdin, stdout)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		err = &usageError{"unknown command " + args[0]}
+	}
+
+	if err == nil {
+		return exitOK
+	}
+
+	fmt.Fprintln(stderr, "cc:", err)
+	if _, ok := err.(*usageError); ok {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	return exitInvalid
+}
+
+// Returns the only positional argument
+func oneArg(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", &usageError{"expected one argument"}
+	}
+	return args[0], nil
+}
+
+// Reads a file, or standard input if the name is "-"
+func readInput(name string, stdin io.Reader) ([]byte, error) {
+	if name == "-" {
+		return ioutil.ReadAll(stdin)
+	}
+	return ioutil.ReadFile(name)
+}
+
+// Derives the condition of a fulfillment
+func condition(args []string, stdout io.Writer) error {
+	ful, err := oneArg(args)
+	if err != nil {
+		return err
+	}
+
+	cond, err := entry.FulfillmentToCondition(ful)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(stdout, cond)
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"io/ioutil"
+
+	"github.com/jtremback/crypto-conditions/entry"
+)
+
+// Pretty-prints a fulfillment or condition as a tree, validating each
+// fulfillment in it if a message is given
+func printTree(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("print", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	messageFile := fs.String("message", "", "")
+
+	if err := fs.Parse(args); err != nil {
+		return &usageError{err.Error()}
+	}
+
+	s, err := oneArg(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	var node *entry.Node
+	if *messageFile != "" {
+		var message []byte
+		message, err = readInput(*messageFile, stdin)
+		if err != nil {
+			return err
+		}
+		node, err = entry.ExplainValidation(s, message)
+	} else {
+		node, err = entry.Explain(s)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := node.Dump(stdout); err != nil {
+		return err
+	}
+
+	return node.Err
+}
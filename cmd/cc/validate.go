@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/jtremback/crypto-conditions/entry"
+)
+
+// Validates a fulfillment against a message, and optionally a condition or
+// a maximum cost
+func validate(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	cond := fs.String("condition", "", "")
+	messageFile := fs.String("message", "", "")
+	maxCost := fs.Uint64("max-cost", 0, "")
+
+	if err := fs.Parse(args); err != nil {
+		return &usageError{err.Error()}
+	}
+
+	if *cond != "" && *maxCost != 0 {
+		return &usageError{"a condition and a maximum cost can't both be given"}
+	}
+
+	ful, err := oneArg(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	message := []byte{}
+	if *messageFile != "" {
+		message, err = readInput(*messageFile, stdin)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case *cond != "":
+		err = entry.ValidateFulfillment(*cond, ful, message)
+	case *maxCost != 0:
+		// The cost is checked before any signature
+		err = entry.ValidateWithMaxCost(ful, message, *maxCost)
+	default:
+		err = entry.Validate(ful, message)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(stdout, "valid")
+	return nil
+}
@@ -0,0 +1,280 @@
+// Error values and validation limits shared by the Crypto Conditions
+// packages
+//
+// Every error returned while parsing or validating a fulfillment or
+// condition matches one of the sentinel errors below with errors.Is. Where
+// the failing fulfillment is known, the error is a *ParseError or a
+// *ValidationError carrying its type and position, which can be retrieved
+// with errors.As.
+package conderr
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	// The input is not a well-formed fulfillment or condition.
+	ErrMalformed = errors.New("malformed input")
+	// The type is not registered, or not supported where it is used.
+	ErrUnsupportedType = errors.New("unsupported condition type")
+	// A signature doesn't verify against its public key and message.
+	ErrInvalidSignature = errors.New("signature not valid")
+	// The valid subfulfillments of a threshold don't add up to the
+	// threshold.
+	ErrThresholdNotMet = errors.New("not enough fulfillments")
+	// The message is longer than a prefix fulfillment allows.
+	ErrMessageTooLong = errors.New("message is longer than the maximum message length")
+	// The cost of the fulfillment is above the maximum cost.
+	ErrCostExceeded = errors.New("fulfillment cost exceeds the maximum cost")
+	// A fulfillment doesn't match the condition it is validated against.
+	ErrConditionMismatch = errors.New("fulfillment doesn't match condition")
+	// Parsing or validation was stopped because its context was cancelled
+	// or its deadline passed. This says nothing about the fulfillment.
+	ErrCanceled = errors.New("validation canceled")
+	// The fulfillment is nested too deeply, has too many subfulfillments or
+	// is too large to be accepted.
+	ErrLimitExceeded = errors.New("fulfillment exceeds a limit")
+	// The fulfillment has no equivalent in the format it is converted to.
+	ErrNotConvertible = errors.New("fulfillment can't be converted")
+)
+
+// Parts of a condition that can mismatch
+const (
+	FieldType        = "type"
+	FieldFingerprint = "fingerprint"
+	FieldSubtypes    = "subtypes"
+	FieldCost        = "cost"
+	FieldLength      = "max fulfillment length"
+)
+
+// Limits that a fulfillment can exceed
+const (
+	LimitDepth        = "nesting depth"
+	LimitChildren     = "entries in a threshold"
+	LimitDecodedBytes = "decoded bytes"
+)
+
+// LimitError describes which limit a fulfillment exceeds. It is found while
+// parsing, before any signature is checked.
+type LimitError struct {
+	Limit string
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return ErrLimitExceeded.Error() + ": more than " + strconv.Itoa(e.Max) + " " + e.Limit
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// MismatchError describes which part of the condition derived from a
+// fulfillment doesn't match the expected condition.
+type MismatchError struct {
+	Field    string
+	Expected string
+	Actual   string
+}
+
+func (e *MismatchError) Error() string {
+	return ErrConditionMismatch.Error() + ": " + e.Field + " is " + e.Actual + ", expected " + e.Expected
+}
+
+func (e *MismatchError) Is(target error) bool {
+	return target == ErrConditionMismatch
+}
+
+// SyntaxError describes malformed input found before the type of the
+// fulfillment or condition is known, such as in the encoding package or in
+// the header of the string format. Offset is relative to the start of the
+// input handed to the failing function.
+type SyntaxError struct {
+	Offset int
+	// Position of the failing fulfillment within the enclosing ones, see
+	// InChild
+	Path []int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return formatPath(e.Path) + e.Msg
+}
+
+func (e *SyntaxError) Is(target error) bool {
+	return target == ErrMalformed
+}
+
+// ParseError describes a fulfillment or condition of a known type that
+// couldn't be parsed. Type is numbered as in the format being parsed, and
+// Offset is relative to the start of the payload of the failing fulfillment.
+type ParseError struct {
+	Type   uint16
+	Offset int
+	// Position of the failing fulfillment within the enclosing ones, see
+	// InChild
+	Path []int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return formatPath(e.Path) + "type " + strconv.Itoa(int(e.Type)) + " at byte " + strconv.Itoa(e.Offset) + ": " + e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError describes a well-formed fulfillment that isn't valid.
+// Type is numbered as in the format of the fulfillment.
+type ValidationError struct {
+	Type uint16
+	// Position of the failing fulfillment within the enclosing ones, see
+	// InChild
+	Path []int
+	Err  error
+}
+
+func (e *ValidationError) Error() string {
+	return formatPath(e.Path) + "type " + strconv.Itoa(int(e.Type)) + ": " + e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// CanceledError is returned by the context-aware functions when their
+// context is done before they finish. Err is the error of the context, so
+// errors.Is also matches context.Canceled or context.DeadlineExceeded.
+type CanceledError struct {
+	Err error
+}
+
+func (e *CanceledError) Error() string {
+	return ErrCanceled.Error() + ": " + e.Err.Error()
+}
+
+func (e *CanceledError) Is(target error) bool {
+	return target == ErrCanceled
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// Canceled returns a *CanceledError if ctx is done, and nil otherwise. It is
+// checked before each fulfillment, and between subfulfillments.
+func Canceled(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return &CanceledError{Err: err}
+	}
+
+	return nil
+}
+
+// ThresholdError describes a threshold whose valid subfulfillments don't
+// add up to the threshold. Failed holds the errors of the subfulfillments
+// that were found invalid, with their index in the path. Validation may stop
+// before every subfulfillment is checked, once the threshold can't be met.
+type ThresholdError struct {
+	Threshold uint64
+	// Weight of the subfulfillments found valid
+	Weight uint64
+	Failed []error
+}
+
+func (e *ThresholdError) Error() string {
+	msg := ErrThresholdNotMet.Error() + ": weight " + strconv.FormatUint(e.Weight, 10) + " of " + strconv.FormatUint(e.Threshold, 10)
+
+	failed := []string{}
+	for _, err := range e.Failed {
+		failed = append(failed, err.Error())
+	}
+	if len(failed) > 0 {
+		msg += " (" + strings.Join(failed, "; ") + ")"
+	}
+
+	return msg
+}
+
+func (e *ThresholdError) Is(target error) bool {
+	return target == ErrThresholdNotMet
+}
+
+func (e *ThresholdError) Unwrap() []error {
+	return e.Failed
+}
+
+// AtOffset moves a *SyntaxError found in part of the input to its offset in
+// the whole input, given the offset of the part. Other errors are returned
+// as they are.
+func AtOffset(offset int, err error) error {
+	if e, ok := err.(*SyntaxError); ok {
+		c := *e
+		c.Offset += offset
+		return &c
+	}
+
+	return err
+}
+
+// NewParseError describes an error found while parsing a fulfillment of the
+// given type, in the part of the payload starting at offset. Syntax errors
+// are moved to their offset in the payload, and errors that already describe
+// a fulfillment are returned as they are.
+func NewParseError(typ uint16, offset int, err error) error {
+	switch e := err.(type) {
+	case *ParseError, *ValidationError:
+		return err
+	case *SyntaxError:
+		err = AtOffset(offset, e)
+		offset = err.(*SyntaxError).Offset
+	}
+
+	return &ParseError{
+		Type:   typ,
+		Offset: offset,
+		Err:    err,
+	}
+}
+
+// InChild records that err was returned by the child with the given index,
+// by prepending the index to the path of the error. The index of a child is
+// its position in the encoding of the parent: the position among the
+// entries of a threshold, or 0 for the subfulfillment of a prefix. Errors
+// without a path are returned as they are.
+func InChild(index int, err error) error {
+	switch e := err.(type) {
+	case *SyntaxError:
+		c := *e
+		c.Path = append([]int{index}, e.Path...)
+		return &c
+	case *ParseError:
+		c := *e
+		c.Path = append([]int{index}, e.Path...)
+		return &c
+	case *ValidationError:
+		c := *e
+		c.Path = append([]int{index}, e.Path...)
+		return &c
+	}
+
+	return err
+}
+
+// Describes the path at the start of an error message
+func formatPath(path []int) string {
+	if len(path) == 0 {
+		return ""
+	}
+
+	indexes := []string{}
+	for _, i := range path {
+		indexes = append(indexes, strconv.Itoa(i))
+	}
+
+	return "subfulfillment " + strings.Join(indexes, ".") + ": "
+}
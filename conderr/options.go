@@ -0,0 +1,52 @@
+package conderr
+
+// Default limits of ValidationOptions
+const (
+	DefaultMaxDepth        = 32
+	DefaultMaxChildren     = 1024
+	DefaultMaxDecodedBytes = 1 << 22
+)
+
+// ValidationOptions limits the fulfillments that are parsed and validated,
+// so that crafted ones can't exhaust the stack or the memory. They are
+// shared by the der package and the registry of the string and binary
+// formats. The limits are enforced while parsing, before any signature is
+// checked, and exceeding one gives a *LimitError. Fields left at zero take
+// the default value, and the functions without options use the defaults.
+type ValidationOptions struct {
+	// Levels of subfulfillments below the fulfillment
+	MaxDepth int
+	// Entries of a threshold, subfulfillments and subconditions together
+	MaxChildren int
+	// Bytes decoded over the whole fulfillment. In the string and binary
+	// formats, this is the total length of the payloads of the fulfillment
+	// and its subfulfillments, each counted once however often it is
+	// decoded.
+	MaxDecodedBytes int
+
+	// Subfulfillments validated at a time, across the whole fulfillment.
+	// Zero or one validates them one after the other. Only the thresholds
+	// of the string and binary formats validate concurrently.
+	Workers int
+}
+
+// WithDefaults returns the options with zero fields set to their default.
+// A nil *ValidationOptions gives the defaults.
+func (opts *ValidationOptions) WithDefaults() ValidationOptions {
+	o := ValidationOptions{}
+	if opts != nil {
+		o = *opts
+	}
+
+	if o.MaxDepth == 0 {
+		o.MaxDepth = DefaultMaxDepth
+	}
+	if o.MaxChildren == 0 {
+		o.MaxChildren = DefaultMaxChildren
+	}
+	if o.MaxDecodedBytes == 0 {
+		o.MaxDecodedBytes = DefaultMaxDecodedBytes
+	}
+
+	return o
+}
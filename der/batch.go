@@ -0,0 +1,99 @@
+package der
+
+import (
+	"context"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/ed25519batch"
+)
+
+// Checks the signature of an Ed25519 fulfillment while validating a tree,
+// so that the signatures can be verified in a batch instead
+type ed25519Check func(ful *Ed25519Sha256, message []byte) error
+
+func verifyEd25519(ful *Ed25519Sha256, message []byte) error {
+	return ful.Validate(message)
+}
+
+// Validates the fulfillment, checking Ed25519 signatures with check, and
+// stopping once ctx is done
+func validate(ctx context.Context, ful Fulfillment, message []byte, check ed25519Check) error {
+	if err := conderr.Canceled(ctx); err != nil {
+		return err
+	}
+
+	switch ful := ful.(type) {
+	case *PrefixSha256:
+		return ful.validate(ctx, message, check)
+	case *ThresholdSha256:
+		return ful.validate(ctx, message, check)
+	case *Ed25519Sha256:
+		return check(ful, message)
+	default:
+		return ful.Validate(message)
+	}
+}
+
+// An Ed25519 fulfillment along with the message it signs
+type signed struct {
+	ful     *Ed25519Sha256
+	message string
+}
+
+// Adds the Ed25519 signatures that validating the fulfillment could check
+// to the batch, recording their index in it
+func collect(ful Fulfillment, message []byte, v *ed25519batch.Verifier, batched map[signed]int) {
+	switch ful := ful.(type) {
+	case *PrefixSha256:
+		if uint64(len(message)) <= ful.MaxMessageLength {
+			collect(ful.SubFulfillment, append(append([]byte{}, ful.Prefix...), message...), v, batched)
+		}
+	case *ThresholdSha256:
+		for _, sf := range ful.SubFulfillments {
+			collect(sf, message, v, batched)
+		}
+	case *Ed25519Sha256:
+		key := signed{ful: ful, message: string(message)}
+		if _, ok := batched[key]; !ok {
+			batched[key] = v.Len()
+			v.Add(&ful.PublicKey, message, &ful.Signature)
+		}
+	}
+}
+
+// ValidateBatch checks each fulfillment for validity against the message of
+// the same index, like their Validate method, and returns an error for
+// each, all of them failing if there isn't exactly one message per
+// fulfillment. The Ed25519 signatures of all the fulfillments are collected
+// first and verified by an ed25519batch.Verifier, each once however many
+// fulfillments check it. The fulfillments are then validated once, with the
+// results of the batch.
+func ValidateBatch(fulfillments []Fulfillment, messages [][]byte) []error {
+	errs := make([]error, len(fulfillments))
+
+	if len(messages) != len(fulfillments) {
+		for i := range errs {
+			errs[i] = &conderr.SyntaxError{Msg: "there must be one message per fulfillment"}
+		}
+		return errs
+	}
+
+	v := &ed25519batch.Verifier{}
+	batched := map[signed]int{}
+	for i, ful := range fulfillments {
+		collect(ful, messages[i], v, batched)
+	}
+
+	valid := v.Verify()
+
+	for i, ful := range fulfillments {
+		errs[i] = validate(context.Background(), ful, messages[i], func(ful *Ed25519Sha256, message []byte) error {
+			if !valid[batched[signed{ful: ful, message: string(message)}]] {
+				return &conderr.ValidationError{Type: TypeEd25519Sha256, Err: conderr.ErrInvalidSignature}
+			}
+			return nil
+		})
+	}
+
+	return errs
+}
@@ -0,0 +1,396 @@
+// Encodes and decodes Crypto Conditions in the ASN.1 DER format of the
+// final crypto-conditions draft, as used by Interledger, BigchainDB and
+// Five Bells.
+//
+// Only fulfillments in this format can be validated against a message read
+// from an io.Reader, with ValidateReader and the functions around it; the
+// cf: strings of the registry have no streaming counterpart.
+package der
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"sort"
+	"strconv"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/encoding"
+)
+
+// Types, as numbered in the Condition and Fulfillment CHOICEs
+const (
+	TypePreimageSha256  = 0
+	TypePrefixSha256    = 1
+	TypeThresholdSha256 = 2
+	TypeRsaSha256       = 3
+	TypeEd25519Sha256   = 4
+)
+
+// Compound types have subconditions, and list their types in the condition
+func isCompound(typ uint16) bool {
+	return typ == TypePrefixSha256 || typ == TypeThresholdSha256
+}
+
+type Condition struct {
+	Type        uint16
+	Fingerprint []byte
+	Cost        uint64
+	// Bitmask of the types of all subconditions, with bit n set for type n
+	Subtypes uint32
+}
+
+// Encode returns the DER encoding of the condition.
+func (cond *Condition) Encode() []byte {
+	fields := [][]byte{
+		encoding.MakeDER(encoding.DERContext|0, cond.Fingerprint),
+		encoding.MakeDER(encoding.DERContext|1, encoding.MakeDERUint(cond.Cost)),
+	}
+
+	if isCompound(cond.Type) {
+		fields = append(fields, encoding.MakeDER(encoding.DERContext|2, encoding.MakeDERBitString(cond.Subtypes)))
+	}
+
+	return encoding.MakeDER(choiceTag(cond.Type), bytes.Join(fields, []byte{}))
+}
+
+// ParseCondition parses a DER encoded condition.
+func ParseCondition(b []byte) (*Condition, error) {
+	typ, content, err := getChoice(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCondition(typ, content)
+}
+
+func parseCondition(typ uint16, content []byte) (*Condition, error) {
+	if typ > TypeEd25519Sha256 {
+		return nil, &conderr.ParseError{Type: typ, Err: conderr.ErrUnsupportedType}
+	}
+
+	fingerprint, b, err := getField(content, 0)
+	if err != nil {
+		return nil, conderr.NewParseError(typ, 0, err)
+	}
+
+	if len(fingerprint) != 32 {
+		return nil, conderr.NewParseError(typ, 0, &conderr.SyntaxError{Msg: "fingerprint must be 32 bytes"})
+	}
+
+	c, b, err := getField(b, 1)
+	if err != nil {
+		return nil, conderr.NewParseError(typ, len(content)-len(b), err)
+	}
+
+	cost, err := encoding.GetDERUint(c)
+	if err != nil {
+		return nil, conderr.NewParseError(typ, len(content)-len(b)-len(c), err)
+	}
+
+	if cost > 0xffffffff {
+		return nil, conderr.NewParseError(typ, len(content)-len(b)-len(c), &conderr.SyntaxError{Msg: "cost is too large"})
+	}
+
+	cond := &Condition{
+		Type:        typ,
+		Fingerprint: fingerprint,
+		Cost:        cost,
+	}
+
+	if isCompound(typ) {
+		s, rest, err := getField(b, 2)
+		if err != nil {
+			return nil, conderr.NewParseError(typ, len(content)-len(b), err)
+		}
+
+		cond.Subtypes, err = encoding.GetDERBitString(s)
+		if err != nil {
+			return nil, conderr.NewParseError(typ, len(content)-len(rest)-len(s), err)
+		}
+		b = rest
+	}
+
+	if len(b) != 0 {
+		return nil, conderr.NewParseError(typ, len(content)-len(b), &conderr.SyntaxError{Msg: "trailing data in condition"})
+	}
+
+	return cond, nil
+}
+
+type Fulfillment interface {
+	// Derives the condition that the fulfillment fulfills.
+	Condition() (*Condition, error)
+	// Returns the DER encoding of the fulfillment.
+	Encode() ([]byte, error)
+	// Checks the fulfillment for validity against a message.
+	Validate(message []byte) error
+}
+
+// ParseFulfillment parses a DER encoded fulfillment of any type.
+func ParseFulfillment(b []byte) (Fulfillment, error) {
+	return ParseFulfillmentContext(context.Background(), b)
+}
+
+// ParseFulfillmentContext is ParseFulfillment, stopped with a
+// *conderr.CanceledError once ctx is done.
+func ParseFulfillmentContext(ctx context.Context, b []byte) (Fulfillment, error) {
+	return ParseFulfillmentWithOptions(ctx, b, nil)
+}
+
+// ParseFulfillmentWithOptions is ParseFulfillmentContext, rejecting
+// fulfillments that exceed the limits of opts with a *conderr.LimitError.
+func ParseFulfillmentWithOptions(ctx context.Context, b []byte, opts *conderr.ValidationOptions) (Fulfillment, error) {
+	p := &parser{ctx: ctx, opts: opts.WithDefaults()}
+
+	if len(b) > p.opts.MaxDecodedBytes {
+		return nil, &conderr.LimitError{Limit: conderr.LimitDecodedBytes, Max: p.opts.MaxDecodedBytes}
+	}
+
+	return p.fulfillment(b)
+}
+
+// State shared by a fulfillment and its subfulfillments while parsing
+type parser struct {
+	ctx  context.Context
+	opts conderr.ValidationOptions
+	// Levels of subfulfillments above the one being parsed
+	depth int
+}
+
+func (p *parser) fulfillment(b []byte) (Fulfillment, error) {
+	if err := conderr.Canceled(p.ctx); err != nil {
+		return nil, err
+	}
+
+	typ, content, err := getChoice(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.depth > p.opts.MaxDepth {
+		return nil, &conderr.ParseError{Type: typ, Err: &conderr.LimitError{Limit: conderr.LimitDepth, Max: p.opts.MaxDepth}}
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+
+	switch typ {
+	case TypePreimageSha256:
+		return parsePreimageSha256(content)
+	case TypePrefixSha256:
+		return p.prefixSha256(content)
+	case TypeThresholdSha256:
+		return p.thresholdSha256(content)
+	case TypeRsaSha256:
+		return parseRsaSha256(content)
+	case TypeEd25519Sha256:
+		return parseEd25519Sha256(content)
+	default:
+		return nil, &conderr.ParseError{Type: typ, Err: conderr.ErrUnsupportedType}
+	}
+}
+
+// Validate parses a DER encoded fulfillment and checks it for validity
+// against a message.
+func Validate(b []byte, message []byte) error {
+	return ValidateContext(context.Background(), b, message)
+}
+
+// ValidateContext is Validate, stopped with a *conderr.CanceledError once
+// ctx is done.
+func ValidateContext(ctx context.Context, b []byte, message []byte) error {
+	return ValidateWithOptions(ctx, b, message, nil)
+}
+
+// ValidateWithOptions is ValidateContext, rejecting fulfillments that exceed
+// the limits of opts with a *conderr.LimitError.
+func ValidateWithOptions(ctx context.Context, b []byte, message []byte, opts *conderr.ValidationOptions) error {
+	ful, err := ParseFulfillmentWithOptions(ctx, b, opts)
+	if err != nil {
+		return err
+	}
+
+	return validate(ctx, ful, message, verifyEd25519)
+}
+
+// ValidateWithMaxCost parses a DER encoded fulfillment and checks it for
+// validity against a message. Fulfillments whose condition costs more than
+// maxCost are rejected before any signature is checked. Passing the cost of
+// the expected condition enforces the condition's cost.
+func ValidateWithMaxCost(b []byte, message []byte, maxCost uint64) error {
+	return ValidateWithMaxCostContext(context.Background(), b, message, maxCost)
+}
+
+// ValidateWithMaxCostContext is ValidateWithMaxCost, stopped with a
+// *conderr.CanceledError once ctx is done.
+func ValidateWithMaxCostContext(ctx context.Context, b []byte, message []byte, maxCost uint64) error {
+	ful, err := ParseFulfillmentContext(ctx, b)
+	if err != nil {
+		return err
+	}
+
+	cond, err := ful.Condition()
+	if err != nil {
+		return err
+	}
+
+	if cond.Cost > maxCost {
+		return &conderr.ValidationError{Type: cond.Type, Err: conderr.ErrCostExceeded}
+	}
+
+	return validate(ctx, ful, message, verifyEd25519)
+}
+
+// ValidateFulfillment parses a DER encoded fulfillment, checks that it
+// matches the condition, and that it is valid against the message. The
+// type, fingerprint, subtypes and cost must all match, otherwise a
+// *conderr.MismatchError describes which part doesn't.
+func ValidateFulfillment(cond *Condition, b []byte, message []byte) error {
+	return ValidateFulfillmentContext(context.Background(), cond, b, message)
+}
+
+// ValidateFulfillmentContext is ValidateFulfillment, stopped with a
+// *conderr.CanceledError once ctx is done.
+func ValidateFulfillmentContext(ctx context.Context, cond *Condition, b []byte, message []byte) error {
+	ful, err := ParseFulfillmentContext(ctx, b)
+	if err != nil {
+		return err
+	}
+
+	if err := matchCondition(cond, ful); err != nil {
+		return err
+	}
+
+	return validate(ctx, ful, message, verifyEd25519)
+}
+
+// Checks that the fulfillment fulfills the condition, regardless of the
+// message
+func matchCondition(cond *Condition, ful Fulfillment) error {
+	derived, err := ful.Condition()
+	if err != nil {
+		return err
+	}
+
+	if derived.Type != cond.Type {
+		return &conderr.MismatchError{
+			Field:    conderr.FieldType,
+			Expected: typeName(cond.Type),
+			Actual:   typeName(derived.Type),
+		}
+	}
+
+	if !bytes.Equal(derived.Fingerprint, cond.Fingerprint) {
+		return &conderr.MismatchError{
+			Field:    conderr.FieldFingerprint,
+			Expected: base64.RawURLEncoding.EncodeToString(cond.Fingerprint),
+			Actual:   base64.RawURLEncoding.EncodeToString(derived.Fingerprint),
+		}
+	}
+
+	if derived.Subtypes != cond.Subtypes {
+		return &conderr.MismatchError{
+			Field:    conderr.FieldSubtypes,
+			Expected: formatSubtypes(cond.Subtypes),
+			Actual:   formatSubtypes(derived.Subtypes),
+		}
+	}
+
+	if derived.Cost != cond.Cost {
+		return &conderr.MismatchError{
+			Field:    conderr.FieldCost,
+			Expected: strconv.FormatUint(cond.Cost, 10),
+			Actual:   strconv.FormatUint(derived.Cost, 10),
+		}
+	}
+
+	return nil
+}
+
+// Name of the type, or its number if it is unknown
+func typeName(typ uint16) string {
+	if int(typ) < len(TypeNames) {
+		return TypeNames[typ]
+	}
+	return strconv.Itoa(int(typ))
+}
+
+// Tag of a type in the Condition and Fulfillment CHOICEs
+func choiceTag(typ uint16) byte {
+	return encoding.DERContext | encoding.DERConstructed | byte(typ)
+}
+
+// Reads a complete Condition or Fulfillment CHOICE, and returns its type
+func getChoice(b []byte) (uint16, []byte, error) {
+	tag, content, rest, err := encoding.GetDER(b)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(rest) != 0 {
+		return 0, nil, &conderr.SyntaxError{Offset: len(b) - len(rest), Msg: "trailing data after DER value"}
+	}
+
+	if tag&^0x1f != encoding.DERContext|encoding.DERConstructed {
+		return 0, nil, &conderr.SyntaxError{Msg: "unexpected DER tag"}
+	}
+
+	return uint16(tag & 0x1f), content, nil
+}
+
+// Reads the primitive context-specific field with the given number. On
+// error, the remainder is the unread input.
+func getField(b []byte, n byte) ([]byte, []byte, error) {
+	tag, content, rest, err := encoding.GetDER(b)
+	if err != nil {
+		return nil, b, err
+	}
+
+	if tag != encoding.DERContext|n {
+		return nil, b, &conderr.SyntaxError{Msg: "unexpected DER tag"}
+	}
+
+	return content, rest, nil
+}
+
+// Reads the constructed context-specific field with the given number. On
+// error, the remainder is the unread input.
+func getConstructedField(b []byte, n byte) ([]byte, []byte, error) {
+	tag, content, rest, err := encoding.GetDER(b)
+	if err != nil {
+		return nil, b, err
+	}
+
+	if tag != encoding.DERContext|encoding.DERConstructed|n {
+		return nil, b, &conderr.SyntaxError{Msg: "unexpected DER tag"}
+	}
+
+	return content, rest, nil
+}
+
+// Splits the content of a SET OF into its elements
+func getSetOf(set []byte) ([][]byte, error) {
+	items := [][]byte{}
+	b := set
+	for len(b) > 0 {
+		_, _, rest, err := encoding.GetDER(b)
+		if err != nil {
+			return nil, conderr.AtOffset(len(set)-len(b), err)
+		}
+		items = append(items, b[:len(b)-len(rest)])
+		b = rest
+	}
+
+	return items, nil
+}
+
+// Sorts encodings by length, and lexicographically if the lengths are
+// equal, matching the reference implementations.
+func sortEncodings(items [][]byte) {
+	sort.Slice(items, func(i, j int) bool {
+		if len(items[i]) == len(items[j]) {
+			return bytes.Compare(items[i], items[j]) < 0
+		}
+		return len(items[i]) < len(items[j])
+	})
+}
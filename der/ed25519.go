@@ -0,0 +1,97 @@
+package der
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+
+	"github.com/agl/ed25519"
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/encoding"
+	"github.com/jtremback/crypto-conditions/signer"
+)
+
+// Cost of an Ed25519 signature check
+const Ed25519Cost = 131072
+
+type Ed25519Sha256 struct {
+	PublicKey [32]byte
+	Signature [64]byte
+}
+
+func parseEd25519Sha256(content []byte) (*Ed25519Sha256, error) {
+	pk, b, err := getField(content, 0)
+	if err != nil {
+		return nil, conderr.NewParseError(TypeEd25519Sha256, 0, err)
+	}
+
+	if len(pk) != 32 {
+		return nil, conderr.NewParseError(TypeEd25519Sha256, 0, &conderr.SyntaxError{Msg: "public key must be 32 bytes"})
+	}
+
+	sig, b, err := getField(b, 1)
+	if err != nil {
+		return nil, conderr.NewParseError(TypeEd25519Sha256, len(content)-len(b), err)
+	}
+
+	if len(sig) != 64 {
+		return nil, conderr.NewParseError(TypeEd25519Sha256, len(content)-len(b)-len(sig), &conderr.SyntaxError{Msg: "signature must be 64 bytes"})
+	}
+
+	if len(b) != 0 {
+		return nil, conderr.NewParseError(TypeEd25519Sha256, len(content)-len(b), &conderr.SyntaxError{Msg: "trailing data in fulfillment"})
+	}
+
+	ful := &Ed25519Sha256{}
+	copy(ful.PublicKey[:], pk)
+	copy(ful.Signature[:], sig)
+
+	return ful, nil
+}
+
+// Signs the message.
+func (ful *Ed25519Sha256) Sign(privkey [64]byte, message []byte) {
+	copy(ful.PublicKey[:], privkey[32:])
+	ful.Signature = *ed25519.Sign(&privkey, message)
+}
+
+// Signs the message with a key that may be kept outside of the process.
+func (ful *Ed25519Sha256) SignWith(s crypto.Signer, message []byte) error {
+	pubkey, signature, err := signer.SignEd25519(s, message)
+	if err != nil {
+		return err
+	}
+
+	ful.PublicKey = pubkey
+	ful.Signature = signature
+	return nil
+}
+
+// The fingerprint is the hash of the DER encoded public key.
+func (ful *Ed25519Sha256) Condition() (*Condition, error) {
+	hash := sha256.Sum256(encoding.MakeDER(encoding.DERSequence,
+		encoding.MakeDER(encoding.DERContext|0, ful.PublicKey[:]),
+	))
+
+	return &Condition{
+		Type:        TypeEd25519Sha256,
+		Fingerprint: hash[:],
+		Cost:        Ed25519Cost,
+	}, nil
+}
+
+func (ful *Ed25519Sha256) Encode() ([]byte, error) {
+	return encoding.MakeDER(choiceTag(TypeEd25519Sha256), bytes.Join([][]byte{
+		encoding.MakeDER(encoding.DERContext|0, ful.PublicKey[:]),
+		encoding.MakeDER(encoding.DERContext|1, ful.Signature[:]),
+	}, []byte{})), nil
+}
+
+// Checks the signature over the message.
+func (ful *Ed25519Sha256) Validate(message []byte) error {
+	if !ed25519.Verify(&ful.PublicKey, message, &ful.Signature) {
+		return &conderr.ValidationError{Type: TypeEd25519Sha256, Err: conderr.ErrInvalidSignature}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package der
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/encoding"
+)
+
+// Cost added by a prefix on top of its subcondition
+const PrefixCost = 1024
+
+// The subfulfillment is validated against the message with the prefix
+// prepended.
+type PrefixSha256 struct {
+	Prefix           []byte
+	MaxMessageLength uint64
+	SubFulfillment   Fulfillment
+}
+
+func (p *parser) prefixSha256(content []byte) (*PrefixSha256, error) {
+	prefix, b, err := getField(content, 0)
+	if err != nil {
+		return nil, conderr.NewParseError(TypePrefixSha256, 0, err)
+	}
+
+	l, b, err := getField(b, 1)
+	if err != nil {
+		return nil, conderr.NewParseError(TypePrefixSha256, len(content)-len(b), err)
+	}
+
+	maxMessageLength, err := encoding.GetDERUint(l)
+	if err != nil {
+		return nil, conderr.NewParseError(TypePrefixSha256, len(content)-len(b)-len(l), err)
+	}
+
+	if maxMessageLength > 0xffffffff {
+		return nil, conderr.NewParseError(TypePrefixSha256, len(content)-len(b)-len(l), &conderr.SyntaxError{Msg: "maximum message length is too large"})
+	}
+
+	sub, b, err := getConstructedField(b, 2)
+	if err != nil {
+		return nil, conderr.NewParseError(TypePrefixSha256, len(content)-len(b), err)
+	}
+
+	if len(b) != 0 {
+		return nil, conderr.NewParseError(TypePrefixSha256, len(content)-len(b), &conderr.SyntaxError{Msg: "trailing data in fulfillment"})
+	}
+
+	subfulfillment, err := p.fulfillment(sub)
+	if err != nil {
+		return nil, conderr.InChild(0, err)
+	}
+
+	ful := &PrefixSha256{
+		Prefix:           prefix,
+		MaxMessageLength: maxMessageLength,
+		SubFulfillment:   subfulfillment,
+	}
+
+	return ful, nil
+}
+
+// The fingerprint is the hash of the prefix, the maximum message length and
+// the subcondition. The cost is that of the subcondition with the longest
+// message, plus a fixed cost.
+func (ful *PrefixSha256) Condition() (*Condition, error) {
+	sub, err := ful.SubFulfillment.Condition()
+	if err != nil {
+		return nil, conderr.InChild(0, err)
+	}
+
+	hash := sha256.Sum256(encoding.MakeDER(encoding.DERSequence, bytes.Join([][]byte{
+		encoding.MakeDER(encoding.DERContext|0, ful.Prefix),
+		encoding.MakeDER(encoding.DERContext|1, encoding.MakeDERUint(ful.MaxMessageLength)),
+		encoding.MakeDER(encoding.DERContext|encoding.DERConstructed|2, sub.Encode()),
+	}, []byte{})))
+
+	return &Condition{
+		Type:        TypePrefixSha256,
+		Fingerprint: hash[:],
+		Cost:        uint64(len(ful.Prefix)) + ful.MaxMessageLength + sub.Cost + PrefixCost,
+		// Our own type is known to anyone who can validate us
+		Subtypes: (1<<sub.Type | sub.Subtypes) &^ (1 << TypePrefixSha256),
+	}, nil
+}
+
+func (ful *PrefixSha256) Encode() ([]byte, error) {
+	sub, err := ful.SubFulfillment.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	return encoding.MakeDER(choiceTag(TypePrefixSha256), bytes.Join([][]byte{
+		encoding.MakeDER(encoding.DERContext|0, ful.Prefix),
+		encoding.MakeDER(encoding.DERContext|1, encoding.MakeDERUint(ful.MaxMessageLength)),
+		encoding.MakeDER(encoding.DERContext|encoding.DERConstructed|2, sub),
+	}, []byte{})), nil
+}
+
+// The subfulfillment must be valid against the prefixed message.
+func (ful *PrefixSha256) Validate(message []byte) error {
+	return ful.validate(context.Background(), message, verifyEd25519)
+}
+
+func (ful *PrefixSha256) validate(ctx context.Context, message []byte, check ed25519Check) error {
+	if uint64(len(message)) > ful.MaxMessageLength {
+		return &conderr.ValidationError{Type: TypePrefixSha256, Err: conderr.ErrMessageTooLong}
+	}
+
+	err := validate(ctx, ful.SubFulfillment, append(append([]byte{}, ful.Prefix...), message...), check)
+	if err != nil {
+		return conderr.InChild(0, err)
+	}
+
+	return nil
+}
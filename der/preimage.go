@@ -0,0 +1,47 @@
+package der
+
+import (
+	"crypto/sha256"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/encoding"
+)
+
+type PreimageSha256 struct {
+	Preimage []byte
+}
+
+func parsePreimageSha256(content []byte) (*PreimageSha256, error) {
+	preimage, b, err := getField(content, 0)
+	if err != nil {
+		return nil, conderr.NewParseError(TypePreimageSha256, 0, err)
+	}
+
+	if len(b) != 0 {
+		return nil, conderr.NewParseError(TypePreimageSha256, len(content)-len(b), &conderr.SyntaxError{Msg: "trailing data in fulfillment"})
+	}
+
+	return &PreimageSha256{Preimage: preimage}, nil
+}
+
+// The fingerprint is the hash of the preimage, and the cost its length.
+func (ful *PreimageSha256) Condition() (*Condition, error) {
+	hash := sha256.Sum256(ful.Preimage)
+
+	return &Condition{
+		Type:        TypePreimageSha256,
+		Fingerprint: hash[:],
+		Cost:        uint64(len(ful.Preimage)),
+	}, nil
+}
+
+func (ful *PreimageSha256) Encode() ([]byte, error) {
+	return encoding.MakeDER(choiceTag(TypePreimageSha256),
+		encoding.MakeDER(encoding.DERContext|0, ful.Preimage),
+	), nil
+}
+
+// A preimage fulfills its condition regardless of the message.
+func (ful *PreimageSha256) Validate(message []byte) error {
+	return nil
+}
@@ -0,0 +1,143 @@
+package der
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/encoding"
+)
+
+// Bounds on the modulus size, in bytes
+const (
+	RsaMinModulusLength = 128
+	RsaMaxModulusLength = 512
+)
+
+// The public exponent is fixed
+const RsaPublicExponent = 65537
+
+var rsaPSSOptions = &rsa.PSSOptions{
+	SaltLength: 32,
+	Hash:       crypto.SHA256,
+}
+
+// Signature is an RSA-PSS signature with SHA-256 and a 32 byte salt.
+type RsaSha256 struct {
+	Modulus   []byte
+	Signature []byte
+}
+
+func parseRsaSha256(content []byte) (*RsaSha256, error) {
+	modulus, b, err := getField(content, 0)
+	if err != nil {
+		return nil, conderr.NewParseError(TypeRsaSha256, 0, err)
+	}
+
+	signature, b, err := getField(b, 1)
+	if err != nil {
+		return nil, conderr.NewParseError(TypeRsaSha256, len(content)-len(b), err)
+	}
+
+	if len(b) != 0 {
+		return nil, conderr.NewParseError(TypeRsaSha256, len(content)-len(b), &conderr.SyntaxError{Msg: "trailing data in fulfillment"})
+	}
+
+	ful := &RsaSha256{
+		Modulus:   modulus,
+		Signature: signature,
+	}
+
+	return ful, nil
+}
+
+// Signs the message, and sets the modulus to that of the key.
+func (ful *RsaSha256) Sign(privkey *rsa.PrivateKey, message []byte) error {
+	if privkey.E != RsaPublicExponent {
+		return errors.New("public exponent must be 65537")
+	}
+
+	modulus := privkey.N.Bytes()
+	if len(modulus) < RsaMinModulusLength || len(modulus) > RsaMaxModulusLength {
+		return errors.New("modulus must be between 128 and 512 bytes")
+	}
+
+	hash := sha256.Sum256(message)
+	signature, err := rsa.SignPSS(rand.Reader, privkey, crypto.SHA256, hash[:], rsaPSSOptions)
+	if err != nil {
+		return err
+	}
+
+	ful.Modulus = modulus
+	ful.Signature = signature
+
+	return nil
+}
+
+// Cost of checking a signature, which is the square of the modulus length.
+func (ful *RsaSha256) Cost() uint64 {
+	return uint64(len(ful.Modulus)) * uint64(len(ful.Modulus))
+}
+
+// The fingerprint is the hash of the DER encoded modulus.
+func (ful *RsaSha256) Condition() (*Condition, error) {
+	hash := sha256.Sum256(encoding.MakeDER(encoding.DERSequence,
+		encoding.MakeDER(encoding.DERContext|0, ful.Modulus),
+	))
+
+	return &Condition{
+		Type:        TypeRsaSha256,
+		Fingerprint: hash[:],
+		Cost:        ful.Cost(),
+	}, nil
+}
+
+func (ful *RsaSha256) Encode() ([]byte, error) {
+	return encoding.MakeDER(choiceTag(TypeRsaSha256), bytes.Join([][]byte{
+		encoding.MakeDER(encoding.DERContext|0, ful.Modulus),
+		encoding.MakeDER(encoding.DERContext|1, ful.Signature),
+	}, []byte{})), nil
+}
+
+// Checks the signature over the message.
+func (ful *RsaSha256) Validate(message []byte) error {
+	hash := sha256.Sum256(message)
+	return ful.validateHash(hash[:])
+}
+
+// Checks the signature over the SHA-256 hash of the message, which is all
+// that RSA-PSS signs.
+func (ful *RsaSha256) validateHash(hash []byte) error {
+	if len(ful.Modulus) < RsaMinModulusLength || len(ful.Modulus) > RsaMaxModulusLength {
+		return &conderr.ValidationError{Type: TypeRsaSha256, Err: &conderr.SyntaxError{Msg: "modulus must be between 128 and 512 bytes"}}
+	}
+
+	if ful.Modulus[0] == 0 {
+		return &conderr.ValidationError{Type: TypeRsaSha256, Err: &conderr.SyntaxError{Msg: "modulus must not have leading zeros"}}
+	}
+
+	if len(ful.Signature) != len(ful.Modulus) {
+		return &conderr.ValidationError{Type: TypeRsaSha256, Err: &conderr.SyntaxError{Msg: "signature must be as long as the modulus"}}
+	}
+
+	pubkey := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(ful.Modulus),
+		E: RsaPublicExponent,
+	}
+
+	// Such signatures are rejected by the reference implementations
+	if new(big.Int).SetBytes(ful.Signature).Cmp(pubkey.N) >= 0 {
+		return &conderr.ValidationError{Type: TypeRsaSha256, Err: conderr.ErrInvalidSignature}
+	}
+
+	if rsa.VerifyPSS(pubkey, crypto.SHA256, hash, ful.Signature, rsaPSSOptions) != nil {
+		return &conderr.ValidationError{Type: TypeRsaSha256, Err: conderr.ErrInvalidSignature}
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package der
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash"
+	"io"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/encoding"
+)
+
+// Default limits of the streaming functions
+const (
+	DefaultMaxFulfillmentLength = 1 << 16
+	DefaultMaxMessageLength     = 1 << 30
+)
+
+// ReadFulfillment reads one DER encoded fulfillment from r, without reading
+// past it. Fulfillments longer than maxLength bytes are rejected before
+// they are read.
+func ReadFulfillment(r io.Reader, maxLength int) (Fulfillment, error) {
+	b, err := encoding.ReadDER(r, maxLength)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseFulfillment(b)
+}
+
+// ValidateReader reads a DER encoded fulfillment and checks it for validity
+// against the message read from the other reader, like Validate. The
+// fulfillment may be at most maxLength bytes long, and the message at most
+// maxMessageLength bytes.
+func ValidateReader(fulfillment io.Reader, message io.Reader, maxLength int, maxMessageLength int64) error {
+	ful, err := ReadFulfillment(fulfillment, maxLength)
+	if err != nil {
+		return err
+	}
+
+	return ValidateMessage(ful, message, maxMessageLength)
+}
+
+// ValidateFulfillmentReader reads a DER encoded fulfillment, checks that it
+// matches the condition, and that it is valid against the message read
+// from the other reader, like ValidateFulfillment. The message is only read
+// if the fulfillment matches.
+func ValidateFulfillmentReader(cond *Condition, fulfillment io.Reader, message io.Reader, maxLength int, maxMessageLength int64) error {
+	ful, err := ReadFulfillment(fulfillment, maxLength)
+	if err != nil {
+		return err
+	}
+
+	if err := matchCondition(cond, ful); err != nil {
+		return err
+	}
+
+	return ValidateMessage(ful, message, maxMessageLength)
+}
+
+// ValidateMessage checks the fulfillment for validity against the message
+// read from r, which may be at most maxLength bytes long. The message is
+// hashed as it is read for RSA signatures and only counted for prefixes and
+// preimages, so it is never held in memory whole. Ed25519 signs the whole
+// message, so fulfillments with Ed25519 subfulfillments read it into
+// memory first, as do fulfillments with subfulfillments of types defined
+// outside this package.
+func ValidateMessage(ful Fulfillment, r io.Reader, maxLength int64) error {
+	m := &streamedMessage{hashes: map[string]hash.Hash{}}
+	m.prepare(ful, nil)
+
+	writers := []io.Writer{}
+	for _, h := range m.hashes {
+		writers = append(writers, h)
+	}
+	if m.buffer != nil {
+		writers = append(writers, m.buffer)
+	}
+
+	// One more byte tells a message of maxLength bytes from a longer one
+	n, err := io.Copy(io.MultiWriter(writers...), io.LimitReader(r, maxLength+1))
+	if err != nil {
+		return err
+	}
+
+	if n > maxLength {
+		return &conderr.ValidationError{Type: fulfillmentType(ful), Err: conderr.ErrMessageTooLong}
+	}
+	m.length = uint64(n)
+
+	return m.validate(ful, nil)
+}
+
+// What the fulfillments of a tree need from a message that is read once
+type streamedMessage struct {
+	length uint64
+	// Hashes of the message as received by each RSA fulfillment, keyed by
+	// the prefixes prepended to it
+	hashes map[string]hash.Hash
+	// The whole message, if there are fulfillments that need all of it
+	buffer *bytes.Buffer
+}
+
+// Sets up what the fulfillment needs, given the prefixes prepended to the
+// message it receives
+func (m *streamedMessage) prepare(ful Fulfillment, prefix []byte) {
+	switch ful := ful.(type) {
+	case *PrefixSha256:
+		m.prepare(ful.SubFulfillment, concat(prefix, ful.Prefix))
+
+	case *ThresholdSha256:
+		for _, sf := range ful.SubFulfillments {
+			m.prepare(sf, prefix)
+		}
+
+	case *RsaSha256:
+		if _, ok := m.hashes[string(prefix)]; !ok {
+			h := sha256.New()
+			h.Write(prefix)
+			m.hashes[string(prefix)] = h
+		}
+
+	case *PreimageSha256:
+		// Preimages don't depend on the message
+
+	default:
+		// Ed25519 and types this package doesn't know need the whole message
+		if m.buffer == nil {
+			m.buffer = &bytes.Buffer{}
+		}
+	}
+}
+
+// Checks the fulfillment like its Validate method, once the message has
+// been read
+func (m *streamedMessage) validate(ful Fulfillment, prefix []byte) error {
+	switch ful := ful.(type) {
+	case *PrefixSha256:
+		if uint64(len(prefix))+m.length > ful.MaxMessageLength {
+			return &conderr.ValidationError{Type: TypePrefixSha256, Err: conderr.ErrMessageTooLong}
+		}
+
+		if err := m.validate(ful.SubFulfillment, concat(prefix, ful.Prefix)); err != nil {
+			return conderr.InChild(0, err)
+		}
+		return nil
+
+	case *ThresholdSha256:
+		if len(ful.SubFulfillments) == 0 {
+			return &conderr.ValidationError{Type: TypeThresholdSha256, Err: conderr.ErrThresholdNotMet}
+		}
+
+		for i, sf := range ful.SubFulfillments {
+			if err := m.validate(sf, prefix); err != nil {
+				return conderr.InChild(i, err)
+			}
+		}
+		return nil
+
+	case *RsaSha256:
+		return ful.validateHash(m.hashes[string(prefix)].Sum(nil))
+
+	case *PreimageSha256:
+		return ful.Validate(nil)
+
+	default:
+		return ful.Validate(concat(prefix, m.buffer.Bytes()))
+	}
+}
+
+// Type of the fulfillment, as numbered in the Fulfillment CHOICE
+func fulfillmentType(ful Fulfillment) uint16 {
+	switch ful.(type) {
+	case *PrefixSha256:
+		return TypePrefixSha256
+	case *ThresholdSha256:
+		return TypeThresholdSha256
+	case *RsaSha256:
+		return TypeRsaSha256
+	case *Ed25519Sha256:
+		return TypeEd25519Sha256
+	default:
+		return TypePreimageSha256
+	}
+}
+
+// Concatenates into a new slice
+func concat(a []byte, b []byte) []byte {
+	return append(append([]byte{}, a...), b...)
+}
@@ -0,0 +1,174 @@
+package der
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"sort"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/encoding"
+)
+
+// Cost added for every subcondition of a threshold
+const ThresholdSubconditionCost = 1024
+
+// The threshold is the number of subfulfillments. The subconditions are the
+// conditions of the remaining, unfulfilled subconditions.
+type ThresholdSha256 struct {
+	SubFulfillments []Fulfillment
+	SubConditions   []*Condition
+}
+
+func (p *parser) thresholdSha256(content []byte) (*ThresholdSha256, error) {
+	fs, b, err := getConstructedField(content, 0)
+	if err != nil {
+		return nil, conderr.NewParseError(TypeThresholdSha256, 0, err)
+	}
+	fsOffset := len(content) - len(b) - len(fs)
+
+	cs, b, err := getConstructedField(b, 1)
+	if err != nil {
+		return nil, conderr.NewParseError(TypeThresholdSha256, len(content)-len(b), err)
+	}
+	csOffset := len(content) - len(b) - len(cs)
+
+	if len(b) != 0 {
+		return nil, conderr.NewParseError(TypeThresholdSha256, len(content)-len(b), &conderr.SyntaxError{Msg: "trailing data in fulfillment"})
+	}
+
+	ful := &ThresholdSha256{}
+
+	items, err := getSetOf(fs)
+	if err != nil {
+		return nil, conderr.NewParseError(TypeThresholdSha256, fsOffset, err)
+	}
+	conditions, err := getSetOf(cs)
+	if err != nil {
+		return nil, conderr.NewParseError(TypeThresholdSha256, csOffset, err)
+	}
+
+	if len(items)+len(conditions) > p.opts.MaxChildren {
+		return nil, &conderr.ParseError{Type: TypeThresholdSha256, Err: &conderr.LimitError{Limit: conderr.LimitChildren, Max: p.opts.MaxChildren}}
+	}
+
+	for i, item := range items {
+		sf, err := p.fulfillment(item)
+		if err != nil {
+			return nil, conderr.InChild(i, err)
+		}
+		ful.SubFulfillments = append(ful.SubFulfillments, sf)
+	}
+
+	for _, item := range conditions {
+		sc, err := ParseCondition(item)
+		if err != nil {
+			return nil, conderr.NewParseError(TypeThresholdSha256, csOffset, err)
+		}
+		ful.SubConditions = append(ful.SubConditions, sc)
+		csOffset += len(item)
+	}
+
+	return ful, nil
+}
+
+// Conditions of the subfulfillments followed by the subconditions
+func (ful *ThresholdSha256) allConditions() ([]*Condition, error) {
+	conds := []*Condition{}
+	for i, sf := range ful.SubFulfillments {
+		cond, err := sf.Condition()
+		if err != nil {
+			return nil, conderr.InChild(i, err)
+		}
+		conds = append(conds, cond)
+	}
+
+	return append(conds, ful.SubConditions...), nil
+}
+
+// The fingerprint is the hash of the threshold and the sorted subconditions.
+// The cost is the sum of the most expensive subconditions that could make up
+// the threshold, plus a fixed cost for every subcondition.
+func (ful *ThresholdSha256) Condition() (*Condition, error) {
+	conds, err := ful.allConditions()
+	if err != nil {
+		return nil, err
+	}
+
+	threshold := len(ful.SubFulfillments)
+
+	items := [][]byte{}
+	costs := []uint64{}
+	var subtypes uint32
+
+	for _, cond := range conds {
+		items = append(items, cond.Encode())
+		costs = append(costs, cond.Cost)
+		subtypes |= 1<<cond.Type | cond.Subtypes
+	}
+
+	sortEncodings(items)
+	sort.Slice(costs, func(i, j int) bool { return costs[i] > costs[j] })
+
+	cost := uint64(ThresholdSubconditionCost * len(conds))
+	for _, c := range costs[:threshold] {
+		cost += c
+	}
+
+	hash := sha256.Sum256(encoding.MakeDER(encoding.DERSequence, bytes.Join([][]byte{
+		encoding.MakeDER(encoding.DERContext|0, encoding.MakeDERUint(uint64(threshold))),
+		encoding.MakeDER(encoding.DERContext|encoding.DERConstructed|1, bytes.Join(items, []byte{})),
+	}, []byte{})))
+
+	return &Condition{
+		Type:        TypeThresholdSha256,
+		Fingerprint: hash[:],
+		Cost:        cost,
+		// Our own type is known to anyone who can validate us
+		Subtypes: subtypes &^ (1 << TypeThresholdSha256),
+	}, nil
+}
+
+func (ful *ThresholdSha256) Encode() ([]byte, error) {
+	fs := [][]byte{}
+	for _, sf := range ful.SubFulfillments {
+		b, err := sf.Encode()
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, b)
+	}
+
+	cs := [][]byte{}
+	for _, sc := range ful.SubConditions {
+		cs = append(cs, sc.Encode())
+	}
+
+	sortEncodings(fs)
+	sortEncodings(cs)
+
+	return encoding.MakeDER(choiceTag(TypeThresholdSha256), bytes.Join([][]byte{
+		encoding.MakeDER(encoding.DERContext|encoding.DERConstructed|0, bytes.Join(fs, []byte{})),
+		encoding.MakeDER(encoding.DERContext|encoding.DERConstructed|1, bytes.Join(cs, []byte{})),
+	}, []byte{})), nil
+}
+
+// Every subfulfillment must be valid against the message.
+func (ful *ThresholdSha256) Validate(message []byte) error {
+	return ful.validate(context.Background(), message, verifyEd25519)
+}
+
+func (ful *ThresholdSha256) validate(ctx context.Context, message []byte, check ed25519Check) error {
+	if len(ful.SubFulfillments) == 0 {
+		return &conderr.ValidationError{Type: TypeThresholdSha256, Err: conderr.ErrThresholdNotMet}
+	}
+
+	for i, sf := range ful.SubFulfillments {
+		err := validate(ctx, sf, message, check)
+		if err != nil {
+			return conderr.InChild(i, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package der
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+)
+
+// Prefix of RFC 6920 named information URIs for SHA-256 fingerprints
+const URIPrefix = "ni:///sha-256;"
+
+// Names of the types, as used in the fpt and subtypes URI parameters
+var TypeNames = []string{
+	TypePreimageSha256:  "preimage-sha-256",
+	TypePrefixSha256:    "prefix-sha-256",
+	TypeThresholdSha256: "threshold-sha-256",
+	TypeRsaSha256:       "rsa-sha-256",
+	TypeEd25519Sha256:   "ed25519-sha-256",
+}
+
+// ParseTypeName returns the type with the given name.
+func ParseTypeName(name string) (uint16, error) {
+	for typ, n := range TypeNames {
+		if n == name {
+			return uint16(typ), nil
+		}
+	}
+
+	return 0, conderr.ErrUnsupportedType
+}
+
+// URI serializes the condition to the named information URI format.
+func (cond *Condition) URI() string {
+	uri := URIPrefix + base64.RawURLEncoding.EncodeToString(cond.Fingerprint) +
+		"?fpt=" + TypeNames[cond.Type] +
+		"&cost=" + strconv.FormatUint(cond.Cost, 10)
+
+	if isCompound(cond.Type) && cond.Subtypes != 0 {
+		uri += "&subtypes=" + formatSubtypes(cond.Subtypes)
+	}
+
+	return uri
+}
+
+// Names of the subtypes in the bitmask, separated by commas
+func formatSubtypes(bits uint32) string {
+	subtypes := []string{}
+	for typ, name := range TypeNames {
+		if bits&(1<<uint(typ)) != 0 {
+			subtypes = append(subtypes, name)
+		}
+	}
+
+	return strings.Join(subtypes, ",")
+}
+
+// ParseURI parses a condition out of the named information URI format.
+func ParseURI(s string) (*Condition, error) {
+	if !strings.HasPrefix(s, URIPrefix) {
+		return nil, &conderr.SyntaxError{Msg: "conditions must start with \"" + URIPrefix + "\""}
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(s, URIPrefix), "?", 2)
+	if len(parts) != 2 {
+		return nil, &conderr.SyntaxError{Offset: len(s), Msg: "conditions must have parameters"}
+	}
+
+	fingerprint, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, &conderr.SyntaxError{Offset: len(URIPrefix), Msg: "fingerprint is not valid base64"}
+	}
+
+	if len(fingerprint) != 32 {
+		return nil, &conderr.SyntaxError{Offset: len(URIPrefix), Msg: "fingerprint must be 32 bytes"}
+	}
+
+	// Offset of the parameters, for errors in any of them
+	offset := len(s) - len(parts[1])
+
+	params, err := url.ParseQuery(parts[1])
+	if err != nil {
+		return nil, &conderr.SyntaxError{Offset: offset, Msg: "parameters are not a valid query"}
+	}
+
+	if len(params["fpt"]) != 1 || len(params["cost"]) != 1 || len(params["subtypes"]) > 1 {
+		return nil, &conderr.SyntaxError{Offset: offset, Msg: "conditions must have one fpt and one cost"}
+	}
+
+	typ, err := ParseTypeName(params.Get("fpt"))
+	if err != nil {
+		return nil, err
+	}
+
+	cost, err := strconv.ParseUint(params.Get("cost"), 10, 32)
+	if err != nil {
+		return nil, &conderr.SyntaxError{Offset: offset, Msg: "cost is not a 32 bit number"}
+	}
+
+	cond := &Condition{
+		Type:        typ,
+		Fingerprint: fingerprint,
+		Cost:        cost,
+	}
+
+	if subtypes := params.Get("subtypes"); subtypes != "" {
+		if !isCompound(typ) {
+			return nil, &conderr.SyntaxError{Offset: offset, Msg: "only compound conditions have subtypes"}
+		}
+
+		for _, name := range strings.Split(subtypes, ",") {
+			subtype, err := ParseTypeName(name)
+			if err != nil {
+				return nil, err
+			}
+			cond.Subtypes |= 1 << subtype
+		}
+	}
+
+	return cond, nil
+}
@@ -2,12 +2,16 @@ package CryptoConditions
 
 import (
 	"bytes"
-	"encoding/binary"
-	"errors"
+	"context"
 
 	"github.com/agl/ed25519"
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/ed25519batch"
+	"github.com/jtremback/crypto-conditions/encoding"
 )
 
+// Condition is a condition in the binary condition format, which all
+// condition types write.
 type Condition struct {
 	Type                 uint16
 	FeatureBitmask       []byte
@@ -15,18 +19,89 @@ type Condition struct {
 	MaxFulfillmentLength uint64
 }
 
+// MarshalBinary writes the type, the feature bitmask, the fingerprint and
+// the maximum fulfillment length.
+func (cond *Condition) MarshalBinary() ([]byte, error) {
+	return bytes.Join([][]byte{
+		encoding.MakeUvarint(uint64(cond.Type)),
+		encoding.MakeVarbyte(cond.FeatureBitmask),
+		encoding.MakeVarbyte(cond.Fingerprint),
+		encoding.MakeUvarint(cond.MaxFulfillmentLength),
+	}, []byte{}), nil
+}
+
+// UnmarshalBinary reads the fields written by MarshalBinary.
+func (cond *Condition) UnmarshalBinary(b []byte) error {
+	r := encoding.NewReader(b)
+
+	typ, err := r.ReadUvarintMax(0xffff)
+	if err != nil {
+		return err
+	}
+
+	bitmask, err := r.ReadVarbyte()
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := r.ReadVarbyte()
+	if err != nil {
+		return err
+	}
+
+	length, err := r.ReadUvarint()
+	if err != nil {
+		return err
+	}
+
+	if err := r.End(); err != nil {
+		return err
+	}
+
+	cond.Type = uint16(typ)
+	cond.FeatureBitmask = append([]byte{}, bitmask...)
+	cond.Fingerprint = append([]byte{}, fingerprint...)
+	cond.MaxFulfillmentLength = length
+	return nil
+}
+
+// Type of raw Ed25519 fulfillments in the old numbering, by which their
+// errors and conditions are typed
+const ed25519Type = 4
+
+// Ed25519Fulfillment is a raw Ed25519 fulfillment, which has no type in the
+// registry: its binary type 4 now numbers ThresholdSha256, so Validate
+// rejects it. It is only checked by Ed25519Validate and
+// Ed25519ValidateBatch.
+//
+// Deprecated: use Ed25519Sha256.Fulfillment.
 type Ed25519Fulfillment struct {
 	PublicKey [32]byte
 	Signature [64]byte
 }
 
 func ParseEd25519Fulfillment(payload []byte) (Ed25519Fulfillment, error) {
-	buf := bytes.NewReader(payload)
 	ful := Ed25519Fulfillment{}
-	err := binary.Read(buf, binary.LittleEndian, ful)
+	err := ful.UnmarshalBinary(payload)
 	return ful, err
 }
 
+// MarshalBinary writes the public key followed by the signature.
+func (ful *Ed25519Fulfillment) MarshalBinary() ([]byte, error) {
+	return bytes.Join([][]byte{ful.PublicKey[:], ful.Signature[:]}, []byte{}), nil
+}
+
+// UnmarshalBinary reads the public key and the signature.
+func (ful *Ed25519Fulfillment) UnmarshalBinary(b []byte) error {
+	if len(b) != len(ful.PublicKey)+len(ful.Signature) {
+		return &conderr.SyntaxError{Msg: "Ed25519 fulfillments must be 96 bytes"}
+	}
+
+	copy(ful.PublicKey[:], b)
+	copy(ful.Signature[:], b[len(ful.PublicKey):])
+	return nil
+}
+
 func Ed25519Validate(payload []byte, message []byte) error {
 	ful, err := ParseEd25519Fulfillment(payload)
 	if err != nil {
@@ -34,15 +109,66 @@ func Ed25519Validate(payload []byte, message []byte) error {
 	}
 
 	if !ed25519.Verify(&ful.PublicKey, message, &ful.Signature) {
-		return errors.New("signature not valid")
+		return &conderr.ValidationError{Type: ed25519Type, Err: conderr.ErrInvalidSignature}
 	}
 
 	return nil
 }
 
+// Ed25519ValidateContext is Ed25519Validate, returning a
+// *conderr.CanceledError instead if ctx is done before it starts.
+func Ed25519ValidateContext(ctx context.Context, payload []byte, message []byte) error {
+	if err := conderr.Canceled(ctx); err != nil {
+		return err
+	}
+
+	return Ed25519Validate(payload, message)
+}
+
+// Ed25519ValidateBatch validates each payload against the message of the
+// same index, like Ed25519Validate, verifying the signatures with an
+// ed25519batch.Verifier.
+// It returns an error for each payload, all of them failing if there
+// isn't exactly one message per payload.
+func Ed25519ValidateBatch(payloads [][]byte, messages [][]byte) []error {
+	errs := make([]error, len(payloads))
+
+	if len(messages) != len(payloads) {
+		for i := range errs {
+			errs[i] = &conderr.SyntaxError{Msg: "there must be one message per payload"}
+		}
+		return errs
+	}
+
+	v := &ed25519batch.Verifier{}
+	// The payloads whose signatures are in the batch, in order
+	batched := []int{}
+
+	for i, payload := range payloads {
+		ful, err := ParseEd25519Fulfillment(payload)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		v.Add(&ful.PublicKey, messages[i], &ful.Signature)
+		batched = append(batched, i)
+	}
+
+	for j, valid := range v.Verify() {
+		if !valid {
+			errs[batched[j]] = &conderr.ValidationError{Type: ed25519Type, Err: conderr.ErrInvalidSignature}
+		}
+	}
+
+	return errs
+}
+
+// Condition returns the condition of the fulfillment, of type 4 in the old
+// numbering.
 func (ful *Ed25519Fulfillment) Condition() Condition {
 	return Condition{
-		Type:                 4,
+		Type:                 ed25519Type,
 		FeatureBitmask:       []byte{0x20},
 		Fingerprint:          ful.PublicKey[:],
 		MaxFulfillmentLength: 96,
@@ -0,0 +1,297 @@
+// Verifies many Ed25519 signatures at once. Batches with an invalid
+// signature are rejected with a single multi-scalar multiplication instead
+// of one per signature.
+package ed25519batch
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+
+	"github.com/agl/ed25519"
+	"github.com/agl/ed25519/edwards25519"
+)
+
+// Verifier collects signatures to verify together. The batch equation
+// holds for any signatures that ed25519.Verify accepts, so a batch for which
+// it fails has an invalid signature, which is found without verifying them
+// one by one. It is multiplied by the cofactor, so it also holds for
+// signatures whose R or public key has a small order component, which
+// ed25519.Verify may reject. A batch that holds proves nothing, and its
+// signatures are verified one by one before any is reported valid.
+//
+// The zero value is an empty Verifier.
+type Verifier struct {
+	entries []entry
+}
+
+type entry struct {
+	publicKey [32]byte
+	message   []byte
+	signature [64]byte
+}
+
+// Add adds a signature to the batch. The message must not be modified until
+// the batch is verified.
+func (v *Verifier) Add(publicKey *[32]byte, message []byte, signature *[64]byte) {
+	v.entries = append(v.entries, entry{
+		publicKey: *publicKey,
+		message:   message,
+		signature: *signature,
+	})
+}
+
+// Len returns the number of signatures in the batch.
+func (v *Verifier) Len() int {
+	return len(v.entries)
+}
+
+// Verify returns whether each signature is valid, in the order they were
+// added. Each is verified by ed25519.Verify, since the batch equation can't
+// tell which are invalid.
+func (v *Verifier) Verify() []bool {
+	valid := make([]bool, len(v.entries))
+	for i := range v.entries {
+		e := &v.entries[i]
+		valid[i] = ed25519.Verify(&e.publicKey, e.message, &e.signature)
+	}
+	return valid
+}
+
+// VerifyAll reports whether every signature is valid. Batches with an
+// invalid signature are rejected by the batch equation, without verifying
+// the signatures one by one, which the others still are.
+func (v *Verifier) VerifyAll() bool {
+	if !v.check() {
+		return false
+	}
+
+	for _, ok := range v.Verify() {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks the batch equation, which only fails if a signature is invalid
+func (v *Verifier) check() bool {
+	// A single signature is verified as fast on its own
+	if len(v.entries) < 2 {
+		return true
+	}
+
+	scalars := [][32]byte{}
+	points := []edwards25519.ExtendedGroupElement{}
+	var sum, zero [32]byte
+
+	// Random coefficients, 128 bits per signature. Without them, the
+	// signatures are left to ed25519.Verify.
+	random := make([]byte, 16*len(v.entries))
+	if _, err := rand.Read(random); err != nil {
+		return true
+	}
+
+	for i := range v.entries {
+		e := &v.entries[i]
+
+		var a, r edwards25519.ExtendedGroupElement
+		var rBytes, s [32]byte
+		copy(rBytes[:], e.signature[:32])
+		copy(s[:], e.signature[32:])
+
+		// Rejected by ed25519.Verify before any curve arithmetic
+		if s[31]&224 != 0 || !a.FromBytes(&e.publicKey) {
+			return false
+		}
+
+		// ed25519.Verify compares R to an encoding it computes, so R must
+		// be a canonical encoding
+		if !r.FromBytes(&rBytes) || !canonical(r, &rBytes) {
+			return false
+		}
+
+		var z [32]byte
+		copy(z[:], random[16*i:16*i+16])
+
+		var digest [64]byte
+		var k, zk [32]byte
+		h := sha512.New()
+		h.Write(rBytes[:])
+		h.Write(e.publicKey[:])
+		h.Write(e.message)
+		h.Sum(digest[:0])
+		edwards25519.ScReduce(&k, &digest)
+
+		// z R + z k A - z S B must be the identity
+		edwards25519.ScMulAdd(&zk, &z, &k, &zero)
+		edwards25519.ScMulAdd(&sum, &z, &s, &sum)
+
+		scalars = append(scalars, z, zk)
+		points = append(points, r, a)
+	}
+
+	var negSum [32]byte
+	edwards25519.ScMulAdd(&negSum, &sum, &minusOne, &zero)
+	scalars = append(scalars, negSum)
+	points = append(points, basePoint())
+
+	var q edwards25519.ExtendedGroupElement
+	multiScalarMult(&q, scalars, points)
+
+	// The scalars are reduced modulo the order of B, which changes the
+	// small order components of the other points, so these are cleared
+	var c edwards25519.CompletedGroupElement
+	for i := 0; i < 3; i++ {
+		q.Double(&c)
+		c.ToExtended(&q)
+	}
+
+	return isIdentity(q)
+}
+
+// L - 1, where L is the order of the base point
+var minusOne = [32]byte{
+	0xec, 0xd3, 0xf5, 0x5c, 0x1a, 0x63, 0x12, 0x58, 0xd6, 0x9c, 0xf7, 0xa2, 0xde, 0xf9, 0xde, 0x14,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10,
+}
+
+// 2d, where d is the curve constant
+var d2 = func() edwards25519.FieldElement {
+	var fe edwards25519.FieldElement
+	edwards25519.FeFromBytes(&fe, &[32]byte{
+		0x59, 0xf1, 0xb2, 0x26, 0x94, 0x9b, 0xd6, 0xeb, 0x56, 0xb1, 0x83, 0x82, 0x9a, 0x14, 0xe0, 0x00,
+		0x30, 0xd1, 0xf3, 0xee, 0xf2, 0x80, 0x8e, 0x19, 0xe7, 0xfc, 0xdf, 0x56, 0xdc, 0xd9, 0x06, 0x24,
+	})
+	return fe
+}()
+
+func basePoint() edwards25519.ExtendedGroupElement {
+	var b edwards25519.ExtendedGroupElement
+	b.FromBytes(&[32]byte{
+		0x58, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66,
+		0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66,
+	})
+	return b
+}
+
+// Whether s is the encoding of p that ToBytes would produce. p is copied, as
+// FeToBytes modifies the field elements it encodes.
+func canonical(p edwards25519.ExtendedGroupElement, s *[32]byte) bool {
+	var y [32]byte
+	edwards25519.FeToBytes(&y, &p.Y)
+	y[31] |= s[31] & 0x80
+	if y != *s {
+		return false
+	}
+
+	// The sign of x = 0 must be positive
+	return edwards25519.FeIsNonZero(&p.X) == 1 || s[31]&0x80 == 0
+}
+
+func isIdentity(p edwards25519.ExtendedGroupElement) bool {
+	var y, z [32]byte
+	edwards25519.FeToBytes(&y, &p.Y)
+	edwards25519.FeToBytes(&z, &p.Z)
+	return edwards25519.FeIsNonZero(&p.X) == 0 && y == z
+}
+
+// Sets r = p + q, as the edwards25519 package adds cached elements
+func add(r *edwards25519.CompletedGroupElement, p, q *edwards25519.ExtendedGroupElement) {
+	var yPlusX, yMinusX, t2d, t0 edwards25519.FieldElement
+
+	edwards25519.FeAdd(&yPlusX, &q.Y, &q.X)
+	edwards25519.FeSub(&yMinusX, &q.Y, &q.X)
+	edwards25519.FeMul(&t2d, &q.T, &d2)
+
+	edwards25519.FeAdd(&r.X, &p.Y, &p.X)
+	edwards25519.FeSub(&r.Y, &p.Y, &p.X)
+	edwards25519.FeMul(&r.Z, &r.X, &yPlusX)
+	edwards25519.FeMul(&r.Y, &r.Y, &yMinusX)
+	edwards25519.FeMul(&r.T, &t2d, &p.T)
+	edwards25519.FeMul(&r.X, &p.Z, &q.Z)
+	edwards25519.FeAdd(&t0, &r.X, &r.X)
+	edwards25519.FeSub(&r.X, &r.Z, &r.Y)
+	edwards25519.FeAdd(&r.Y, &r.Z, &r.Y)
+	edwards25519.FeAdd(&r.Z, &t0, &r.T)
+	edwards25519.FeSub(&r.T, &t0, &r.T)
+}
+
+// Sets r = p - q
+func sub(r *edwards25519.CompletedGroupElement, p, q *edwards25519.ExtendedGroupElement) {
+	neg := *q
+	edwards25519.FeNeg(&neg.X, &neg.X)
+	edwards25519.FeNeg(&neg.T, &neg.T)
+	add(r, p, &neg)
+}
+
+// Sets r to the sum of scalars[i] points[i], in variable time. Each scalar
+// is written in signed digits up to 15 that are at least 5 positions apart,
+// and the points share one chain of doublings.
+func multiScalarMult(r *edwards25519.ExtendedGroupElement, scalars [][32]byte, points []edwards25519.ExtendedGroupElement) {
+	digits := make([][256]int8, len(points))
+	// P, 3P, 5P, ..., 15P for each point P
+	multiples := make([][8]edwards25519.ExtendedGroupElement, len(points))
+
+	var c edwards25519.CompletedGroupElement
+	for i := range points {
+		slide(&digits[i], &scalars[i])
+
+		var double edwards25519.ExtendedGroupElement
+		points[i].Double(&c)
+		c.ToExtended(&double)
+
+		multiples[i][0] = points[i]
+		for j := 1; j < len(multiples[i]); j++ {
+			add(&c, &multiples[i][j-1], &double)
+			c.ToExtended(&multiples[i][j])
+		}
+	}
+
+	r.Zero()
+	for bit := 255; bit >= 0; bit-- {
+		r.Double(&c)
+		c.ToExtended(r)
+
+		for i := range digits {
+			switch d := digits[i][bit]; {
+			case d > 0:
+				add(&c, r, &multiples[i][d/2])
+				c.ToExtended(r)
+			case d < 0:
+				sub(&c, r, &multiples[i][-d/2])
+				c.ToExtended(r)
+			}
+		}
+	}
+}
+
+// Writes the scalar in signed digits, as the edwards25519 package does
+func slide(r *[256]int8, a *[32]byte) {
+	for i := range r {
+		r[i] = int8(1 & (a[i>>3] >> uint(i&7)))
+	}
+
+	for i := range r {
+		if r[i] != 0 {
+			for b := 1; b <= 6 && i+b < 256; b++ {
+				if r[i+b] != 0 {
+					if r[i]+(r[i+b]<<uint(b)) <= 15 {
+						r[i] += r[i+b] << uint(b)
+						r[i+b] = 0
+					} else if r[i]-(r[i+b]<<uint(b)) >= -15 {
+						r[i] -= r[i+b] << uint(b)
+						for k := i + b; k < 256; k++ {
+							if r[k] == 0 {
+								r[k] = 1
+								break
+							}
+							r[k] = 0
+						}
+					} else {
+						break
+					}
+				}
+			}
+		}
+	}
+}
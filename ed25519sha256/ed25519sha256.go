@@ -3,17 +3,57 @@ package Ed25519Sha256
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/sha256"
 	"encoding/base64"
-	"errors"
-	"fmt"
+	"encoding/json"
 	"strconv"
-	"strings"
 
 	"github.com/agl/ed25519"
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/der"
 	"github.com/jtremback/crypto-conditions/encoding"
+	"github.com/jtremback/crypto-conditions/registry"
+	"github.com/jtremback/crypto-conditions/signer"
 )
 
+const (
+	TypeID      = 8
+	Name        = "Ed25519Sha256"
+	FeatureBits = registry.FeatureSha256 | registry.FeatureEd25519
+)
+
+func init() {
+	registry.Register(&registry.Type{
+		ID:          TypeID,
+		Name:        Name,
+		FeatureBits: FeatureBits,
+		// The signature is checked when validating, so that fulfillments
+		// whose signature isn't valid can still be inspected, and their
+		// condition derived
+		ParseFulfillment: func(payload []byte) (registry.Fulfillment, error) {
+			return parsePayload(payload)
+		},
+		FulfillmentToCondition: func(payload []byte) (string, error) {
+			ful, err := parsePayload(payload)
+			if err != nil {
+				return "", err
+			}
+			cond := ful.Condition()
+			return cond.Serialize(), nil
+		},
+		Validate: Validate,
+		Cost:     Cost,
+		UnmarshalJSON: func(b []byte) (registry.Fulfillment, error) {
+			ful := &Fulfillment{}
+			if err := json.Unmarshal(b, ful); err != nil {
+				return nil, err
+			}
+			return ful, nil
+		},
+	})
+}
+
 func sliceTo64Byte(slice []byte) [64]byte {
 	if len(slice) == 64 {
 		var array [64]byte
@@ -43,96 +83,157 @@ type Fulfillment struct {
 
 // Serializes to the Crypto Conditions Fulfillment string format.
 func (ful *Fulfillment) Serialize() string {
-	payload := base64.URLEncoding.EncodeToString(bytes.Join([][]byte{
+	return "cf:1:8:" + base64.URLEncoding.EncodeToString(ful.payload())
+}
+
+// Binary payload of the fulfillment, as found in the string and binary
+// formats
+func (ful *Fulfillment) payload() []byte {
+	return bytes.Join([][]byte{
 		encoding.MakeVarbyte(ful.PublicKey[:]),
 		encoding.MakeVarbyte(ful.MessageId),
 		encoding.MakeVarbyte(ful.FixedMessage),
 		encoding.MakeUvarint(ful.MaxDynamicMessageLength),
 		encoding.MakeVarbyte(ful.DynamicMessage),
 		encoding.MakeVarbyte(ful.Signature[:]),
-	}, []byte{}))
-
-	return "cf:1:8:" + payload
+	}, []byte{})
 }
 
 // Signs an in-memory Fulfillment
 func (ful *Fulfillment) Sign(privkey [64]byte) {
-	ful.Signature = *ed25519.Sign(&privkey, append(ful.FixedMessage, ful.DynamicMessage...))
+	ful.Signature = *ed25519.Sign(&privkey, ful.message())
+}
+
+// Signs an in-memory Fulfillment with a key that may be kept outside of the
+// process, and sets the public key to the signer's.
+func (ful *Fulfillment) SignWith(s crypto.Signer) error {
+	pubkey, signature, err := signer.SignEd25519(s, ful.message())
+	if err != nil {
+		return err
+	}
+
+	ful.PublicKey = pubkey
+	ful.Signature = signature
+	return nil
 }
 
 // Parses Fulfillment out of the Crypto Conditions string format,
 // and checks it for validity, including the signature.
 func ParseFulfillment(s string) (*Fulfillment, error) {
-	parts := strings.Split(s, ":")
-	if len(parts) != 4 {
-		return nil, errors.New("parsing error")
+	typ, payload, err := registry.SplitFulfillment(s)
+	if err != nil {
+		return nil, err
 	}
 
-	if parts[0] != "cf" {
-		return nil, errors.New("fulfillments must start with \"cf\"")
+	if typ != TypeID {
+		return nil, &conderr.ParseError{Type: typ, Err: conderr.ErrUnsupportedType}
 	}
 
-	if parts[1] != "1" {
-		return nil, errors.New("must be protocol version 1")
-	}
-	if parts[2] != "8" {
-		return nil, errors.New("not an Ed25519Sha256 condition")
-	}
+	return ParsePayload(payload)
+}
 
-	b, err := base64.URLEncoding.DecodeString(parts[3])
+// Parses Fulfillment out of the binary payload, and checks it for validity,
+// including the signature.
+func ParsePayload(b []byte) (*Fulfillment, error) {
+	ful, err := parsePayload(b)
 	if err != nil {
-		return nil, errors.New("parsing error")
+		return nil, err
+	}
+
+	// Check signature
+	if !ed25519.Verify(&ful.PublicKey, ful.message(), &ful.Signature) {
+		return nil, &conderr.ValidationError{Type: TypeID, Err: conderr.ErrInvalidSignature}
 	}
 
-	pk, b, err := encoding.GetVarbyte(b)
+	return ful, nil
+}
+
+// The signed message: the fixed message followed by the dynamic message
+func (ful *Fulfillment) message() []byte {
+	return append(append([]byte{}, ful.FixedMessage...), ful.DynamicMessage...)
+}
+
+// Parses the structure of the binary payload without checking the signature
+func parsePayload(payload []byte) (*Fulfillment, error) {
+	r := encoding.NewReader(payload)
+
+	pk, err := r.ReadVarbyte()
 	if err != nil {
-		return nil, err
+		return nil, conderr.NewParseError(TypeID, 0, err)
+	}
+	if len(pk) != 32 {
+		return nil, conderr.NewParseError(TypeID, 0, &conderr.SyntaxError{Msg: "public key must be 32 bytes"})
 	}
-	pubkey := sliceTo32Byte(pk)
 
-	messageId, b, err := encoding.GetVarbyte(b)
+	messageId, err := r.ReadVarbyte()
 	if err != nil {
-		return nil, err
+		return nil, conderr.NewParseError(TypeID, 0, err)
 	}
-	fixedMessage, b, err := encoding.GetVarbyte(b)
+	fixedMessage, err := r.ReadVarbyte()
 	if err != nil {
-		return nil, err
+		return nil, conderr.NewParseError(TypeID, 0, err)
 	}
-	fmt.Println("foo", len(b))
-	maxDynamicMessageLength, b, err := encoding.GetUvarint(b)
+	maxDynamicMessageLength, err := r.ReadUvarint()
 	if err != nil {
-		return nil, err
+		return nil, conderr.NewParseError(TypeID, 0, err)
 	}
-	fmt.Println("foo", maxDynamicMessageLength, len(b))
-	dynamicMessage, b, err := encoding.GetVarbyte(b)
+	dynamicOffset := r.Offset()
+	dynamicMessage, err := r.ReadVarbyte()
 	if err != nil {
-		return nil, err
+		return nil, conderr.NewParseError(TypeID, 0, err)
+	}
+	if uint64(len(dynamicMessage)) > maxDynamicMessageLength {
+		return nil, conderr.NewParseError(TypeID, dynamicOffset, &conderr.SyntaxError{Msg: "dynamic message must not be longer than its maximum length"})
 	}
 
-	sig, b, err := encoding.GetVarbyte(b)
+	offset := r.Offset()
+	sig, err := r.ReadVarbyte()
 	if err != nil {
-		return nil, err
+		return nil, conderr.NewParseError(TypeID, 0, err)
+	}
+	if len(sig) != 64 {
+		return nil, conderr.NewParseError(TypeID, offset, &conderr.SyntaxError{Msg: "signature must be 64 bytes"})
 	}
-	signature := sliceTo64Byte(sig)
 
-	// Check signature
-	fullMessage := append(fixedMessage, dynamicMessage...)
-	if !ed25519.Verify(&pubkey, fullMessage, &signature) {
-		return nil, errors.New("signature not valid")
+	if err := r.End(); err != nil {
+		return nil, conderr.NewParseError(TypeID, 0, err)
 	}
 
 	ful := &Fulfillment{
-		PublicKey:               pubkey,
+		PublicKey:               sliceTo32Byte(pk),
 		MessageId:               messageId,
 		FixedMessage:            fixedMessage,
 		MaxDynamicMessageLength: maxDynamicMessageLength,
 		DynamicMessage:          dynamicMessage,
-		Signature:               signature,
+		Signature:               sliceTo64Byte(sig),
 	}
 
 	return ful, nil
 }
 
+// Returns the cost of the payload without checking the signature.
+func Cost(payload []byte) (uint64, error) {
+	ful, err := parsePayload(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	return ful.Cost(), nil
+}
+
+// Cost of checking the signature
+func (ful *Fulfillment) Cost() uint64 {
+	return der.Ed25519Cost
+}
+
+// Checks the payload for validity. The signature covers the fixed and
+// dynamic messages carried in the fulfillment, so the message is not used,
+// and the dynamic message must not be longer than its maximum length.
+func Validate(payload []byte, message []byte) error {
+	_, err := ParsePayload(payload)
+	return err
+}
+
 // Turns an in-memory Fulfillment to an in-memory Condition. DynamicMessage and Signature
 // are discarded if present.
 func (ful *Fulfillment) Condition() Condition {
@@ -152,22 +253,60 @@ func (ful *Fulfillment) Condition() Condition {
 	}
 }
 
+// Converts to the DER encodable Ed25519 fulfillment of the final
+// crypto-conditions draft. The message of the converted fulfillment is the
+// fixed message followed by the dynamic message.
+func (ful *Fulfillment) DER() *der.Ed25519Sha256 {
+	return &der.Ed25519Sha256{
+		PublicKey: ful.PublicKey,
+		Signature: ful.Signature,
+	}
+}
+
 type Condition struct {
 	PublicKey               [32]byte
 	MessageId               []byte
 	FixedMessage            []byte
 	MaxDynamicMessageLength uint64
+	// Fingerprint of a condition read from the binary format, or from JSON
+	// without the fields above, which are left empty: those formats may
+	// only carry the fingerprint. Nil for conditions made of the fields.
+	Fingerprint []byte
 }
 
 // Serializes to the Crypto Conditions string format.
 func (cond *Condition) Serialize() string {
-	hash := sha256.Sum256(bytes.Join([][]byte{
+	hash := cond.fingerprint()
+	return "cc:1:8:" + base64.URLEncoding.EncodeToString(hash[:]) + ":" + strconv.FormatUint(cond.MaxDynamicMessageLength, 10)
+}
+
+// Hash of the public key and the messages, unless only the fingerprint is
+// known
+func (cond *Condition) fingerprint() [32]byte {
+	if cond.Fingerprint != nil {
+		var hash [32]byte
+		copy(hash[:], cond.Fingerprint)
+		return hash
+	}
+
+	return sha256.Sum256(bytes.Join([][]byte{
 		encoding.MakeVarbyte(cond.PublicKey[:]),
 		encoding.MakeVarbyte(cond.MessageId),
 		encoding.MakeVarbyte(cond.FixedMessage),
 	}, []byte{}))
+}
 
-	return "cc:1:8:" + base64.URLEncoding.EncodeToString(hash[:]) + ":" + strconv.FormatUint(cond.MaxDynamicMessageLength, 10)
+// Converts to the condition of the DER encodable Ed25519 fulfillment of the
+// final crypto-conditions draft, which commits to the public key but not to
+// the message id and fixed message. Conditions known by their fingerprint
+// only are rejected with conderr.ErrNotConvertible, as the draft hashes a
+// different encoding of the public key.
+func (cond *Condition) DER() (*der.Condition, error) {
+	if cond.Fingerprint != nil {
+		return nil, &conderr.ParseError{Type: TypeID, Err: conderr.ErrNotConvertible}
+	}
+
+	return (&der.Ed25519Sha256{PublicKey: cond.PublicKey}).Condition()
 }
 
 func FulfillmentToCondition(s string) (string, error) {
@@ -181,3 +320,191 @@ func FulfillmentToCondition(s string) (string, error) {
 	condString := cond.Serialize()
 	return condString, nil
 }
+
+// JSON representation of the Fulfillment
+type jsonFulfillment struct {
+	Type                    string         `json:"type"`
+	PublicKey               encoding.Bytes `json:"publicKey"`
+	MessageId               encoding.Bytes `json:"messageId"`
+	FixedMessage            encoding.Bytes `json:"fixedMessage"`
+	MaxDynamicMessageLength uint64         `json:"maxDynamicMessageLength"`
+	DynamicMessage          encoding.Bytes `json:"dynamicMessage"`
+	Signature               encoding.Bytes `json:"signature"`
+}
+
+func (ful *Fulfillment) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&jsonFulfillment{
+		Type:                    Name,
+		PublicKey:               ful.PublicKey[:],
+		MessageId:               ful.MessageId,
+		FixedMessage:            ful.FixedMessage,
+		MaxDynamicMessageLength: ful.MaxDynamicMessageLength,
+		DynamicMessage:          ful.DynamicMessage,
+		Signature:               ful.Signature[:],
+	})
+}
+
+func (ful *Fulfillment) UnmarshalJSON(b []byte) error {
+	aux := &jsonFulfillment{}
+	if err := json.Unmarshal(b, aux); err != nil {
+		return err
+	}
+
+	if err := registry.CheckTypeName(aux.Type, TypeID); err != nil {
+		return err
+	}
+
+	if err := aux.PublicKey.Array(ful.PublicKey[:]); err != nil {
+		return &conderr.ParseError{Type: TypeID, Err: err}
+	}
+	if err := aux.Signature.Array(ful.Signature[:]); err != nil {
+		return &conderr.ParseError{Type: TypeID, Err: err}
+	}
+
+	if uint64(len(aux.DynamicMessage)) > aux.MaxDynamicMessageLength {
+		return &conderr.ParseError{Type: TypeID, Err: &conderr.SyntaxError{Msg: "dynamic message must not be longer than its maximum length"}}
+	}
+
+	ful.MessageId = aux.MessageId
+	ful.FixedMessage = aux.FixedMessage
+	ful.MaxDynamicMessageLength = aux.MaxDynamicMessageLength
+	ful.DynamicMessage = aux.DynamicMessage
+	return nil
+}
+
+// JSON representation of the Condition: that of any condition in the
+// string format, along with the fields the fingerprint is derived from,
+// which the Condition can't do without
+type jsonCondition struct {
+	Type                 string         `json:"type"`
+	Fingerprint          encoding.Bytes `json:"fingerprint"`
+	MaxFulfillmentLength uint64         `json:"maxFulfillmentLength"`
+	PublicKey            encoding.Bytes `json:"publicKey"`
+	MessageId            encoding.Bytes `json:"messageId"`
+	FixedMessage         encoding.Bytes `json:"fixedMessage"`
+}
+
+func (cond *Condition) MarshalJSON() ([]byte, error) {
+	if cond.Fingerprint != nil {
+		return json.Marshal(cond.generic())
+	}
+
+	hash := cond.fingerprint()
+	return json.Marshal(&jsonCondition{
+		Type:                 Name,
+		Fingerprint:          hash[:],
+		MaxFulfillmentLength: cond.MaxDynamicMessageLength,
+		PublicKey:            cond.PublicKey[:],
+		MessageId:            cond.MessageId,
+		FixedMessage:         cond.FixedMessage,
+	})
+}
+
+// UnmarshalJSON reads the representation written by MarshalJSON. Conditions
+// represented by their fingerprint only, as entry.ToJSON writes them, are
+// read into the Fingerprint.
+func (cond *Condition) UnmarshalJSON(b []byte) error {
+	aux := &jsonCondition{}
+	if err := json.Unmarshal(b, aux); err != nil {
+		return err
+	}
+
+	if err := registry.CheckTypeName(aux.Type, TypeID); err != nil {
+		return err
+	}
+
+	if aux.PublicKey == nil && aux.MessageId == nil && aux.FixedMessage == nil {
+		return cond.fromGeneric(&registry.Condition{
+			Type:                 TypeID,
+			Fingerprint:          aux.Fingerprint,
+			MaxFulfillmentLength: aux.MaxFulfillmentLength,
+		})
+	}
+
+	parsed := Condition{
+		MessageId:               aux.MessageId,
+		FixedMessage:            aux.FixedMessage,
+		MaxDynamicMessageLength: aux.MaxFulfillmentLength,
+	}
+	if err := aux.PublicKey.Array(parsed.PublicKey[:]); err != nil {
+		return &conderr.ParseError{Type: TypeID, Err: err}
+	}
+
+	hash := parsed.fingerprint()
+	if !bytes.Equal(aux.Fingerprint, hash[:]) {
+		return &conderr.ParseError{Type: TypeID, Err: &conderr.SyntaxError{Msg: "fingerprint doesn't match the public key and messages"}}
+	}
+
+	*cond = parsed
+	return nil
+}
+
+// MarshalBinary writes the fulfillment in the binary format.
+func (ful *Fulfillment) MarshalBinary() ([]byte, error) {
+	return registry.MakeBinaryFulfillment(TypeID, ful.payload()), nil
+}
+
+// UnmarshalBinary reads the fulfillment out of the binary format. The
+// signature is checked when validating.
+func (ful *Fulfillment) UnmarshalBinary(b []byte) error {
+	typ, payload, err := registry.SplitBinaryFulfillment(b)
+	if err != nil {
+		return err
+	}
+
+	if typ != TypeID {
+		return &conderr.ParseError{Type: typ, Err: conderr.ErrUnsupportedType}
+	}
+
+	// The fulfillment must not refer to b
+	parsed, err := parsePayload(append([]byte{}, payload...))
+	if err != nil {
+		return err
+	}
+
+	*ful = *parsed
+	return nil
+}
+
+// MarshalBinary writes the condition in the binary condition format, which
+// only carries the fingerprint.
+func (cond *Condition) MarshalBinary() ([]byte, error) {
+	return cond.generic().Binary(), nil
+}
+
+// UnmarshalBinary reads the condition out of the binary condition format,
+// into the Fingerprint.
+func (cond *Condition) UnmarshalBinary(b []byte) error {
+	c, err := registry.ParseBinaryCondition(b)
+	if err != nil {
+		return err
+	}
+
+	return cond.fromGeneric(c)
+}
+
+// The condition as any condition in the string format
+func (cond *Condition) generic() *registry.Condition {
+	hash := cond.fingerprint()
+	return &registry.Condition{
+		Type:                 TypeID,
+		Fingerprint:          hash[:],
+		MaxFulfillmentLength: cond.MaxDynamicMessageLength,
+	}
+}
+
+// Reads a condition known by its fingerprint only
+func (cond *Condition) fromGeneric(c *registry.Condition) error {
+	if c.Type != TypeID {
+		return &conderr.ParseError{Type: c.Type, Err: conderr.ErrUnsupportedType}
+	}
+	if len(c.Fingerprint) != sha256.Size {
+		return &conderr.ParseError{Type: TypeID, Err: &conderr.SyntaxError{Msg: "fingerprint must be 32 bytes"}}
+	}
+
+	*cond = Condition{
+		MaxDynamicMessageLength: c.MaxFulfillmentLength,
+		Fingerprint:             append([]byte{}, c.Fingerprint...),
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package encoding
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+)
+
+// DER identifier octet bits
+const (
+	DERConstructed = 0x20
+	DERContext     = 0x80
+	DERSequence    = 0x30
+)
+
+// MakeDERLength returns the DER encoding of a content length
+func MakeDERLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+
+	b := []byte{}
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+// MakeDER prefixes the content with a tag and its length
+func MakeDER(tag byte, content []byte) []byte {
+	return bytes.Join([][]byte{[]byte{tag}, MakeDERLength(len(content)), content}, []byte{})
+}
+
+// MakeDERUint returns the content octets of a non-negative INTEGER
+func MakeDERUint(n uint64) []byte {
+	b := []byte{}
+	for {
+		b = append([]byte{byte(n)}, b...)
+		n >>= 8
+		if n == 0 {
+			break
+		}
+	}
+
+	// Keep the sign bit clear
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+
+	return b
+}
+
+// MakeDERBitString returns the content octets of a BIT STRING with named
+// bits, where bit n of the bitmask is named bit n. Trailing zero bits are
+// removed as DER requires.
+func MakeDERBitString(bits uint32) []byte {
+	if bits == 0 {
+		return []byte{0}
+	}
+
+	last := 0
+	for i := 0; i < 32; i++ {
+		if bits&(1<<uint(i)) != 0 {
+			last = i
+		}
+	}
+
+	b := make([]byte, last/8+2)
+	b[0] = byte(7 - last%8)
+	for i := 0; i <= last; i++ {
+		if bits&(1<<uint(i)) != 0 {
+			b[1+i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+
+	return b
+}
+
+// GetDER reads a tag, length and content off the front of a byte slice, and
+// returns the tag, the content and the remainder. On error, the remainder is
+// the unread input.
+func GetDER(b []byte) (byte, []byte, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, b, &conderr.SyntaxError{Msg: "error parsing DER"}
+	}
+
+	tag := b[0]
+	if tag&0x1f == 0x1f {
+		return 0, nil, b, &conderr.SyntaxError{Msg: "DER tag numbers above 30 are not supported"}
+	}
+
+	// Four length bytes overflow an int on 32-bit platforms, so the length
+	// is only converted once it is known to fit in the input
+	length := uint64(b[1])
+	content := b[2:]
+
+	if length&0x80 != 0 {
+		n := int(length & 0x7f)
+		// Indefinite lengths are not allowed in DER
+		if n == 0 || n > 4 || n > len(content) {
+			return 0, nil, b, &conderr.SyntaxError{Offset: 1, Msg: "error parsing DER length"}
+		}
+
+		if content[0] == 0 {
+			return 0, nil, b, &conderr.SyntaxError{Offset: 1, Msg: "DER length is not minimal"}
+		}
+
+		length = 0
+		for _, c := range content[:n] {
+			length = length<<8 | uint64(c)
+		}
+		content = content[n:]
+
+		if length < 0x80 {
+			return 0, nil, b, &conderr.SyntaxError{Offset: 1, Msg: "DER length is not minimal"}
+		}
+	}
+
+	if length > uint64(len(content)) {
+		return 0, nil, b, &conderr.SyntaxError{Offset: 1, Msg: "error parsing DER length"}
+	}
+
+	return tag, content[:length], content[length:], nil
+}
+
+// ReadDER reads one tag, length and content from r, without reading past
+// them, and returns the whole encoding. Encodings longer than maxLength are
+// rejected before their content is read.
+func ReadDER(r io.Reader, maxLength int) ([]byte, error) {
+	header := make([]byte, 2, 6)
+	if err := readFullDER(r, header, 0); err != nil {
+		return nil, err
+	}
+
+	length := uint64(header[1])
+	if length&0x80 != 0 {
+		n := int(length & 0x7f)
+		if n == 0 || n > 4 {
+			return nil, &conderr.SyntaxError{Offset: 1, Msg: "error parsing DER length"}
+		}
+
+		header = header[:2+n]
+		if err := readFullDER(r, header[2:], 2); err != nil {
+			return nil, err
+		}
+
+		length = 0
+		for _, c := range header[2:] {
+			length = length<<8 | uint64(c)
+		}
+	}
+
+	if uint64(len(header))+length > uint64(maxLength) {
+		return nil, &conderr.SyntaxError{Offset: 1, Msg: "DER encoding is longer than " + strconv.Itoa(maxLength) + " bytes"}
+	}
+
+	b := make([]byte, len(header)+int(length))
+	copy(b, header)
+	if err := readFullDER(r, b[len(header):], len(header)); err != nil {
+		return nil, err
+	}
+
+	// Checks the rules of DER that weren't needed to find the end
+	if _, _, _, err := GetDER(b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Reads exactly len(b) bytes, reporting missing input as malformed DER
+func readFullDER(r io.Reader, b []byte, offset int) error {
+	_, err := io.ReadFull(r, b)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &conderr.SyntaxError{Offset: offset, Msg: "error parsing DER"}
+	}
+	return err
+}
+
+// GetDERUint parses the content octets of a non-negative INTEGER
+func GetDERUint(b []byte) (uint64, error) {
+	if len(b) == 0 {
+		return 0, &conderr.SyntaxError{Msg: "error parsing DER integer"}
+	}
+
+	if b[0]&0x80 != 0 {
+		return 0, &conderr.SyntaxError{Msg: "DER integer is negative"}
+	}
+
+	if len(b) > 1 && b[0] == 0 && b[1]&0x80 == 0 {
+		return 0, &conderr.SyntaxError{Msg: "DER integer is not minimal"}
+	}
+
+	if b[0] == 0 {
+		b = b[1:]
+	}
+
+	if len(b) > 8 {
+		return 0, &conderr.SyntaxError{Msg: "DER integer is too large"}
+	}
+
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+
+	return n, nil
+}
+
+// GetDERBitString parses the content octets of a BIT STRING with named
+// bits into a bitmask, as written by MakeDERBitString.
+func GetDERBitString(b []byte) (uint32, error) {
+	if len(b) == 0 || b[0] > 7 || (len(b) == 1 && b[0] != 0) {
+		return 0, &conderr.SyntaxError{Msg: "error parsing DER bit string"}
+	}
+
+	if len(b) > 5 {
+		return 0, &conderr.SyntaxError{Msg: "DER bit string is too long"}
+	}
+
+	unused := uint(b[0])
+	b = b[1:]
+
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	// Unused bits must be zero, and the last used bit must be set
+	last := b[len(b)-1]
+	if last&(1<<unused-1) != 0 || last&(1<<unused) == 0 {
+		return 0, &conderr.SyntaxError{Msg: "DER bit string is not minimal"}
+	}
+
+	var bits uint32
+	for i := 0; i < len(b)*8-int(unused); i++ {
+		if b[i/8]&(0x80>>uint(i%8)) != 0 {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, nil
+}
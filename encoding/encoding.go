@@ -4,7 +4,6 @@ package encoding
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 )
 
 // Regex for validating fulfillments
@@ -28,29 +27,28 @@ func MakeVarbyte(buf []byte) []byte {
 	return b
 }
 
+// GetUvarint reads a uvarint off the front of a byte slice, and returns it
+// and the remainder. On error, the remainder is the unread input.
 func GetUvarint(b []byte) (uint64, []byte, error) {
-	uv, offset := binary.Uvarint(b)
-	if offset <= 0 {
-		return 0, []byte{}, errors.New("error parsing Uvarint")
+	r := NewReader(b)
+	uv, err := r.ReadUvarint()
+	if err != nil {
+		return 0, b, err
 	}
 
-	b = b[offset:]
-
-	return uv, b, nil
+	return uv, b[r.Offset():], nil
 }
 
+// GetVarbyte reads a varbyte off the front of a byte slice, and returns its
+// content and the remainder. On error, the remainder is the unread input.
 func GetVarbyte(b []byte) ([]byte, []byte, error) {
-	length, offset := binary.Uvarint(b)
-	if offset <= 0 {
-		return []byte{}, []byte{}, errors.New("error parsing Uvarint")
-	}
-
-	if !(uint64(len(b)) > length) {
-		return nil, nil, errors.New("error parsing Varbyte")
+	r := NewReader(b)
+	vb, err := r.ReadVarbyte()
+	if err != nil {
+		return nil, b, err
 	}
-	vb, b := b[offset:][:length], b[offset:][length:]
 
-	return vb, b, nil
+	return vb, b[r.Offset():], nil
 }
 
 // MakeVarray takes a slice of byte slices and returns a byte slice
@@ -66,14 +64,6 @@ func MakeVarray(items [][]byte) []byte {
 
 // ParseVarray takes a byte slice containing a concatenated list
 // of Varbytes, and returns a slice of byte slices
-func ParseVarray(b []byte) [][]byte {
-	arr := [][]byte{}
-	for len(b) > 0 {
-		length, offset := binary.Uvarint(b)
-		b = b[offset:]
-		arr = append(arr, b[:length])
-		b = b[length:]
-	}
-
-	return arr
+func ParseVarray(b []byte) ([][]byte, error) {
+	return NewReader(b).ReadVarray()
 }
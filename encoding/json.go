@@ -0,0 +1,37 @@
+package encoding
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+)
+
+// Bytes is a byte string written to JSON in URL-safe base64, as in the
+// string format. Decoding accepts it with or without padding.
+type Bytes []byte
+
+func (b Bytes) MarshalText() ([]byte, error) {
+	return []byte(base64.URLEncoding.EncodeToString(b)), nil
+}
+
+func (b *Bytes) UnmarshalText(text []byte) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(string(text), "="))
+	if err != nil {
+		return &conderr.SyntaxError{Msg: "not valid base64url"}
+	}
+
+	*b = decoded
+	return nil
+}
+
+// Array copies the bytes into a fixed size array, checking their length.
+func (b Bytes) Array(array []byte) error {
+	if len(b) != len(array) {
+		return &conderr.SyntaxError{Msg: "must be " + strconv.Itoa(len(array)) + " bytes"}
+	}
+
+	copy(array, b)
+	return nil
+}
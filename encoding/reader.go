@@ -0,0 +1,132 @@
+package encoding
+
+import (
+	"encoding/binary"
+	"strconv"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+)
+
+// DefaultMaxLength is the longest varbyte a new Reader accepts.
+const DefaultMaxLength = 1 << 24
+
+// Reader reads the uvarints and varbytes of a binary payload in order. It
+// checks every length against the remaining input and its limit, returning
+// errors instead of panicking on malformed input. The offsets of its errors
+// are relative to the start of the payload, and a failed read consumes
+// nothing.
+type Reader struct {
+	b      []byte
+	offset int
+	// Longest varbyte accepted
+	MaxLength int
+}
+
+// NewReader returns a Reader over the payload.
+func NewReader(b []byte) *Reader {
+	return &Reader{
+		b:         b,
+		MaxLength: DefaultMaxLength,
+	}
+}
+
+// Offset returns the number of bytes read so far.
+func (r *Reader) Offset() int {
+	return r.offset
+}
+
+// Len returns the number of bytes left to read.
+func (r *Reader) Len() int {
+	return len(r.b) - r.offset
+}
+
+func (r *Reader) syntaxError(offset int, msg string) error {
+	return &conderr.SyntaxError{Offset: offset, Msg: msg}
+}
+
+// Reads a uvarint without consuming it, and returns its value and length
+func (r *Reader) peekUvarint() (uint64, int, error) {
+	v, n := binary.Uvarint(r.b[r.offset:])
+	switch {
+	case n == 0:
+		return 0, 0, r.syntaxError(r.offset, "error parsing Uvarint")
+	case n < 0:
+		return 0, 0, r.syntaxError(r.offset, "uvarint overflows 64 bits")
+	case n != len(MakeUvarint(v)):
+		return 0, 0, r.syntaxError(r.offset, "uvarint is not minimal")
+	}
+
+	return v, n, nil
+}
+
+// ReadUvarint reads a uvarint.
+func (r *Reader) ReadUvarint() (uint64, error) {
+	v, n, err := r.peekUvarint()
+	if err != nil {
+		return 0, err
+	}
+
+	r.offset += n
+	return v, nil
+}
+
+// ReadUvarintMax reads a uvarint that must not be larger than max, such as
+// one that is stored in a smaller integer type.
+func (r *Reader) ReadUvarintMax(max uint64) (uint64, error) {
+	v, n, err := r.peekUvarint()
+	if err != nil {
+		return 0, err
+	}
+
+	if v > max {
+		return 0, r.syntaxError(r.offset, "uvarint is larger than "+strconv.FormatUint(max, 10))
+	}
+
+	r.offset += n
+	return v, nil
+}
+
+// ReadVarbyte reads a varbyte, and returns its content. The content refers
+// to the payload.
+func (r *Reader) ReadVarbyte() ([]byte, error) {
+	length, n, err := r.peekUvarint()
+	if err != nil {
+		return nil, err
+	}
+
+	if length > uint64(r.MaxLength) {
+		return nil, r.syntaxError(r.offset, "varbyte is longer than "+strconv.Itoa(r.MaxLength)+" bytes")
+	}
+
+	if length > uint64(r.Len()-n) {
+		return nil, r.syntaxError(r.offset+n, "error parsing Varbyte")
+	}
+
+	start := r.offset + n
+	r.offset = start + int(length)
+	return r.b[start:r.offset:r.offset], nil
+}
+
+// ReadVarray reads varbytes up to the end of the payload.
+func (r *Reader) ReadVarray() ([][]byte, error) {
+	start := r.offset
+	arr := [][]byte{}
+	for r.Len() > 0 {
+		item, err := r.ReadVarbyte()
+		if err != nil {
+			r.offset = start
+			return nil, err
+		}
+		arr = append(arr, item)
+	}
+
+	return arr, nil
+}
+
+// End checks that the whole payload has been read.
+func (r *Reader) End() error {
+	if r.Len() != 0 {
+		return r.syntaxError(r.offset, "trailing data")
+	}
+	return nil
+}
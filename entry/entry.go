@@ -1,72 +1,319 @@
 package entry
 
 import (
-	"errors"
+	"context"
+	"encoding/json"
 	"strings"
 
 	"github.com/jtremback/crypto-conditions/ThresholdSha256"
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/der"
 	"github.com/jtremback/crypto-conditions/ed25519sha256"
+	"github.com/jtremback/crypto-conditions/prefixsha256"
+	"github.com/jtremback/crypto-conditions/registry"
+	"github.com/jtremback/crypto-conditions/rsasha256"
 	"github.com/jtremback/crypto-conditions/sha256"
 )
 
 type Condition interface {
 }
 
-//Interface Layer abstracting over
+//Interface Layer abstracting over the fulfillments of all condition types
 type Fullfillment interface {
 	Serialize() string
-	Fullfill(Condition) bool
 }
 
+// Parses a fulfillment of any registered type out of the Crypto Conditions
+// string format.
 func ParseFullfillment(ful string) (Fullfillment, error) {
+	return registry.ParseFulfillment(ful)
+}
 
-	parts := strings.Split(ful, ":")
-	if len(parts) != 4 {
-		return "", errors.New("parsing error")
-	}
+// Parses like ParseFullfillment, stopping with a *conderr.CanceledError once
+// ctx is done.
+func ParseFullfillmentContext(ctx context.Context, ful string) (Fullfillment, error) {
+	return registry.ParseFulfillmentContext(ctx, ful)
+}
+
+// Parses like ParseFullfillmentContext, rejecting fulfillments that exceed
+// the limits of opts with a *conderr.LimitError.
+func ParseFullfillmentWithOptions(ctx context.Context, ful string, opts *conderr.ValidationOptions) (Fullfillment, error) {
+	return registry.ParseFulfillmentContext(registry.WithOptions(ctx, opts), ful)
+}
+
+// Derives the serialized condition of a fulfillment of any registered type.
+func FulfillmentToCondition(ful string) (string, error) {
+	return registry.FulfillmentToCondition(ful)
+}
+
+// Derives the condition like FulfillmentToCondition, stopping with a
+// *conderr.CanceledError once ctx is done.
+func FulfillmentToConditionContext(ctx context.Context, ful string) (string, error) {
+	return registry.FulfillmentToConditionContext(ctx, ful)
+}
+
+// Checks a fulfillment of any registered type, in the string format, against
+// a message.
+func Validate(ful string, message []byte) error {
+	return registry.Validate(ful, message)
+}
+
+// Checks the fulfillment like Validate, stopping with a
+// *conderr.CanceledError once ctx is done.
+func ValidateContext(ctx context.Context, ful string, message []byte) error {
+	return registry.ValidateContext(ctx, ful, message)
+}
+
+// Checks the fulfillment like ValidateContext, rejecting fulfillments that
+// exceed the limits of opts with a *conderr.LimitError.
+func ValidateWithOptions(ctx context.Context, ful string, message []byte, opts *conderr.ValidationOptions) error {
+	return registry.ValidateContext(registry.WithOptions(ctx, opts), ful, message)
+}
+
+// Checks a fulfillment of any registered type, in the binary format, against
+// a message.
+func ValidateBinary(ful []byte, message []byte) error {
+	return registry.ValidateBinary(ful, message)
+}
+
+// Checks the fulfillment like ValidateBinary, stopping with a
+// *conderr.CanceledError once ctx is done.
+func ValidateBinaryContext(ctx context.Context, ful []byte, message []byte) error {
+	return registry.ValidateBinaryContext(ctx, ful, message)
+}
+
+// Checks the fulfillment like ValidateBinaryContext, rejecting fulfillments
+// that exceed the limits of opts with a *conderr.LimitError.
+func ValidateBinaryWithOptions(ctx context.Context, ful []byte, message []byte, opts *conderr.ValidationOptions) error {
+	return registry.ValidateBinaryContext(registry.WithOptions(ctx, opts), ful, message)
+}
+
+// Checks that a fulfillment of any registered type matches the condition,
+// and that it is valid against the message. Both are in the string format.
+// The cost and subtypes aren't part of the condition, only
+// der.ValidateFulfillment checks them.
+func ValidateFulfillment(cond string, ful string, message []byte) error {
+	return registry.ValidateFulfillment(cond, ful, message)
+}
+
+// Checks the fulfillment like ValidateFulfillment, stopping with a
+// *conderr.CanceledError once ctx is done.
+func ValidateFulfillmentContext(ctx context.Context, cond string, ful string, message []byte) error {
+	return registry.ValidateFulfillmentContext(ctx, cond, ful, message)
+}
+
+// Checks that a fulfillment of any registered type matches the condition,
+// and that it is valid against the message. Both are in the binary format.
+// The cost and subtypes aren't part of the condition, only
+// der.ValidateFulfillment checks them.
+func ValidateBinaryFulfillment(cond []byte, ful []byte, message []byte) error {
+	return registry.ValidateBinaryFulfillment(cond, ful, message)
+}
+
+// Checks the fulfillment like ValidateBinaryFulfillment, stopping with a
+// *conderr.CanceledError once ctx is done.
+func ValidateBinaryFulfillmentContext(ctx context.Context, cond []byte, ful []byte, message []byte) error {
+	return registry.ValidateBinaryFulfillmentContext(ctx, cond, ful, message)
+}
+
+// Returns the cost of validating a fulfillment of any registered type.
+func Cost(ful string) (uint64, error) {
+	return registry.Cost(ful)
+}
+
+// Checks a fulfillment of any registered type, in the string format, against
+// a message, rejecting it without checking signatures if it costs more than
+// maxCost.
+func ValidateWithMaxCost(ful string, message []byte, maxCost uint64) error {
+	return registry.ValidateWithMaxCost(ful, message, maxCost)
+}
+
+// Checks the fulfillment like ValidateWithMaxCost, stopping with a
+// *conderr.CanceledError once ctx is done.
+func ValidateWithMaxCostContext(ctx context.Context, ful string, message []byte, maxCost uint64) error {
+	return registry.ValidateWithMaxCostContext(ctx, ful, message, maxCost)
+}
 
-	if parts[0] != "cf" {
-		return "", errors.New("fulfillments must start with \"cf\"")
+// Checks a fulfillment of any registered type, in the binary format, against
+// a message, rejecting it without checking signatures if it costs more than
+// maxCost.
+func ValidateBinaryWithMaxCost(ful []byte, message []byte, maxCost uint64) error {
+	return registry.ValidateBinaryWithMaxCost(ful, message, maxCost)
+}
+
+// Checks the fulfillment like ValidateBinaryWithMaxCost, stopping with a
+// *conderr.CanceledError once ctx is done.
+func ValidateBinaryWithMaxCostContext(ctx context.Context, ful []byte, message []byte, maxCost uint64) error {
+	return registry.ValidateBinaryWithMaxCostContext(ctx, ful, message, maxCost)
+}
+
+// Converts a fulfillment of any registered type from the Crypto Conditions
+// string format to the equivalent fulfillment of the final crypto-conditions
+// draft. The draft has no weights and no conditions in the string format, so
+// a threshold only converts if all of its entries are fulfillments of weight
+// one. Fulfillments that don't convert are rejected with
+// conderr.ErrNotConvertible.
+func FulfillmentToDER(ful string) (der.Fulfillment, error) {
+	return toDER(registry.WithOptions(context.Background(), nil), ful)
+}
+
+// Derives the ni: URI of the condition of a fulfillment converted with
+// FulfillmentToDER. Conditions in the string format can't be converted, as
+// their fingerprints hash a different encoding than those of ni: URIs; see
+// ConditionToURI for conditions whose contents are known.
+func FulfillmentToURI(ful string) (string, error) {
+	d, err := FulfillmentToDER(ful)
+	if err != nil {
+		return "", err
 	}
 
-	if parts[1] != "1" {
-		return "", errors.New("must be protocol version 1")
+	cond, err := d.Condition()
+	if err != nil {
+		return "", err
 	}
 
-	switch parts[2] {
-	case "1":
-		return Sha256.ParseFulfillment(ful)
-	case "2":
-		return Ed25519Sha256.ParseFulfillment(ful)
-	case "4":
-		return ThresholdSha256.ParseFulfillment(ful)
+	return cond.URI(), nil
+}
+
+// Derives the ni: URI of an Ed25519Sha256 or RsaSha256 condition whose
+// public key or modulus is known, as derived from a fulfillment or read
+// from JSON. Only those types can be converted: a preimage condition is a
+// hash of the preimage, and compound conditions are hashes of their
+// subconditions, all in an encoding other than that of ni: URIs. For the
+// same reason, neither cc: strings, which only carry a fingerprint, nor ni:
+// URIs can be converted to each other. Other conditions are rejected with
+// conderr.ErrNotConvertible.
+func ConditionToURI(cond interface{}) (string, error) {
+	var d *der.Condition
+	var err error
+
+	switch cond := cond.(type) {
+	case *Ed25519Sha256.Condition:
+		d, err = cond.DER()
+	case *RsaSha256.Condition:
+		d, err = cond.DER()
 	default:
-		return "", errors.New("unsupported condition type")
+		return "", conderr.ErrNotConvertible
 	}
+	if err != nil {
+		return "", err
+	}
+
+	return d.URI(), nil
 }
 
-func FulfillmentToCondition(ful string) (string, error) {
-	parts := strings.Split(ful, ":")
-	if len(parts) != 4 {
-		return "", errors.New("parsing error")
+func toDER(ctx context.Context, s string) (der.Fulfillment, error) {
+	f, err := registry.ParseFulfillmentContext(ctx, s)
+	if err != nil {
+		return nil, err
 	}
 
-	if parts[0] != "cf" {
-		return "", errors.New("fulfillments must start with \"cf\"")
+	switch f := f.(type) {
+	case *Sha256.Fulfillment:
+		return f.DER(), nil
+	case *Ed25519Sha256.Fulfillment:
+		return f.DER(), nil
+	case *RsaSha256.Fulfillment:
+		return f.DER(), nil
+	case *PrefixSha256.Fulfillment:
+		sub, err := toDER(ctx, f.SubFulfillment)
+		if err != nil {
+			return nil, conderr.InChild(0, err)
+		}
+
+		return &der.PrefixSha256{
+			Prefix:           f.Prefix,
+			MaxMessageLength: f.MaxMessageLength,
+			SubFulfillment:   sub,
+		}, nil
+	case *ThresholdSha256.Fulfillment:
+		return thresholdToDER(ctx, f)
 	}
 
-	if parts[1] != "1" {
-		return "", errors.New("must be protocol version 1")
+	id, _, _ := registry.SplitFulfillment(s)
+	return nil, &conderr.ParseError{Type: id, Err: conderr.ErrNotConvertible}
+}
+
+// The first Threshold entries are kept as fulfillments, and the others as
+// their conditions
+func thresholdToDER(ctx context.Context, ful *ThresholdSha256.Fulfillment) (der.Fulfillment, error) {
+	entries, err := ful.Entries()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(entries)) < uint64(ful.Threshold) {
+		return nil, &conderr.ParseError{Type: ThresholdSha256.TypeID, Err: conderr.ErrNotConvertible}
 	}
 
-	switch parts[2] {
-	case "1":
-		return Sha256.FulfillmentToCondition(ful)
-	case "2":
-		return Ed25519Sha256.FulfillmentToCondition(ful)
-	case "4":
-		return ThresholdSha256.FulfillmentToCondition(ful)
-	default:
-		return "", errors.New("unsupported condition type")
+	threshold := &der.ThresholdSha256{}
+
+	for i, entry := range entries {
+		if entry.Weight != 1 || !strings.HasPrefix(entry.String, "cf:") {
+			return nil, &conderr.ParseError{Type: ThresholdSha256.TypeID, Err: conderr.ErrNotConvertible}
+		}
+
+		sub, err := toDER(ctx, entry.String)
+		if err != nil {
+			return nil, conderr.InChild(i, err)
+		}
+
+		if len(threshold.SubFulfillments) < int(ful.Threshold) {
+			threshold.SubFulfillments = append(threshold.SubFulfillments, sub)
+			continue
+		}
+
+		cond, err := sub.Condition()
+		if err != nil {
+			return nil, conderr.InChild(i, err)
+		}
+		threshold.SubConditions = append(threshold.SubConditions, cond)
+	}
+
+	return threshold, nil
+}
+
+// Converts a fulfillment or condition of any registered type from the
+// Crypto Conditions string format to its JSON representation.
+func ToJSON(s string) ([]byte, error) {
+	if strings.HasPrefix(s, "cc:") {
+		cond, err := registry.ParseCondition(s)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(cond)
+	}
+
+	return registry.MarshalFulfillmentJSON(s)
+}
+
+// Converts the JSON representation of a fulfillment or condition of any
+// registered type to the Crypto Conditions string format. Conditions are
+// told apart by their fingerprint.
+func FromJSON(b []byte) (string, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return "", err
 	}
+
+	if _, ok := fields["fingerprint"]; ok {
+		cond := &registry.Condition{}
+		if err := json.Unmarshal(b, cond); err != nil {
+			return "", err
+		}
+		return cond.Serialize(), nil
+	}
+
+	return registry.UnmarshalFulfillmentJSON(b)
+}
+
+// Converts a fulfillment from the Crypto Conditions string format to the
+// binary format.
+func FulfillmentToBinary(ful string) ([]byte, error) {
+	return registry.FulfillmentToBinary(ful)
+}
+
+// Converts a fulfillment of any registered type from the binary format to
+// the Crypto Conditions string format.
+func BinaryToFulfillment(ful []byte) (string, error) {
+	return registry.BinaryToFulfillment(ful)
 }
@@ -0,0 +1,248 @@
+package entry
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jtremback/crypto-conditions/ThresholdSha256"
+	"github.com/jtremback/crypto-conditions/der"
+	"github.com/jtremback/crypto-conditions/ed25519sha256"
+	"github.com/jtremback/crypto-conditions/prefixsha256"
+	"github.com/jtremback/crypto-conditions/registry"
+	"github.com/jtremback/crypto-conditions/rsasha256"
+	"github.com/jtremback/crypto-conditions/sha256"
+)
+
+// Node describes a fulfillment or condition, and the subfulfillments and
+// subconditions it is made of.
+type Node struct {
+	// Name of the type
+	Type string
+	// Weight in the parent threshold, 0 for other nodes
+	Weight uint32
+	// Whether the node is a fulfillment rather than a condition
+	Fulfilled bool
+
+	Fingerprint          []byte
+	MaxFulfillmentLength uint64
+	// Cost of validating the fulfillment
+	Cost uint64
+	// Parts of the fulfillment specific to its type
+	Fields []Field
+
+	// Whether the fulfillment was validated, and why it isn't valid
+	Validated bool
+	Err       error
+
+	Children []*Node
+}
+
+// A part of a fulfillment, formatted for display
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Explain describes a fulfillment or condition of any registered type, in
+// the string or ni: URI format, without validating it.
+func Explain(s string) (*Node, error) {
+	return explain(s, 0, nil, false)
+}
+
+// ExplainValidation describes a fulfillment or condition like Explain, and
+// validates every fulfillment in the tree against the message it receives,
+// so that the failing branches can be told apart.
+func ExplainValidation(s string, message []byte) (*Node, error) {
+	return explain(s, 0, message, true)
+}
+
+func explain(s string, weight uint32, message []byte, validate bool) (*Node, error) {
+	switch {
+	case strings.HasPrefix(s, "cc:"):
+		return explainCondition(s, weight)
+	case strings.HasPrefix(s, der.URIPrefix):
+		return explainURI(s)
+	}
+
+	ful, err := registry.ParseFulfillment(s)
+	if err != nil {
+		return nil, err
+	}
+
+	condString, err := registry.FulfillmentToCondition(s)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := explainCondition(condString, weight)
+	if err != nil {
+		return nil, err
+	}
+	node.Fulfilled = true
+
+	node.Cost, err = registry.Cost(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if validate {
+		node.Validated = true
+		node.Err = registry.Validate(s, message)
+	}
+
+	switch ful := ful.(type) {
+	case *Sha256.Fulfillment:
+		node.field("preimage length", strconv.Itoa(len(ful.Preimage)))
+		node.field("preimage", hex.EncodeToString(ful.Preimage))
+
+	case *Ed25519Sha256.Fulfillment:
+		node.field("public key", hex.EncodeToString(ful.PublicKey[:]))
+		node.field("message id", hex.EncodeToString(ful.MessageId))
+		node.field("fixed message", hex.EncodeToString(ful.FixedMessage))
+		node.field("dynamic message", hex.EncodeToString(ful.DynamicMessage))
+		node.field("max dynamic message length", strconv.FormatUint(ful.MaxDynamicMessageLength, 10))
+		node.field("signature", hex.EncodeToString(ful.Signature[:]))
+
+	case *RsaSha256.Fulfillment:
+		node.field("modulus length", strconv.Itoa(len(ful.Modulus)))
+		node.field("modulus", hex.EncodeToString(ful.Modulus))
+		node.field("signature", hex.EncodeToString(ful.Signature))
+
+	case *PrefixSha256.Fulfillment:
+		node.field("prefix", hex.EncodeToString(ful.Prefix))
+		node.field("max message length", strconv.FormatUint(ful.MaxMessageLength, 10))
+
+		// The subfulfillment is validated against the prefixed message
+		child, err := explain(ful.SubFulfillment, 0, append(append([]byte{}, ful.Prefix...), message...), validate)
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, child)
+
+	case *ThresholdSha256.Fulfillment:
+		node.field("threshold", strconv.FormatUint(uint64(ful.Threshold), 10))
+
+		entries, err := ful.Entries()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			child, err := explain(entry.String, entry.Weight, message, validate)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+	}
+
+	return node, nil
+}
+
+func explainCondition(s string, weight uint32) (*Node, error) {
+	cond, err := registry.ParseCondition(s)
+	if err != nil {
+		return nil, err
+	}
+
+	name := "type " + registry.FormatType(cond.Type)
+	if typ, err := registry.Lookup(cond.Type); err == nil {
+		name = typ.Name
+	}
+
+	return &Node{
+		Type:                 name,
+		Weight:               weight,
+		Fingerprint:          cond.Fingerprint,
+		MaxFulfillmentLength: cond.MaxFulfillmentLength,
+	}, nil
+}
+
+func explainURI(s string) (*Node, error) {
+	cond, err := der.ParseURI(s)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &Node{
+		Type:        der.TypeNames[cond.Type],
+		Fingerprint: cond.Fingerprint,
+		Cost:        cond.Cost,
+	}
+
+	if cond.Subtypes != 0 {
+		subtypes := []string{}
+		for typ, name := range der.TypeNames {
+			if cond.Subtypes&(1<<uint(typ)) != 0 {
+				subtypes = append(subtypes, name)
+			}
+		}
+		node.field("subtypes", strings.Join(subtypes, ","))
+	}
+
+	return node, nil
+}
+
+func (node *Node) field(name string, value string) {
+	node.Fields = append(node.Fields, Field{Name: name, Value: value})
+}
+
+// Dump writes the tree as indented text, one line per node or field.
+func (node *Node) Dump(w io.Writer) error {
+	return node.dump(w, "")
+}
+
+func (node *Node) dump(w io.Writer, indent string) error {
+	header := indent
+	if node.Weight != 0 {
+		header += "weight " + strconv.FormatUint(uint64(node.Weight), 10) + ": "
+	}
+
+	header += node.Type
+	if node.Fulfilled {
+		header += " fulfillment"
+	} else {
+		header += " condition"
+	}
+
+	if node.Validated && node.Err == nil {
+		header += ": valid"
+	} else if node.Validated {
+		header += ": invalid: " + node.Err.Error()
+	}
+
+	lines := []string{header}
+	lines = append(lines, indent+"  fingerprint: "+base64.URLEncoding.EncodeToString(node.Fingerprint))
+	if node.MaxFulfillmentLength != 0 {
+		lines = append(lines, indent+"  max fulfillment length: "+strconv.FormatUint(node.MaxFulfillmentLength, 10))
+	}
+	if node.Fulfilled || node.Cost != 0 {
+		lines = append(lines, indent+"  cost: "+strconv.FormatUint(node.Cost, 10))
+	}
+	for _, f := range node.Fields {
+		lines = append(lines, indent+"  "+f.Name+": "+f.Value)
+	}
+
+	if _, err := fmt.Fprintln(w, strings.Join(lines, "\n")); err != nil {
+		return err
+	}
+
+	for _, child := range node.Children {
+		if err := child.dump(w, indent+"    "); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// String returns the tree as written by Dump.
+func (node *Node) String() string {
+	b := &strings.Builder{}
+	node.Dump(b)
+	return b.String()
+}
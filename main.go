@@ -1,2 +1,9 @@
 // Generates and parses Crypto Conditions
+//
+// The binary format numbers types like the string format and the registry:
+// Sha256 is 1, PrefixSha256 2, ThresholdSha256 4, Ed25519Sha256 8 and
+// RsaSha256 10.
+// Before the registry, thresholds were type 2 and raw Ed25519 fulfillments
+// type 4. Such fulfillments must be converted, and raw Ed25519 ones are
+// rejected by Validate.
 package CryptoConditions
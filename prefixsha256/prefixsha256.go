@@ -0,0 +1,425 @@
+// Generates and parses Prefix-Sha256 Crypto Conditions
+package PrefixSha256
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/der"
+	"github.com/jtremback/crypto-conditions/encoding"
+	"github.com/jtremback/crypto-conditions/registry"
+)
+
+const (
+	TypeID      = 2
+	Name        = "PrefixSha256"
+	FeatureBits = registry.FeatureSha256 | registry.FeaturePrefix
+)
+
+func init() {
+	registry.Register(&registry.Type{
+		ID:          TypeID,
+		Name:        Name,
+		FeatureBits: FeatureBits,
+		ParseFulfillment: func(payload []byte) (registry.Fulfillment, error) {
+			return ParsePayload(payload)
+		},
+		FulfillmentToCondition: func(payload []byte) (string, error) {
+			return fulfillmentToCondition(context.Background(), payload)
+		},
+		Validate: Validate,
+		Cost:     Cost,
+		UnmarshalJSON: func(b []byte) (registry.Fulfillment, error) {
+			ful := &Fulfillment{}
+			if err := json.Unmarshal(b, ful); err != nil {
+				return nil, err
+			}
+			return ful, nil
+		},
+		ParseFulfillmentContext: func(ctx context.Context, payload []byte) (registry.Fulfillment, error) {
+			return ParsePayloadContext(ctx, payload)
+		},
+		FulfillmentToConditionContext: fulfillmentToCondition,
+		ValidateContext:               ValidateContext,
+		CostContext:                   CostContext,
+	})
+}
+
+func fulfillmentToCondition(ctx context.Context, payload []byte) (string, error) {
+	// Deriving the condition checks the subfulfillment, so it isn't parsed
+	// beforehand
+	ful, err := parsePayload(payload)
+	if err != nil {
+		return "", err
+	}
+	cond, err := ful.condition(ctx)
+	if err != nil {
+		return "", err
+	}
+	return cond.Serialize(), nil
+}
+
+type Fulfillment struct {
+	Prefix           []byte
+	MaxMessageLength uint64
+	// Fulfillment string of any type, fulfilled with the prefixed message
+	SubFulfillment string
+}
+
+// Serializes to the Crypto Conditions Fulfillment string format.
+func (ful *Fulfillment) Serialize() string {
+	return "cf:1:" + registry.FormatType(TypeID) + ":" + base64.URLEncoding.EncodeToString(ful.payload())
+}
+
+// Binary payload of the fulfillment, as found in the string and binary
+// formats
+func (ful *Fulfillment) payload() []byte {
+	return bytes.Join([][]byte{
+		encoding.MakeVarbyte(ful.Prefix),
+		encoding.MakeUvarint(ful.MaxMessageLength),
+		encoding.MakeVarbyte([]byte(ful.SubFulfillment)),
+	}, []byte{})
+}
+
+// Parses Fulfillment out of the Crypto Conditions string format,
+// and checks the subfulfillment for validity.
+func ParseFulfillment(s string) (*Fulfillment, error) {
+	typ, payload, err := registry.SplitFulfillment(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if typ != TypeID {
+		return nil, &conderr.ParseError{Type: typ, Err: conderr.ErrUnsupportedType}
+	}
+
+	return ParsePayload(payload)
+}
+
+// Parses Fulfillment out of the binary payload, and checks the
+// subfulfillment for validity.
+func ParsePayload(b []byte) (*Fulfillment, error) {
+	ctx, err := registry.Nested(context.Background(), TypeID, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePayloadContext(ctx, b)
+}
+
+// ParsePayloadContext is ParsePayload, within the limits of ctx and stopped
+// with a *conderr.CanceledError once ctx is done. The payload itself must
+// already be accounted for with registry.Nested, as the registry does.
+func ParsePayloadContext(ctx context.Context, b []byte) (*Fulfillment, error) {
+	ful, err := parsePayload(b)
+	if err != nil {
+		return nil, err
+	}
+
+	// The subfulfillment must be a fulfillment of a registered type
+	_, err = registry.FulfillmentToConditionContext(ctx, ful.SubFulfillment)
+	if err != nil {
+		return nil, conderr.InChild(0, err)
+	}
+
+	return ful, nil
+}
+
+// Parses the binary payload without looking into the subfulfillment
+func parsePayload(payload []byte) (*Fulfillment, error) {
+	r := encoding.NewReader(payload)
+
+	prefix, err := r.ReadVarbyte()
+	if err != nil {
+		return nil, conderr.NewParseError(TypeID, 0, err)
+	}
+
+	maxMessageLength, err := r.ReadUvarint()
+	if err != nil {
+		return nil, conderr.NewParseError(TypeID, 0, err)
+	}
+
+	sub, err := r.ReadVarbyte()
+	if err != nil {
+		return nil, conderr.NewParseError(TypeID, 0, err)
+	}
+
+	if err := r.End(); err != nil {
+		return nil, conderr.NewParseError(TypeID, 0, err)
+	}
+
+	ful := &Fulfillment{
+		Prefix:           prefix,
+		MaxMessageLength: maxMessageLength,
+		SubFulfillment:   string(sub),
+	}
+
+	return ful, nil
+}
+
+// Returns the cost of the payload without checking any signatures.
+func Cost(payload []byte) (uint64, error) {
+	ctx, err := registry.Nested(context.Background(), TypeID, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	return CostContext(ctx, payload)
+}
+
+// CostContext is Cost, within the limits of ctx like ParsePayloadContext.
+func CostContext(ctx context.Context, payload []byte) (uint64, error) {
+	ful, err := parsePayload(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	return ful.cost(ctx)
+}
+
+// Cost of the subfulfillment with a message of the maximum length, plus the
+// length of the prefix and a fixed cost
+func (ful *Fulfillment) Cost() (uint64, error) {
+	return ful.cost(context.Background())
+}
+
+func (ful *Fulfillment) cost(ctx context.Context) (uint64, error) {
+	sub, err := registry.CostContext(ctx, ful.SubFulfillment)
+	if err != nil {
+		return 0, conderr.InChild(0, err)
+	}
+
+	return registry.AddCost(uint64(len(ful.Prefix)), ful.MaxMessageLength, sub, der.PrefixCost), nil
+}
+
+// Checks the payload for validity against the message. The subfulfillment
+// must be valid against the message with the prefix prepended. Some types
+// don't use that message: Ed25519Sha256 fulfillments sign the messages they
+// carry, so under a prefix only the length of the message is checked, and
+// neither it nor the prefix is signed.
+func Validate(payload []byte, message []byte) error {
+	ctx, err := registry.Nested(context.Background(), TypeID, payload)
+	if err != nil {
+		return err
+	}
+
+	return ValidateContext(ctx, payload, message)
+}
+
+// ValidateContext is Validate, within the limits of ctx like
+// ParsePayloadContext, and stopped with a *conderr.CanceledError once ctx is
+// done.
+func ValidateContext(ctx context.Context, payload []byte, message []byte) error {
+	// Validating the subfulfillment checks that it is well-formed
+	ful, err := parsePayload(payload)
+	if err != nil {
+		return err
+	}
+
+	if uint64(len(message)) > ful.MaxMessageLength {
+		return &conderr.ValidationError{Type: TypeID, Err: conderr.ErrMessageTooLong}
+	}
+
+	err = registry.ValidateContext(ctx, ful.SubFulfillment, append(append([]byte{}, ful.Prefix...), message...))
+	if err != nil {
+		return conderr.InChild(0, err)
+	}
+
+	return nil
+}
+
+// Turns an in-memory Fulfillment to an in-memory Condition.
+func (ful *Fulfillment) Condition() (Condition, error) {
+	return ful.condition(context.Background())
+}
+
+func (ful *Fulfillment) condition(ctx context.Context) (Condition, error) {
+	subcondition, err := registry.FulfillmentToConditionContext(ctx, ful.SubFulfillment)
+	if err != nil {
+		return Condition{}, conderr.InChild(0, err)
+	}
+
+	cond, err := registry.ParseCondition(subcondition)
+	if err != nil {
+		return Condition{}, err
+	}
+
+	hash := sha256.Sum256(bytes.Join([][]byte{
+		encoding.MakeVarbyte(ful.Prefix),
+		encoding.MakeUvarint(ful.MaxMessageLength),
+		encoding.MakeVarbyte(cond.Binary()),
+	}, []byte{}))
+
+	// The subfulfillment is at most as long as the longer of its condition
+	// and its largest fulfillment
+	sub := uint64(len(subcondition))
+	if cond.MaxFulfillmentLength > sub {
+		sub = cond.MaxFulfillmentLength
+	}
+
+	length := uint64(len(encoding.MakeVarbyte(ful.Prefix))) +
+		uint64(len(encoding.MakeUvarint(ful.MaxMessageLength))) +
+		uint64(len(encoding.MakeUvarint(sub))) + sub
+
+	// The fulfillment is serialized as a base64 string with a header
+	length = uint64(len("cf:1:"+registry.FormatType(TypeID)+":")) + uint64(base64.URLEncoding.EncodedLen(int(length)))
+
+	return Condition{
+		Hash:                 hash,
+		MaxFulfillmentLength: length,
+	}, nil
+}
+
+type Condition struct {
+	Hash                 [32]byte
+	MaxFulfillmentLength uint64
+}
+
+// Serializes to the Crypto Conditions string format.
+func (cond *Condition) Serialize() string {
+	return "cc:1:" + registry.FormatType(TypeID) + ":" + base64.URLEncoding.EncodeToString(cond.Hash[:]) + ":" + strconv.FormatUint(cond.MaxFulfillmentLength, 10)
+}
+
+func FulfillmentToCondition(s string) (string, error) {
+	ful, err := ParseFulfillment(s)
+	if err != nil {
+		return "", err
+	}
+
+	cond, err := ful.Condition()
+	if err != nil {
+		return "", err
+	}
+
+	condString := cond.Serialize()
+	return condString, nil
+}
+
+// JSON representation of the Fulfillment, with the subfulfillment in its
+// own JSON representation
+type jsonFulfillment struct {
+	Type             string          `json:"type"`
+	Prefix           encoding.Bytes  `json:"prefix"`
+	MaxMessageLength uint64          `json:"maxMessageLength"`
+	SubFulfillment   json.RawMessage `json:"subfulfillment"`
+}
+
+func (ful *Fulfillment) MarshalJSON() ([]byte, error) {
+	sub, err := registry.MarshalFulfillmentJSON(ful.SubFulfillment)
+	if err != nil {
+		return nil, conderr.InChild(0, err)
+	}
+
+	return json.Marshal(&jsonFulfillment{
+		Type:             Name,
+		Prefix:           ful.Prefix,
+		MaxMessageLength: ful.MaxMessageLength,
+		SubFulfillment:   sub,
+	})
+}
+
+func (ful *Fulfillment) UnmarshalJSON(b []byte) error {
+	aux := &jsonFulfillment{}
+	if err := json.Unmarshal(b, aux); err != nil {
+		return err
+	}
+
+	if err := registry.CheckTypeName(aux.Type, TypeID); err != nil {
+		return err
+	}
+
+	sub, err := registry.UnmarshalFulfillmentJSON(aux.SubFulfillment)
+	if err != nil {
+		return conderr.InChild(0, err)
+	}
+
+	ful.Prefix = aux.Prefix
+	ful.MaxMessageLength = aux.MaxMessageLength
+	ful.SubFulfillment = sub
+	return nil
+}
+
+// The condition is represented like any condition in the string format.
+func (cond *Condition) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&registry.Condition{
+		Type:                 TypeID,
+		Fingerprint:          cond.Hash[:],
+		MaxFulfillmentLength: cond.MaxFulfillmentLength,
+	})
+}
+
+func (cond *Condition) UnmarshalJSON(b []byte) error {
+	aux := &registry.Condition{}
+	if err := json.Unmarshal(b, aux); err != nil {
+		return err
+	}
+
+	if aux.Type != TypeID {
+		return &conderr.ParseError{Type: aux.Type, Err: conderr.ErrUnsupportedType}
+	}
+
+	if err := encoding.Bytes(aux.Fingerprint).Array(cond.Hash[:]); err != nil {
+		return err
+	}
+	cond.MaxFulfillmentLength = aux.MaxFulfillmentLength
+	return nil
+}
+
+// MarshalBinary writes the fulfillment in the binary format.
+func (ful *Fulfillment) MarshalBinary() ([]byte, error) {
+	return registry.MakeBinaryFulfillment(TypeID, ful.payload()), nil
+}
+
+// UnmarshalBinary reads the fulfillment out of the binary format.
+func (ful *Fulfillment) UnmarshalBinary(b []byte) error {
+	typ, payload, err := registry.SplitBinaryFulfillment(b)
+	if err != nil {
+		return err
+	}
+
+	if typ != TypeID {
+		return &conderr.ParseError{Type: typ, Err: conderr.ErrUnsupportedType}
+	}
+
+	// The fulfillment must not refer to b
+	parsed, err := ParsePayload(append([]byte{}, payload...))
+	if err != nil {
+		return err
+	}
+
+	*ful = *parsed
+	return nil
+}
+
+// MarshalBinary writes the condition in the binary condition format.
+func (cond *Condition) MarshalBinary() ([]byte, error) {
+	c := &registry.Condition{
+		Type:                 TypeID,
+		Fingerprint:          cond.Hash[:],
+		MaxFulfillmentLength: cond.MaxFulfillmentLength,
+	}
+	return c.Binary(), nil
+}
+
+// UnmarshalBinary reads the condition out of the binary condition format.
+func (cond *Condition) UnmarshalBinary(b []byte) error {
+	c, err := registry.ParseBinaryCondition(b)
+	if err != nil {
+		return err
+	}
+
+	if c.Type != TypeID {
+		return &conderr.ParseError{Type: c.Type, Err: conderr.ErrUnsupportedType}
+	}
+
+	if err := encoding.Bytes(c.Fingerprint).Array(cond.Hash[:]); err != nil {
+		return err
+	}
+	cond.MaxFulfillmentLength = c.MaxFulfillmentLength
+	return nil
+}
@@ -0,0 +1,146 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/encoding"
+)
+
+// Condition is the type-independent form of a condition, as found in the
+// Crypto Conditions string format.
+type Condition struct {
+	Type                 uint16
+	Fingerprint          []byte
+	MaxFulfillmentLength uint64
+}
+
+// ParseCondition parses a condition of any type out of the Crypto
+// Conditions string format.
+func ParseCondition(s string) (*Condition, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 5 {
+		return nil, &conderr.SyntaxError{Msg: "conditions must have five parts"}
+	}
+
+	if parts[0] != "cc" {
+		return nil, &conderr.SyntaxError{Msg: "conditions must start with \"cc\""}
+	}
+
+	if parts[1] != "1" {
+		return nil, &conderr.SyntaxError{Offset: len("cc:"), Msg: "must be protocol version 1"}
+	}
+
+	typ, err := ParseType(parts[2])
+	if err != nil {
+		return nil, conderr.AtOffset(len("cc:1:"), err)
+	}
+
+	fingerprint, err := base64.URLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, &conderr.SyntaxError{Offset: len(strings.Join(parts[:3], ":")) + 1, Msg: "fingerprint is not valid base64"}
+	}
+
+	length, err := strconv.ParseUint(parts[4], 10, 64)
+	if err != nil {
+		return nil, &conderr.SyntaxError{Offset: len(s) - len(parts[4]), Msg: "max fulfillment length is not a number"}
+	}
+
+	cond := &Condition{
+		Type:                 typ,
+		Fingerprint:          fingerprint,
+		MaxFulfillmentLength: length,
+	}
+
+	return cond, nil
+}
+
+// Serializes to the Crypto Conditions string format.
+func (cond *Condition) Serialize() string {
+	return "cc:1:" + FormatType(cond.Type) + ":" + base64.URLEncoding.EncodeToString(cond.Fingerprint) + ":" + strconv.FormatUint(cond.MaxFulfillmentLength, 10)
+}
+
+// Binary returns the condition in the binary format: the type, the feature
+// bitmask and the fingerprint as varbytes, and the maximum fulfillment
+// length. This is the layout of CryptoConditions.Condition, which every
+// condition type writes.
+func (cond *Condition) Binary() []byte {
+	return bytes.Join([][]byte{
+		encoding.MakeUvarint(uint64(cond.Type)),
+		encoding.MakeVarbyte(FeatureBitmask(cond.Type)),
+		encoding.MakeVarbyte(cond.Fingerprint),
+		encoding.MakeUvarint(cond.MaxFulfillmentLength),
+	}, []byte{})
+}
+
+// FeatureBitmask returns the feature bitmask of the binary condition format
+// for a type: its feature bits as a uvarint, or nothing if it isn't
+// registered.
+func FeatureBitmask(id uint16) []byte {
+	typ, err := Lookup(id)
+	if err != nil {
+		return nil
+	}
+	return encoding.MakeUvarint(uint64(typ.FeatureBits))
+}
+
+// ParseBinaryCondition parses a condition of any type out of the binary
+// format written by Binary. The feature bitmask must be that of the type if
+// it is registered.
+func ParseBinaryCondition(b []byte) (*Condition, error) {
+	r := encoding.NewReader(b)
+
+	typ, err := r.ReadUvarintMax(0xffff)
+	if err != nil {
+		return nil, err
+	}
+
+	bitmask, err := r.ReadVarbyte()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := Lookup(uint16(typ)); err == nil && !bytes.Equal(bitmask, FeatureBitmask(uint16(typ))) {
+		return nil, &conderr.ParseError{Type: uint16(typ), Err: &conderr.SyntaxError{Msg: "feature bitmask doesn't match the type"}}
+	}
+
+	fingerprint, err := r.ReadVarbyte()
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := r.ReadUvarint()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.End(); err != nil {
+		return nil, err
+	}
+
+	cond := &Condition{
+		Type:                 uint16(typ),
+		Fingerprint:          fingerprint,
+		MaxFulfillmentLength: length,
+	}
+
+	return cond, nil
+}
+
+func (cond *Condition) MarshalBinary() ([]byte, error) {
+	return cond.Binary(), nil
+}
+
+func (cond *Condition) UnmarshalBinary(b []byte) error {
+	parsed, err := ParseBinaryCondition(b)
+	if err != nil {
+		return err
+	}
+
+	*cond = *parsed
+	cond.Fingerprint = append([]byte{}, parsed.Fingerprint...)
+	return nil
+}
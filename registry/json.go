@@ -0,0 +1,129 @@
+package registry
+
+import (
+	"encoding/json"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/encoding"
+)
+
+// JSON representation of a condition. Fulfillments are represented by
+// their type, in the JSON representation of the type's Fulfillment.
+type jsonCondition struct {
+	Type                 string         `json:"type"`
+	Fingerprint          encoding.Bytes `json:"fingerprint"`
+	MaxFulfillmentLength uint64         `json:"maxFulfillmentLength"`
+}
+
+// FormatTypeName returns the type as it appears in the JSON representation:
+// the name of the registered type, or its number in the string format if
+// it isn't registered.
+func FormatTypeName(id uint16) string {
+	typ, err := Lookup(id)
+	if err != nil {
+		return FormatType(id)
+	}
+	return typ.Name
+}
+
+// ParseTypeName parses the type of the JSON representation.
+func ParseTypeName(name string) (uint16, error) {
+	for _, typ := range Types() {
+		if typ.Name == name {
+			return typ.ID, nil
+		}
+	}
+
+	id, err := ParseType(name)
+	if err != nil {
+		return 0, conderr.ErrUnsupportedType
+	}
+	return id, nil
+}
+
+// CheckTypeName checks that the type of the JSON representation is the
+// given one.
+func CheckTypeName(name string, id uint16) error {
+	typ, err := ParseTypeName(name)
+	if err != nil {
+		return err
+	}
+
+	if typ != id {
+		return &conderr.ParseError{Type: typ, Err: conderr.ErrUnsupportedType}
+	}
+	return nil
+}
+
+func (cond *Condition) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&jsonCondition{
+		Type:                 FormatTypeName(cond.Type),
+		Fingerprint:          cond.Fingerprint,
+		MaxFulfillmentLength: cond.MaxFulfillmentLength,
+	})
+}
+
+func (cond *Condition) UnmarshalJSON(b []byte) error {
+	aux := &jsonCondition{}
+	if err := json.Unmarshal(b, aux); err != nil {
+		return err
+	}
+
+	typ, err := ParseTypeName(aux.Type)
+	if err != nil {
+		return err
+	}
+
+	cond.Type = typ
+	cond.Fingerprint = aux.Fingerprint
+	cond.MaxFulfillmentLength = aux.MaxFulfillmentLength
+	return nil
+}
+
+// MarshalFulfillmentJSON converts a fulfillment of any registered type from
+// the string format to the JSON representation.
+func MarshalFulfillmentJSON(s string) ([]byte, error) {
+	ful, err := ParseFulfillment(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := ful.(json.Marshaler); !ok {
+		id, _, _ := SplitFulfillment(s)
+		return nil, &conderr.ParseError{Type: id, Err: conderr.ErrUnsupportedType}
+	}
+
+	return json.Marshal(ful)
+}
+
+// UnmarshalFulfillmentJSON converts a fulfillment of any registered type
+// from the JSON representation to the string format.
+func UnmarshalFulfillmentJSON(b []byte) (string, error) {
+	tag := &struct {
+		Type string `json:"type"`
+	}{}
+	if err := json.Unmarshal(b, tag); err != nil {
+		return "", err
+	}
+
+	id, err := ParseTypeName(tag.Type)
+	if err != nil {
+		return "", err
+	}
+
+	typ, err := Lookup(id)
+	if err != nil {
+		return "", err
+	}
+
+	if typ.UnmarshalJSON == nil {
+		return "", &conderr.ParseError{Type: id, Err: conderr.ErrUnsupportedType}
+	}
+
+	ful, err := typ.UnmarshalJSON(b)
+	if err != nil {
+		return "", err
+	}
+
+	return ful.Serialize(), nil
+}
@@ -0,0 +1,127 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+)
+
+type limitsKey struct{}
+
+// Limits carried by the context from a fulfillment to its subfulfillments
+type limits struct {
+	opts conderr.ValidationOptions
+	// Depth of the fulfillment the context belongs to, -1 above the
+	// top-level one
+	depth   int
+	decoded *decoded
+	// Goroutines running validations besides the caller's, shared by the
+	// whole tree. Nil if the subfulfillments are validated one after the
+	// other.
+	pool chan struct{}
+}
+
+// Payloads decoded so far, shared by the whole tree. Subfulfillments are
+// decoded again by every operation that looks into them, but each payload
+// only counts once.
+type decoded struct {
+	mu    sync.Mutex
+	bytes int
+	seen  map[[sha256.Size]byte]bool
+}
+
+// Accounts for the payload if it wasn't seen before, and returns the bytes
+// decoded so far
+func (d *decoded) add(payload []byte) int {
+	key := sha256.Sum256(payload)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.seen[key] {
+		d.seen[key] = true
+		d.bytes += len(payload)
+	}
+
+	return d.bytes
+}
+
+// WithOptions returns a context that applies the limits of opts to the
+// fulfillments parsed or validated with it, and to their subfulfillments.
+// Fulfillments exceeding them are rejected with a *conderr.LimitError. The
+// functions given a context without options apply the defaults.
+func WithOptions(ctx context.Context, opts *conderr.ValidationOptions) context.Context {
+	l := &limits{
+		opts:    opts.WithDefaults(),
+		depth:   -1,
+		decoded: &decoded{seen: map[[sha256.Size]byte]bool{}},
+	}
+
+	// The caller's goroutine is one of the workers
+	if l.opts.Workers > 1 {
+		l.pool = make(chan struct{}, l.opts.Workers-1)
+	}
+
+	return context.WithValue(ctx, limitsKey{}, l)
+}
+
+// Options returns the options that apply to the fulfillments parsed or
+// validated with ctx.
+func Options(ctx context.Context) conderr.ValidationOptions {
+	if l, ok := ctx.Value(limitsKey{}).(*limits); ok {
+		return l.opts
+	}
+	return (*conderr.ValidationOptions)(nil).WithDefaults()
+}
+
+// Nested accounts for a fulfillment of the given type and payload, within
+// the limits of ctx, and returns the context of its subfulfillments. The
+// registry does so for each fulfillment it parses, derives, costs or
+// validates, so types only need it for the functions they export without a
+// context, which start a new tree.
+func Nested(ctx context.Context, id uint16, payload []byte) (context.Context, error) {
+	l, ok := ctx.Value(limitsKey{}).(*limits)
+	if !ok {
+		ctx = WithOptions(ctx, nil)
+		l = ctx.Value(limitsKey{}).(*limits)
+	}
+
+	if l.depth+1 > l.opts.MaxDepth {
+		return nil, &conderr.ParseError{Type: id, Err: &conderr.LimitError{Limit: conderr.LimitDepth, Max: l.opts.MaxDepth}}
+	}
+
+	if l.decoded.add(payload) > l.opts.MaxDecodedBytes {
+		return nil, &conderr.ParseError{Type: id, Err: &conderr.LimitError{Limit: conderr.LimitDecodedBytes, Max: l.opts.MaxDecodedBytes}}
+	}
+
+	return context.WithValue(ctx, limitsKey{}, &limits{
+		opts:    l.opts,
+		depth:   l.depth + 1,
+		decoded: l.decoded,
+		pool:    l.pool,
+	}), nil
+}
+
+// Go runs f in a new goroutine if the options of ctx allow another worker,
+// and returns whether it did. Otherwise the caller is expected to run f
+// itself, which keeps nested thresholds from waiting on each other for
+// workers.
+func Go(ctx context.Context, f func()) bool {
+	l, ok := ctx.Value(limitsKey{}).(*limits)
+	if !ok || l.pool == nil {
+		return false
+	}
+
+	select {
+	case l.pool <- struct{}{}:
+		go func() {
+			defer func() { <-l.pool }()
+			f()
+		}()
+		return true
+	default:
+		return false
+	}
+}
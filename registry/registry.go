@@ -0,0 +1,567 @@
+// Keeps track of the Crypto Condition types known to the library, and
+// dispatches parsing, condition derivation and validation to them.
+//
+// Each condition type package registers itself from an init function, so
+// importing a type package is enough to make it available to every entry
+// point. Third-party types can be added the same way by calling Register.
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/encoding"
+)
+
+// Feature bits, as used in the condition feature bitmask
+const (
+	FeatureSha256    = 0x01
+	FeaturePreimage  = 0x02
+	FeaturePrefix    = 0x04
+	FeatureThreshold = 0x08
+	FeatureRsaPss    = 0x10
+	FeatureEd25519   = 0x20
+)
+
+// Fulfillment is implemented by the in-memory fulfillment of every
+// condition type.
+type Fulfillment interface {
+	Serialize() string
+}
+
+// Type describes a condition type. All functions operate on the binary
+// payload of a fulfillment, which is the base64-decoded last part of the
+// string format and the varbyte following the type in the binary format.
+type Type struct {
+	ID          uint16
+	Name        string
+	FeatureBits uint32
+
+	// Parses the payload into an in-memory Fulfillment.
+	ParseFulfillment func(payload []byte) (Fulfillment, error)
+	// Derives the serialized condition from the payload.
+	FulfillmentToCondition func(payload []byte) (string, error)
+	// Checks the payload for validity against a message.
+	Validate func(payload []byte, message []byte) error
+	// Computes the cost of validating the payload. This must be cheap
+	// compared to validation, and must not check signatures.
+	Cost func(payload []byte) (uint64, error)
+	// Decodes the JSON representation of a fulfillment. Optional, types
+	// without it can't be read from JSON.
+	UnmarshalJSON func(b []byte) (Fulfillment, error)
+
+	// Context-aware versions of ParseFulfillment, FulfillmentToCondition,
+	// Validate and Cost. Optional, for types with subfulfillments, which pass
+	// the context on, so that its limits apply to the whole tree, and check
+	// it with conderr.Canceled between them. Other types are only checked
+	// for cancellation before they start.
+	ParseFulfillmentContext       func(ctx context.Context, payload []byte) (Fulfillment, error)
+	FulfillmentToConditionContext func(ctx context.Context, payload []byte) (string, error)
+	ValidateContext               func(ctx context.Context, payload []byte, message []byte) error
+	CostContext                   func(ctx context.Context, payload []byte) (uint64, error)
+}
+
+func (typ *Type) parseFulfillment(ctx context.Context, payload []byte) (Fulfillment, error) {
+	if err := conderr.Canceled(ctx); err != nil {
+		return nil, err
+	}
+
+	ctx, err := Nested(ctx, typ.ID, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if typ.ParseFulfillmentContext != nil {
+		return typ.ParseFulfillmentContext(ctx, payload)
+	}
+	return typ.ParseFulfillment(payload)
+}
+
+func (typ *Type) fulfillmentToCondition(ctx context.Context, payload []byte) (string, error) {
+	if err := conderr.Canceled(ctx); err != nil {
+		return "", err
+	}
+
+	ctx, err := Nested(ctx, typ.ID, payload)
+	if err != nil {
+		return "", err
+	}
+
+	if typ.FulfillmentToConditionContext != nil {
+		return typ.FulfillmentToConditionContext(ctx, payload)
+	}
+	return typ.FulfillmentToCondition(payload)
+}
+
+func (typ *Type) validate(ctx context.Context, payload []byte, message []byte) error {
+	if err := conderr.Canceled(ctx); err != nil {
+		return err
+	}
+
+	ctx, err := Nested(ctx, typ.ID, payload)
+	if err != nil {
+		return err
+	}
+
+	if typ.ValidateContext != nil {
+		return typ.ValidateContext(ctx, payload, message)
+	}
+	return typ.Validate(payload, message)
+}
+
+func (typ *Type) cost(ctx context.Context, payload []byte) (uint64, error) {
+	if err := conderr.Canceled(ctx); err != nil {
+		return 0, err
+	}
+
+	ctx, err := Nested(ctx, typ.ID, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	if typ.CostContext != nil {
+		return typ.CostContext(ctx, payload)
+	}
+	return typ.Cost(payload)
+}
+
+var (
+	mu    sync.RWMutex
+	types = map[uint16]*Type{}
+)
+
+// Register makes a condition type available to the library. It panics if
+// the type is incomplete or if a type with the same ID is already
+// registered.
+func Register(typ *Type) {
+	if typ == nil || typ.ParseFulfillment == nil || typ.FulfillmentToCondition == nil || typ.Validate == nil || typ.Cost == nil {
+		panic("registry: incomplete condition type")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if dup, ok := types[typ.ID]; ok {
+		panic(fmt.Sprintf("registry: type %d already registered as %s", typ.ID, dup.Name))
+	}
+	types[typ.ID] = typ
+}
+
+// Lookup returns the registered type with the given ID.
+func Lookup(id uint16) (*Type, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	typ, ok := types[id]
+	if !ok {
+		return nil, &conderr.ParseError{Type: id, Err: conderr.ErrUnsupportedType}
+	}
+	return typ, nil
+}
+
+// Types returns all registered types, ordered by ID.
+func Types() []*Type {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	ts := make([]*Type, 0, len(types))
+	for _, typ := range types {
+		ts = append(ts, typ)
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i].ID < ts[j].ID })
+
+	return ts
+}
+
+// FormatType returns the type as it appears in the string format.
+func FormatType(id uint16) string {
+	return strconv.FormatUint(uint64(id), 16)
+}
+
+// ParseType parses the type part of the string format.
+func ParseType(s string) (uint16, error) {
+	id, err := strconv.ParseUint(s, 16, 16)
+	if err != nil {
+		return 0, &conderr.SyntaxError{Msg: "type is not a 16 bit hex number"}
+	}
+	return uint16(id), nil
+}
+
+// SplitFulfillment checks the header of the Crypto Conditions fulfillment
+// string format, and returns the type and the decoded payload.
+func SplitFulfillment(s string) (uint16, []byte, error) {
+	return SplitFulfillmentContext(context.Background(), s)
+}
+
+// SplitFulfillmentContext is SplitFulfillment, rejecting payloads that
+// decode to more than the MaxDecodedBytes of the options of ctx with a
+// *conderr.LimitError before decoding them.
+func SplitFulfillmentContext(ctx context.Context, s string) (uint16, []byte, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 4 {
+		return 0, nil, &conderr.SyntaxError{Msg: "fulfillments must have four parts"}
+	}
+
+	if parts[0] != "cf" {
+		return 0, nil, &conderr.SyntaxError{Msg: "fulfillments must start with \"cf\""}
+	}
+
+	if parts[1] != "1" {
+		return 0, nil, &conderr.SyntaxError{Offset: len("cf:"), Msg: "must be protocol version 1"}
+	}
+
+	id, err := ParseType(parts[2])
+	if err != nil {
+		return 0, nil, conderr.AtOffset(len("cf:1:"), err)
+	}
+
+	// The payload isn't decoded if it can't fit in the limit, leaving out
+	// the padding, which decodes to nothing
+	max := Options(ctx).MaxDecodedBytes
+	if base64.URLEncoding.DecodedLen(len(parts[3]))-(len(parts[3])-len(strings.TrimRight(parts[3], "="))) > max {
+		return 0, nil, &conderr.ParseError{Type: id, Err: &conderr.LimitError{Limit: conderr.LimitDecodedBytes, Max: max}}
+	}
+
+	payload, err := base64.URLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return 0, nil, &conderr.SyntaxError{Offset: len(s) - len(parts[3]), Msg: "payload is not valid base64"}
+	}
+
+	return id, payload, nil
+}
+
+// SplitBinaryFulfillment reads the type and the payload out of the binary
+// fulfillment format.
+func SplitBinaryFulfillment(b []byte) (uint16, []byte, error) {
+	r := encoding.NewReader(b)
+
+	id, err := r.ReadUvarintMax(0xffff)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	payload, err := r.ReadVarbyte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := r.End(); err != nil {
+		return 0, nil, err
+	}
+
+	return uint16(id), payload, nil
+}
+
+// MakeBinaryFulfillment writes the type and the payload in the binary
+// fulfillment format.
+func MakeBinaryFulfillment(id uint16, payload []byte) []byte {
+	return bytes.Join([][]byte{
+		encoding.MakeUvarint(uint64(id)),
+		encoding.MakeVarbyte(payload),
+	}, []byte{})
+}
+
+// FulfillmentToBinary converts a fulfillment from the string format to the
+// binary format.
+func FulfillmentToBinary(s string) ([]byte, error) {
+	id, payload, err := SplitFulfillment(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return MakeBinaryFulfillment(id, payload), nil
+}
+
+// BinaryToFulfillment converts a fulfillment of any registered type from
+// the binary format to the string format, checking that it parses.
+func BinaryToFulfillment(b []byte) (string, error) {
+	id, payload, err := SplitBinaryFulfillment(b)
+	if err != nil {
+		return "", err
+	}
+
+	typ, err := Lookup(id)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := typ.parseFulfillment(context.Background(), payload); err != nil {
+		return "", err
+	}
+
+	return "cf:1:" + FormatType(id) + ":" + base64.URLEncoding.EncodeToString(payload), nil
+}
+
+// ParseFulfillment parses a fulfillment of any registered type out of the
+// string format.
+func ParseFulfillment(s string) (Fulfillment, error) {
+	return ParseFulfillmentContext(context.Background(), s)
+}
+
+// ParseFulfillmentContext is ParseFulfillment, stopped with a
+// *conderr.CanceledError once ctx is done.
+func ParseFulfillmentContext(ctx context.Context, s string) (Fulfillment, error) {
+	id, payload, err := SplitFulfillmentContext(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	typ, err := Lookup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return typ.parseFulfillment(ctx, payload)
+}
+
+// FulfillmentToCondition derives the serialized condition of a fulfillment
+// of any registered type.
+func FulfillmentToCondition(s string) (string, error) {
+	return FulfillmentToConditionContext(context.Background(), s)
+}
+
+// FulfillmentToConditionContext is FulfillmentToCondition, stopped with a
+// *conderr.CanceledError once ctx is done.
+func FulfillmentToConditionContext(ctx context.Context, s string) (string, error) {
+	id, payload, err := SplitFulfillmentContext(ctx, s)
+	if err != nil {
+		return "", err
+	}
+
+	typ, err := Lookup(id)
+	if err != nil {
+		return "", err
+	}
+
+	return typ.fulfillmentToCondition(ctx, payload)
+}
+
+// Validate checks a fulfillment of any registered type, in the string
+// format, against a message.
+func Validate(s string, message []byte) error {
+	return ValidateContext(context.Background(), s, message)
+}
+
+// ValidateContext is Validate, stopped with a *conderr.CanceledError once
+// ctx is done.
+func ValidateContext(ctx context.Context, s string, message []byte) error {
+	id, payload, err := SplitFulfillmentContext(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	typ, err := Lookup(id)
+	if err != nil {
+		return err
+	}
+
+	return typ.validate(ctx, payload, message)
+}
+
+// ValidateBinary checks a fulfillment of any registered type, in the binary
+// format, against a message.
+func ValidateBinary(b []byte, message []byte) error {
+	return ValidateBinaryContext(context.Background(), b, message)
+}
+
+// ValidateBinaryContext is ValidateBinary, stopped with a
+// *conderr.CanceledError once ctx is done.
+func ValidateBinaryContext(ctx context.Context, b []byte, message []byte) error {
+	id, payload, err := SplitBinaryFulfillment(b)
+	if err != nil {
+		return err
+	}
+
+	typ, err := Lookup(id)
+	if err != nil {
+		return err
+	}
+
+	return typ.validate(ctx, payload, message)
+}
+
+// AddCost adds costs together, saturating instead of overflowing.
+func AddCost(costs ...uint64) uint64 {
+	var total uint64
+	for _, c := range costs {
+		if total+c < total {
+			return math.MaxUint64
+		}
+		total += c
+	}
+	return total
+}
+
+// Cost returns the cost of validating a fulfillment of any registered type,
+// in the string format.
+func Cost(s string) (uint64, error) {
+	return CostContext(context.Background(), s)
+}
+
+// CostContext is Cost, within the limits of ctx and stopped with a
+// *conderr.CanceledError once ctx is done.
+func CostContext(ctx context.Context, s string) (uint64, error) {
+	id, payload, err := SplitFulfillmentContext(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+
+	typ, err := Lookup(id)
+	if err != nil {
+		return 0, err
+	}
+
+	return typ.cost(ctx, payload)
+}
+
+// ValidateWithMaxCost checks a fulfillment of any registered type, in the
+// string format, against a message. Fulfillments that cost more than
+// maxCost are rejected before any signature is checked.
+func ValidateWithMaxCost(s string, message []byte, maxCost uint64) error {
+	return ValidateWithMaxCostContext(context.Background(), s, message, maxCost)
+}
+
+// ValidateWithMaxCostContext is ValidateWithMaxCost, stopped with a
+// *conderr.CanceledError once ctx is done.
+func ValidateWithMaxCostContext(ctx context.Context, s string, message []byte, maxCost uint64) error {
+	id, payload, err := SplitFulfillmentContext(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	return validateWithMaxCost(ctx, id, payload, message, maxCost)
+}
+
+// ValidateBinaryWithMaxCost is ValidateWithMaxCost for the binary format.
+func ValidateBinaryWithMaxCost(b []byte, message []byte, maxCost uint64) error {
+	return ValidateBinaryWithMaxCostContext(context.Background(), b, message, maxCost)
+}
+
+// ValidateBinaryWithMaxCostContext is ValidateBinaryWithMaxCost, stopped
+// with a *conderr.CanceledError once ctx is done.
+func ValidateBinaryWithMaxCostContext(ctx context.Context, b []byte, message []byte, maxCost uint64) error {
+	id, payload, err := SplitBinaryFulfillment(b)
+	if err != nil {
+		return err
+	}
+
+	return validateWithMaxCost(ctx, id, payload, message, maxCost)
+}
+
+func validateWithMaxCost(ctx context.Context, id uint16, payload []byte, message []byte, maxCost uint64) error {
+	typ, err := Lookup(id)
+	if err != nil {
+		return err
+	}
+
+	cost, err := typ.cost(ctx, payload)
+	if err != nil {
+		return err
+	}
+
+	if cost > maxCost {
+		return &conderr.ValidationError{Type: id, Err: conderr.ErrCostExceeded}
+	}
+
+	return typ.validate(ctx, payload, message)
+}
+
+// ValidateFulfillment checks that a fulfillment of any registered type
+// matches the condition, and that it is valid against the message. Both are
+// in the string format. The type and fingerprint must match, and the
+// maximum fulfillment length derived from the fulfillment must not exceed
+// the condition's. Mismatches are reported as a *conderr.MismatchError.
+// Conditions of these formats carry no cost or subtypes, so unlike
+// der.ValidateFulfillment, neither is checked against the condition. The
+// fingerprint still covers the subconditions.
+func ValidateFulfillment(cond string, ful string, message []byte) error {
+	return ValidateFulfillmentContext(context.Background(), cond, ful, message)
+}
+
+// ValidateFulfillmentContext is ValidateFulfillment, stopped with a
+// *conderr.CanceledError once ctx is done.
+func ValidateFulfillmentContext(ctx context.Context, cond string, ful string, message []byte) error {
+	c, err := ParseCondition(cond)
+	if err != nil {
+		return err
+	}
+
+	id, payload, err := SplitFulfillmentContext(ctx, ful)
+	if err != nil {
+		return err
+	}
+
+	return validateFulfillment(ctx, c, id, payload, message)
+}
+
+// ValidateBinaryFulfillment is ValidateFulfillment for the binary formats.
+func ValidateBinaryFulfillment(cond []byte, ful []byte, message []byte) error {
+	return ValidateBinaryFulfillmentContext(context.Background(), cond, ful, message)
+}
+
+// ValidateBinaryFulfillmentContext is ValidateBinaryFulfillment, stopped
+// with a *conderr.CanceledError once ctx is done.
+func ValidateBinaryFulfillmentContext(ctx context.Context, cond []byte, ful []byte, message []byte) error {
+	c, err := ParseBinaryCondition(cond)
+	if err != nil {
+		return err
+	}
+
+	id, payload, err := SplitBinaryFulfillment(ful)
+	if err != nil {
+		return err
+	}
+
+	return validateFulfillment(ctx, c, id, payload, message)
+}
+
+func validateFulfillment(ctx context.Context, cond *Condition, id uint16, payload []byte, message []byte) error {
+	if id != cond.Type {
+		return &conderr.MismatchError{
+			Field:    conderr.FieldType,
+			Expected: FormatType(cond.Type),
+			Actual:   FormatType(id),
+		}
+	}
+
+	typ, err := Lookup(id)
+	if err != nil {
+		return err
+	}
+
+	s, err := typ.fulfillmentToCondition(ctx, payload)
+	if err != nil {
+		return err
+	}
+
+	derived, err := ParseCondition(s)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(derived.Fingerprint, cond.Fingerprint) {
+		return &conderr.MismatchError{
+			Field:    conderr.FieldFingerprint,
+			Expected: base64.URLEncoding.EncodeToString(cond.Fingerprint),
+			Actual:   base64.URLEncoding.EncodeToString(derived.Fingerprint),
+		}
+	}
+
+	if derived.MaxFulfillmentLength > cond.MaxFulfillmentLength {
+		return &conderr.MismatchError{
+			Field:    conderr.FieldLength,
+			Expected: strconv.FormatUint(cond.MaxFulfillmentLength, 10),
+			Actual:   strconv.FormatUint(derived.MaxFulfillmentLength, 10),
+		}
+	}
+
+	return typ.validate(ctx, payload, message)
+}
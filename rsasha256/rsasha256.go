@@ -0,0 +1,389 @@
+// Generates and parses Rsa-Sha256 Crypto Conditions, which use RSA-PSS
+// signatures.
+package RsaSha256
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/der"
+	"github.com/jtremback/crypto-conditions/encoding"
+	"github.com/jtremback/crypto-conditions/registry"
+)
+
+const (
+	TypeID      = 0x10
+	Name        = "RsaSha256"
+	FeatureBits = registry.FeatureSha256 | registry.FeatureRsaPss
+)
+
+func init() {
+	registry.Register(&registry.Type{
+		ID:          TypeID,
+		Name:        Name,
+		FeatureBits: FeatureBits,
+		ParseFulfillment: func(payload []byte) (registry.Fulfillment, error) {
+			return ParsePayload(payload)
+		},
+		FulfillmentToCondition: func(payload []byte) (string, error) {
+			ful, err := ParsePayload(payload)
+			if err != nil {
+				return "", err
+			}
+			cond := ful.Condition()
+			return cond.Serialize(), nil
+		},
+		Validate: Validate,
+		Cost:     Cost,
+		UnmarshalJSON: func(b []byte) (registry.Fulfillment, error) {
+			ful := &Fulfillment{}
+			if err := json.Unmarshal(b, ful); err != nil {
+				return nil, err
+			}
+			return ful, nil
+		},
+	})
+}
+
+type Fulfillment struct {
+	Modulus   []byte
+	Signature []byte
+}
+
+// Serializes to the Crypto Conditions Fulfillment string format.
+func (ful *Fulfillment) Serialize() string {
+	return "cf:1:" + registry.FormatType(TypeID) + ":" + base64.URLEncoding.EncodeToString(ful.payload())
+}
+
+// Binary payload of the fulfillment, as found in the string and binary
+// formats
+func (ful *Fulfillment) payload() []byte {
+	return bytes.Join([][]byte{
+		encoding.MakeVarbyte(ful.Modulus),
+		encoding.MakeVarbyte(ful.Signature),
+	}, []byte{})
+}
+
+// Signs the message with an RSA key whose modulus is between 128 and 512
+// bytes long, and sets the modulus to that of the key.
+func (ful *Fulfillment) Sign(privkey *rsa.PrivateKey, message []byte) error {
+	d := &der.RsaSha256{}
+	err := d.Sign(privkey, message)
+	if err != nil {
+		return err
+	}
+
+	ful.Modulus = d.Modulus
+	ful.Signature = d.Signature
+
+	return nil
+}
+
+// Parses Fulfillment out of the Crypto Conditions string format.
+// The signature is checked when validating against a message.
+func ParseFulfillment(s string) (*Fulfillment, error) {
+	typ, payload, err := registry.SplitFulfillment(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if typ != TypeID {
+		return nil, &conderr.ParseError{Type: typ, Err: conderr.ErrUnsupportedType}
+	}
+
+	return ParsePayload(payload)
+}
+
+// Parses Fulfillment out of the binary payload.
+func ParsePayload(payload []byte) (*Fulfillment, error) {
+	r := encoding.NewReader(payload)
+
+	modulus, err := r.ReadVarbyte()
+	if err != nil {
+		return nil, conderr.NewParseError(TypeID, 0, err)
+	}
+
+	if len(modulus) < der.RsaMinModulusLength || len(modulus) > der.RsaMaxModulusLength {
+		return nil, conderr.NewParseError(TypeID, 0, &conderr.SyntaxError{Msg: "modulus must be between 128 and 512 bytes"})
+	}
+
+	signature, err := r.ReadVarbyte()
+	if err != nil {
+		return nil, conderr.NewParseError(TypeID, 0, err)
+	}
+
+	if err := r.End(); err != nil {
+		return nil, conderr.NewParseError(TypeID, 0, err)
+	}
+
+	ful := &Fulfillment{
+		Modulus:   modulus,
+		Signature: signature,
+	}
+
+	return ful, nil
+}
+
+// Checks the payload for validity, including the signature over the message.
+func Validate(payload []byte, message []byte) error {
+	ful, err := ParsePayload(payload)
+	if err != nil {
+		return err
+	}
+
+	return ful.DER().Validate(message)
+}
+
+// Returns the cost of the payload without checking the signature.
+func Cost(payload []byte) (uint64, error) {
+	ful, err := ParsePayload(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	return ful.Cost(), nil
+}
+
+// Cost of checking the signature, which is the square of the modulus
+// length.
+func (ful *Fulfillment) Cost() uint64 {
+	return ful.DER().Cost()
+}
+
+// Converts to the DER encodable RSA fulfillment of the final
+// crypto-conditions draft.
+func (ful *Fulfillment) DER() *der.RsaSha256 {
+	return &der.RsaSha256{
+		Modulus:   ful.Modulus,
+		Signature: ful.Signature,
+	}
+}
+
+// Turns an in-memory Fulfillment to an in-memory Condition. The signature
+// is as long as the modulus, so the length of the fulfillment is known.
+func (ful *Fulfillment) Condition() Condition {
+	payload := len(encoding.MakeVarbyte(ful.Modulus)) * 2
+
+	return Condition{
+		Modulus:              ful.Modulus,
+		MaxFulfillmentLength: uint64(len("cf:1:"+registry.FormatType(TypeID)+":") + base64.URLEncoding.EncodedLen(payload)),
+	}
+}
+
+type Condition struct {
+	Modulus              []byte
+	MaxFulfillmentLength uint64
+	// Fingerprint of a condition read from the binary format, or from JSON
+	// without the modulus, which is left empty: those formats may only
+	// carry the fingerprint. Nil for conditions made of the modulus.
+	Fingerprint []byte
+}
+
+// Serializes to the Crypto Conditions string format.
+func (cond *Condition) Serialize() string {
+	hash := cond.fingerprint()
+	return "cc:1:" + registry.FormatType(TypeID) + ":" + base64.URLEncoding.EncodeToString(hash[:]) + ":" + strconv.FormatUint(cond.MaxFulfillmentLength, 10)
+}
+
+// Hash of the modulus, unless only the fingerprint is known
+func (cond *Condition) fingerprint() [32]byte {
+	if cond.Fingerprint != nil {
+		var hash [32]byte
+		copy(hash[:], cond.Fingerprint)
+		return hash
+	}
+
+	return sha256.Sum256(encoding.MakeVarbyte(cond.Modulus))
+}
+
+// Converts to the condition of the DER encodable RSA fulfillment of the
+// final crypto-conditions draft. Conditions known by their fingerprint only
+// are rejected with conderr.ErrNotConvertible, as the draft hashes a
+// different encoding of the modulus.
+func (cond *Condition) DER() (*der.Condition, error) {
+	if cond.Modulus == nil {
+		return nil, &conderr.ParseError{Type: TypeID, Err: conderr.ErrNotConvertible}
+	}
+
+	return (&der.RsaSha256{Modulus: cond.Modulus}).Condition()
+}
+
+func FulfillmentToCondition(s string) (string, error) {
+	ful, err := ParseFulfillment(s)
+	if err != nil {
+		return "", err
+	}
+
+	cond := ful.Condition()
+
+	condString := cond.Serialize()
+	return condString, nil
+}
+
+// JSON representation of the Fulfillment
+type jsonFulfillment struct {
+	Type      string         `json:"type"`
+	Modulus   encoding.Bytes `json:"modulus"`
+	Signature encoding.Bytes `json:"signature"`
+}
+
+func (ful *Fulfillment) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&jsonFulfillment{
+		Type:      Name,
+		Modulus:   ful.Modulus,
+		Signature: ful.Signature,
+	})
+}
+
+// Reads the JSON representation, checking the modulus like ParsePayload.
+func (ful *Fulfillment) UnmarshalJSON(b []byte) error {
+	aux := &jsonFulfillment{}
+	if err := json.Unmarshal(b, aux); err != nil {
+		return err
+	}
+
+	if err := registry.CheckTypeName(aux.Type, TypeID); err != nil {
+		return err
+	}
+
+	if len(aux.Modulus) < der.RsaMinModulusLength || len(aux.Modulus) > der.RsaMaxModulusLength {
+		return &conderr.ParseError{Type: TypeID, Err: &conderr.SyntaxError{Msg: "modulus must be between 128 and 512 bytes"}}
+	}
+
+	ful.Modulus = aux.Modulus
+	ful.Signature = aux.Signature
+	return nil
+}
+
+// JSON representation of the Condition: that of any condition in the
+// string format, along with the modulus the fingerprint is derived from,
+// which the Condition can't do without
+type jsonCondition struct {
+	Type                 string         `json:"type"`
+	Fingerprint          encoding.Bytes `json:"fingerprint"`
+	MaxFulfillmentLength uint64         `json:"maxFulfillmentLength"`
+	Modulus              encoding.Bytes `json:"modulus"`
+}
+
+func (cond *Condition) MarshalJSON() ([]byte, error) {
+	if cond.Fingerprint != nil {
+		return json.Marshal(cond.generic())
+	}
+
+	hash := cond.fingerprint()
+	return json.Marshal(&jsonCondition{
+		Type:                 Name,
+		Fingerprint:          hash[:],
+		MaxFulfillmentLength: cond.MaxFulfillmentLength,
+		Modulus:              cond.Modulus,
+	})
+}
+
+// UnmarshalJSON reads the representation written by MarshalJSON. Conditions
+// represented by their fingerprint only, as entry.ToJSON writes them, are
+// read into the Fingerprint.
+func (cond *Condition) UnmarshalJSON(b []byte) error {
+	aux := &jsonCondition{}
+	if err := json.Unmarshal(b, aux); err != nil {
+		return err
+	}
+
+	if err := registry.CheckTypeName(aux.Type, TypeID); err != nil {
+		return err
+	}
+
+	if aux.Modulus == nil {
+		return cond.fromGeneric(&registry.Condition{
+			Type:                 TypeID,
+			Fingerprint:          aux.Fingerprint,
+			MaxFulfillmentLength: aux.MaxFulfillmentLength,
+		})
+	}
+
+	parsed := Condition{
+		Modulus:              aux.Modulus,
+		MaxFulfillmentLength: aux.MaxFulfillmentLength,
+	}
+
+	hash := parsed.fingerprint()
+	if !bytes.Equal(aux.Fingerprint, hash[:]) {
+		return &conderr.ParseError{Type: TypeID, Err: &conderr.SyntaxError{Msg: "fingerprint doesn't match the modulus"}}
+	}
+
+	*cond = parsed
+	return nil
+}
+
+// MarshalBinary writes the fulfillment in the binary format.
+func (ful *Fulfillment) MarshalBinary() ([]byte, error) {
+	return registry.MakeBinaryFulfillment(TypeID, ful.payload()), nil
+}
+
+// UnmarshalBinary reads the fulfillment out of the binary format.
+func (ful *Fulfillment) UnmarshalBinary(b []byte) error {
+	typ, payload, err := registry.SplitBinaryFulfillment(b)
+	if err != nil {
+		return err
+	}
+
+	if typ != TypeID {
+		return &conderr.ParseError{Type: typ, Err: conderr.ErrUnsupportedType}
+	}
+
+	// The fulfillment must not refer to b
+	parsed, err := ParsePayload(append([]byte{}, payload...))
+	if err != nil {
+		return err
+	}
+
+	*ful = *parsed
+	return nil
+}
+
+// MarshalBinary writes the condition in the binary condition format, which
+// only carries the fingerprint.
+func (cond *Condition) MarshalBinary() ([]byte, error) {
+	return cond.generic().Binary(), nil
+}
+
+// UnmarshalBinary reads the condition out of the binary condition format,
+// into the Fingerprint.
+func (cond *Condition) UnmarshalBinary(b []byte) error {
+	c, err := registry.ParseBinaryCondition(b)
+	if err != nil {
+		return err
+	}
+
+	return cond.fromGeneric(c)
+}
+
+// The condition as any condition in the string format
+func (cond *Condition) generic() *registry.Condition {
+	hash := cond.fingerprint()
+	return &registry.Condition{
+		Type:                 TypeID,
+		Fingerprint:          hash[:],
+		MaxFulfillmentLength: cond.MaxFulfillmentLength,
+	}
+}
+
+// Reads a condition known by its fingerprint only
+func (cond *Condition) fromGeneric(c *registry.Condition) error {
+	if c.Type != TypeID {
+		return &conderr.ParseError{Type: c.Type, Err: conderr.ErrUnsupportedType}
+	}
+	if len(c.Fingerprint) != sha256.Size {
+		return &conderr.ParseError{Type: TypeID, Err: &conderr.SyntaxError{Msg: "fingerprint must be 32 bytes"}}
+	}
+
+	*cond = Condition{
+		MaxFulfillmentLength: c.MaxFulfillmentLength,
+		Fingerprint:          append([]byte{}, c.Fingerprint...),
+	}
+	return nil
+}
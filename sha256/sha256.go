@@ -5,13 +5,49 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/base64"
-	"errors"
+	"encoding/json"
 	"strconv"
-	"strings"
 
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/der"
 	"github.com/jtremback/crypto-conditions/encoding"
+	"github.com/jtremback/crypto-conditions/registry"
 )
 
+const (
+	TypeID      = 1
+	Name        = "Sha256"
+	FeatureBits = registry.FeatureSha256 | registry.FeaturePreimage
+)
+
+func init() {
+	registry.Register(&registry.Type{
+		ID:          TypeID,
+		Name:        Name,
+		FeatureBits: FeatureBits,
+		ParseFulfillment: func(payload []byte) (registry.Fulfillment, error) {
+			return ParsePayload(payload)
+		},
+		FulfillmentToCondition: func(payload []byte) (string, error) {
+			ful, err := ParsePayload(payload)
+			if err != nil {
+				return "", err
+			}
+			cond := ful.Condition()
+			return cond.Serialize(), nil
+		},
+		Validate: Validate,
+		Cost:     Cost,
+		UnmarshalJSON: func(b []byte) (registry.Fulfillment, error) {
+			ful := &Fulfillment{}
+			if err := json.Unmarshal(b, ful); err != nil {
+				return nil, err
+			}
+			return ful, nil
+		},
+	})
+}
+
 type Fulfillment struct {
 	Preimage             []byte
 	MaxFulfillmentLength uint64
@@ -24,34 +60,48 @@ func (ful *Fulfillment) Serialize() string {
 
 // Parses Fulfillment out of the Crypto Conditions string format, and checks it for validity.
 func ParseFulfillment(s string) (*Fulfillment, error) {
-	parts := strings.Split(s, ":")
-	if len(parts) != 4 {
-		return nil, errors.New("parsing error")
+	typ, payload, err := registry.SplitFulfillment(s)
+	if err != nil {
+		return nil, err
 	}
 
-	if parts[0] != "cf" {
-		return nil, errors.New("fulfillments must start with \"cf\"")
+	if typ != TypeID {
+		return nil, &conderr.ParseError{Type: typ, Err: conderr.ErrUnsupportedType}
 	}
 
-	if parts[1] != "1" {
-		return nil, errors.New("must be protocol version 1")
-	}
+	return ParsePayload(payload)
+}
 
-	if parts[2] != "1" {
-		return nil, errors.New("not an Sha256 condition")
+// Parses Fulfillment out of the binary payload. The payload of a Sha256
+// fulfillment is the preimage itself.
+func ParsePayload(payload []byte) (*Fulfillment, error) {
+	ful := &Fulfillment{
+		Preimage: payload,
 	}
 
-	// Get Preimage
-	pre, err := base64.URLEncoding.DecodeString(parts[3])
+	return ful, nil
+}
+
+// Checks the payload for validity. A preimage fulfills its condition
+// regardless of the message.
+func Validate(payload []byte, message []byte) error {
+	_, err := ParsePayload(payload)
+	return err
+}
+
+// Returns the cost of the payload, which is the length of the preimage.
+func Cost(payload []byte) (uint64, error) {
+	ful, err := ParsePayload(payload)
 	if err != nil {
-		return nil, errors.New("parsing error")
+		return 0, err
 	}
 
-	ful := &Fulfillment{
-		Preimage: pre,
-	}
+	return ful.Cost(), nil
+}
 
-	return ful, nil
+// Cost of the preimage, which is its length
+func (ful *Fulfillment) Cost() uint64 {
+	return uint64(len(ful.Preimage))
 }
 
 //Turns an in-memory Fulfillment to an in-memory Condition. If the MaxFulfillmentLength is
@@ -75,6 +125,14 @@ func (ful *Fulfillment) Condition() Condition {
 	}
 }
 
+// Converts to the DER encodable preimage fulfillment of the final
+// crypto-conditions draft.
+func (ful *Fulfillment) DER() *der.PreimageSha256 {
+	return &der.PreimageSha256{
+		Preimage: ful.Preimage,
+	}
+}
+
 type Condition struct {
 	Hash                 [32]byte
 	MaxFulfillmentLength uint64
@@ -96,3 +154,113 @@ func FulfillmentToCondition(s string) (string, error) {
 	condString := cond.Serialize()
 	return condString, nil
 }
+
+// JSON representation of the Fulfillment
+type jsonFulfillment struct {
+	Type                 string         `json:"type"`
+	Preimage             encoding.Bytes `json:"preimage"`
+	MaxFulfillmentLength uint64         `json:"maxFulfillmentLength,omitempty"`
+}
+
+func (ful *Fulfillment) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&jsonFulfillment{
+		Type:                 Name,
+		Preimage:             ful.Preimage,
+		MaxFulfillmentLength: ful.MaxFulfillmentLength,
+	})
+}
+
+func (ful *Fulfillment) UnmarshalJSON(b []byte) error {
+	aux := &jsonFulfillment{}
+	if err := json.Unmarshal(b, aux); err != nil {
+		return err
+	}
+
+	if err := registry.CheckTypeName(aux.Type, TypeID); err != nil {
+		return err
+	}
+
+	ful.Preimage = aux.Preimage
+	ful.MaxFulfillmentLength = aux.MaxFulfillmentLength
+	return nil
+}
+
+// The condition is represented like any condition in the string format.
+func (cond *Condition) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&registry.Condition{
+		Type:                 TypeID,
+		Fingerprint:          cond.Hash[:],
+		MaxFulfillmentLength: cond.MaxFulfillmentLength,
+	})
+}
+
+func (cond *Condition) UnmarshalJSON(b []byte) error {
+	aux := &registry.Condition{}
+	if err := json.Unmarshal(b, aux); err != nil {
+		return err
+	}
+
+	if aux.Type != TypeID {
+		return &conderr.ParseError{Type: aux.Type, Err: conderr.ErrUnsupportedType}
+	}
+
+	if err := encoding.Bytes(aux.Fingerprint).Array(cond.Hash[:]); err != nil {
+		return err
+	}
+	cond.MaxFulfillmentLength = aux.MaxFulfillmentLength
+	return nil
+}
+
+// MarshalBinary writes the fulfillment in the binary format.
+func (ful *Fulfillment) MarshalBinary() ([]byte, error) {
+	return registry.MakeBinaryFulfillment(TypeID, ful.Preimage), nil
+}
+
+// UnmarshalBinary reads the fulfillment out of the binary format.
+func (ful *Fulfillment) UnmarshalBinary(b []byte) error {
+	typ, payload, err := registry.SplitBinaryFulfillment(b)
+	if err != nil {
+		return err
+	}
+
+	if typ != TypeID {
+		return &conderr.ParseError{Type: typ, Err: conderr.ErrUnsupportedType}
+	}
+
+	// The fulfillment must not refer to b
+	parsed, err := ParsePayload(append([]byte{}, payload...))
+	if err != nil {
+		return err
+	}
+
+	*ful = *parsed
+	return nil
+}
+
+// MarshalBinary writes the condition in the binary condition format.
+func (cond *Condition) MarshalBinary() ([]byte, error) {
+	c := &registry.Condition{
+		Type:                 TypeID,
+		Fingerprint:          cond.Hash[:],
+		MaxFulfillmentLength: cond.MaxFulfillmentLength,
+	}
+	return c.Binary(), nil
+}
+
+// UnmarshalBinary reads the condition out of the binary condition format.
+func (cond *Condition) UnmarshalBinary(b []byte) error {
+	c, err := registry.ParseBinaryCondition(b)
+	if err != nil {
+		return err
+	}
+
+	if c.Type != TypeID {
+		return &conderr.ParseError{Type: c.Type, Err: conderr.ErrUnsupportedType}
+	}
+
+	if err := encoding.Bytes(c.Fingerprint).Array(cond.Hash[:]); err != nil {
+		return err
+	}
+	cond.MaxFulfillmentLength = c.MaxFulfillmentLength
+	return nil
+}
@@ -0,0 +1,120 @@
+package signer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"os"
+)
+
+// FileSigner is a reference crypto.Signer for an Ed25519 key stored in a
+// PKCS #8 PEM file, as written by WriteKeyFile or by
+// "openssl genpkey -algorithm ed25519". The key is read from the file for
+// every signature rather than kept in memory.
+type FileSigner struct {
+	path   string
+	public ed25519.PublicKey
+}
+
+// NewFileSigner returns a signer for the key in the file.
+func NewFileSigner(path string) (*FileSigner, error) {
+	key, err := readKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSigner{
+		path:   path,
+		public: key.Public().(ed25519.PublicKey),
+	}, nil
+}
+
+// Public returns the ed25519.PublicKey of the key in the file.
+func (s *FileSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign signs the message with the key in the file, which must not have
+// changed since the signer was created.
+func (s *FileSigner) Sign(rand io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	key, err := readKeyFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(key)
+
+	if !bytes.Equal(key.Public().(ed25519.PublicKey), s.public) {
+		return nil, errors.New("key in " + s.path + " has changed")
+	}
+
+	return key.Sign(rand, message, opts)
+}
+
+// WriteKeyFile writes the key to a new file, readable only by its owner.
+func WriteKeyFile(path string, key ed25519.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+var errNoKey = errors.New("no PEM encoded private key")
+
+func readKeyFile(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(b)
+
+	key, err := ParseKey(b)
+	if err == errNoKey {
+		return nil, errors.New(errNoKey.Error() + " in " + path)
+	}
+	return key, err
+}
+
+// ParseKey parses an Ed25519 key in a PKCS #8 PEM block, in the format of
+// the files read by NewFileSigner.
+func ParseKey(b []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errNoKey
+	}
+	defer zero(block.Bytes)
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, ErrNotEd25519
+	}
+
+	return key, nil
+}
+
+// Overwrites key material once it is no longer needed
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,54 @@
+// Signs fulfillments with keys that may be kept outside of the process,
+// such as in an HSM, a KMS or a signing agent, through crypto.Signer.
+package signer
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+)
+
+// ErrNotEd25519 is returned for signers whose key isn't an Ed25519 key.
+var ErrNotEd25519 = errors.New("signer key is not an Ed25519 key")
+
+// Ed25519PublicKey returns the public key of the signer, which must be an
+// Ed25519 key.
+func Ed25519PublicKey(s crypto.Signer) ([32]byte, error) {
+	var pubkey [32]byte
+
+	pub, ok := s.Public().(ed25519.PublicKey)
+	if !ok || len(pub) != len(pubkey) {
+		return pubkey, ErrNotEd25519
+	}
+
+	copy(pubkey[:], pub)
+	return pubkey, nil
+}
+
+// SignEd25519 signs the message with the signer, and returns the public
+// key and the signature. The signature is checked before it is returned,
+// so that a faulty signer can't produce fulfillments that don't validate.
+func SignEd25519(s crypto.Signer, message []byte) ([32]byte, [64]byte, error) {
+	var signature [64]byte
+
+	pubkey, err := Ed25519PublicKey(s)
+	if err != nil {
+		return pubkey, signature, err
+	}
+
+	// Ed25519 signs the message itself rather than a digest
+	sig, err := s.Sign(rand.Reader, message, crypto.Hash(0))
+	if err != nil {
+		return pubkey, signature, err
+	}
+
+	if len(sig) != len(signature) || !ed25519.Verify(pubkey[:], message, sig) {
+		return pubkey, signature, conderr.ErrInvalidSignature
+	}
+
+	copy(signature[:], sig)
+	return pubkey, signature, nil
+}
@@ -1,14 +1,33 @@
 package test
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	encodingpkg "encoding"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/agl/ed25519"
+	"github.com/jtremback/crypto-conditions"
 	"github.com/jtremback/crypto-conditions/ThresholdSha256"
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/der"
 	"github.com/jtremback/crypto-conditions/ed25519sha256"
 	"github.com/jtremback/crypto-conditions/encoding"
+	"github.com/jtremback/crypto-conditions/entry"
+	"github.com/jtremback/crypto-conditions/prefixsha256"
+	"github.com/jtremback/crypto-conditions/registry"
+	"github.com/jtremback/crypto-conditions/rsasha256"
 	"github.com/jtremback/crypto-conditions/sha256"
 )
 
@@ -40,13 +59,104 @@ func TestEncoding(t *testing.T) {
 	}
 	fmt.Println(seri)
 
-	deseri := encoding.ParseVarray(seri)
+	deseri, err := encoding.ParseVarray(seri)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if !reflect.DeepEqual(deseri, [][]byte{[]byte{1, 1, 1, 1, 1}, []byte{2, 2, 2}, []byte{3, 3, 3, 3}}) {
 		t.Fatal(deseri)
 	}
 	fmt.Println(deseri)
 }
 
+func TestReader(t *testing.T) {
+	// A varbyte may end exactly at the end of the input
+	vb, rest, err := encoding.GetVarbyte([]byte{1, 7})
+	if err != nil || !bytes.Equal(vb, []byte{7}) || len(rest) != 0 {
+		t.Fatal("varbyte at the end of the input not read", vb, rest, err)
+	}
+
+	malformed := [][]byte{
+		// Truncated content
+		{5, 1},
+		// Truncated length
+		{0x80},
+		// Length larger than the input
+		{0xff, 0xff, 0xff, 0xff, 0x0f, 1},
+		// Length overflowing 64 bits
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+		// Non-minimal length
+		{0x81, 0x00, 1},
+	}
+	for _, b := range malformed {
+		if _, err := encoding.ParseVarray(b); !errors.Is(err, conderr.ErrMalformed) {
+			t.Fatal("malformed varray not detected", b, err)
+		}
+	}
+
+	r := encoding.NewReader([]byte{2, 1, 2, 0xac, 0x02, 9})
+	if vb, err := r.ReadVarbyte(); err != nil || !bytes.Equal(vb, []byte{1, 2}) {
+		t.Fatal("varbyte not read", vb, err)
+	}
+	if _, err := r.ReadUvarintMax(255); err == nil || r.Offset() != 3 {
+		t.Fatal("uvarint over the maximum not detected", err, r.Offset())
+	}
+	if n, err := r.ReadUvarint(); err != nil || n != 300 {
+		t.Fatal("uvarint not read", n, err)
+	}
+
+	var syntaxErr *conderr.SyntaxError
+	if err := r.End(); !errors.As(err, &syntaxErr) || syntaxErr.Offset != 5 {
+		t.Fatal("trailing data not detected", err)
+	}
+
+	r = encoding.NewReader([]byte{3, 1, 2, 3})
+	r.MaxLength = 2
+	if _, err := r.ReadVarbyte(); !errors.Is(err, conderr.ErrMalformed) {
+		t.Fatal("varbyte over the limit not detected", err)
+	}
+
+	// Malformed payloads are rejected by the parsers without panicking
+	payloads := map[uint16][]byte{
+		ThresholdSha256.TypeID: {1, 5, 1},
+		PrefixSha256.TypeID:    {0, 0xff},
+		Ed25519Sha256.TypeID:   append(encoding.MakeVarbyte(pubkey1[:]), 0, 0, 0, 0, 0x40),
+		RsaSha256.TypeID:       {0xff, 0xff, 0xff, 0xff, 0x0f},
+	}
+	for id, payload := range payloads {
+		s := "cf:1:" + registry.FormatType(id) + ":" + base64.URLEncoding.EncodeToString(payload)
+		if err := entry.Validate(s, nil); !errors.Is(err, conderr.ErrMalformed) {
+			t.Fatal("malformed payload not detected", id, err)
+		}
+	}
+
+	shaFul := &Sha256.Fulfillment{
+		Preimage: []byte{42},
+	}
+	edFul := &Ed25519Sha256.Fulfillment{
+		PublicKey: pubkey1,
+	}
+	edFul.Sign(privkey1)
+	thrFul := &ThresholdSha256.Fulfillment{
+		Threshold: 1,
+		SubFulfillments: ThresholdSha256.WeightedStrings{
+			{Weight: 1, String: shaFul.Serialize()},
+		},
+	}
+
+	for _, ful := range []registry.Fulfillment{edFul, thrFul} {
+		_, payload, err := registry.SplitFulfillment(ful.Serialize())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		trailing := ful.Serialize()[:strings.LastIndex(ful.Serialize(), ":")+1] + base64.URLEncoding.EncodeToString(append(payload, 0))
+		if _, err := registry.Cost(trailing); !errors.Is(err, conderr.ErrMalformed) {
+			t.Fatal("trailing data not detected", trailing, err)
+		}
+	}
+}
+
 func TestSha256Fulfillment(t *testing.T) {
 	ful := &Sha256.Fulfillment{
 		Preimage: []byte{42},
@@ -149,6 +259,40 @@ func TestEd25519Sha256Fulfillment(t *testing.T) {
 		fmt.Println(cond1)
 		fmt.Println(cond2)
 	}
+
+	// The dynamic message can't be longer than its maximum length
+	long := &Ed25519Sha256.Fulfillment{
+		PublicKey:               pubkey1,
+		DynamicMessage:          []byte{1, 2},
+		MaxDynamicMessageLength: 1,
+	}
+	long.Sign(privkey1)
+	if _, err := Ed25519Sha256.ParseFulfillment(long.Serialize()); !errors.Is(err, conderr.ErrMalformed) {
+		t.Fatal("dynamic message longer than the maximum parsed", err)
+	}
+	if err := entry.Validate(long.Serialize(), nil); !errors.Is(err, conderr.ErrMalformed) {
+		t.Fatal("dynamic message longer than the maximum validated", err)
+	}
+	b, err := json.Marshal(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &Ed25519Sha256.Fulfillment{}); !errors.Is(err, conderr.ErrMalformed) {
+		t.Fatal("dynamic message longer than the maximum read from JSON", err)
+	}
+
+	// Signing leaves the fixed message alone, even with room after it
+	fixed := make([]byte, 1, 8)
+	shared := &Ed25519Sha256.Fulfillment{
+		PublicKey:               pubkey1,
+		FixedMessage:            fixed,
+		DynamicMessage:          []byte{7},
+		MaxDynamicMessageLength: 1,
+	}
+	shared.Sign(privkey1)
+	if fixed[:2][1] != 0 {
+		t.Fatal("signing wrote past the fixed message")
+	}
 }
 
 func TestThresholdSha256Fulfillment(t *testing.T) {
@@ -201,10 +345,1838 @@ func TestThresholdSha256Fulfillment(t *testing.T) {
 	}
 
 	thrCondString := thrCond.Serialize()
-	fmt.Println(thrCondString)
+
+	thrFulString := thrFul.Serialize()
+	if !strings.HasPrefix(thrFulString, "cf:1:4:") || !strings.HasPrefix(thrCondString, "cc:1:4:") {
+		t.Fatal("serialization incorrect", thrFulString, thrCondString)
+	}
+
+	parsed, err := ThresholdSha256.ParseFulfillment(thrFulString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Threshold != 80 || len(parsed.SubConditions) != 2 || len(parsed.SubFulfillments) != 2 {
+		t.Fatal(errors.New("parsed fulfillment doesn't match"))
+	}
+	if parsed.Serialize() != thrFulString {
+		t.Fatal(errors.New("serialized fulfillment doesn't match"))
+	}
+
+	condString, err := entry.FulfillmentToCondition(thrFulString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if condString != thrCondString {
+		t.Fatal(errors.New("serialized condition doesn't match"), condString, thrCondString)
+	}
+
+	// Order of the subconditions doesn't change the condition
+	thrFul.SubConditions[0], thrFul.SubConditions[1] = thrFul.SubConditions[1], thrFul.SubConditions[0]
+	swappedCond, err := thrFul.Condition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if swappedCond.Serialize() != thrCondString {
+		t.Fatal(errors.New("condition depends on subcondition order"))
+	}
+
+	if err := entry.Validate(thrFulString, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The deprecated root wrappers read and write the same payload
+	_, payload, err := registry.SplitFulfillment(thrFulString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := CryptoConditions.ParseThresholdSha256Fulfillment(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Threshold != 80 || len(old.SubFulfillments) != 2 || !bytes.Equal(old.Serialize(), payload) {
+		t.Fatal(errors.New("deprecated fulfillment doesn't match"))
+	}
+	if oldCond := old.Condition(); !bytes.Equal(oldCond.Fingerprint, thrCond.Hash[:]) || oldCond.MaxFulfillmentLength != thrCond.MaxFulfillmentLength {
+		t.Fatal(errors.New("deprecated condition doesn't match"))
+	}
+	if entries, err := CryptoConditions.ParseWeightedStrings(payload[1:]); err != nil || len(entries) != 2 {
+		t.Fatal("deprecated entries don't match", err)
+	}
+	if err := CryptoConditions.ThresholdSha256Validate(payload, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Subfulfillments that can't be matched to a subcondition aren't
+	// written twice, as a fulfillment and as a condition
+	malformed := ThresholdSha256.Fulfillment{
+		Threshold:     1,
+		SubConditions: thrFul.SubConditions,
+		SubFulfillments: ThresholdSha256.WeightedStrings{
+			{Weight: 60, String: shaFulString},
+			{Weight: 20, String: "cf:1:8:AA=="},
+		},
+	}
+	var parseErr *conderr.ParseError
+	if _, err := malformed.Entries(); !errors.As(err, &parseErr) || !reflect.DeepEqual(parseErr.Path, []int{1}) {
+		t.Fatal("malformed subfulfillment not reported", err)
+	}
+	if _, err := malformed.MarshalBinary(); !errors.Is(err, conderr.ErrMalformed) {
+		t.Fatal("malformed subfulfillment written", err)
+	}
+	if _, err := json.Marshal(&malformed); !errors.Is(err, conderr.ErrMalformed) {
+		t.Fatal("malformed subfulfillment written to JSON", err)
+	}
+	if s := malformed.Serialize(); s != "" {
+		t.Fatal("malformed subfulfillment serialized", s)
+	}
+
+	// Only the preimage is fulfilled, which isn't enough
+	partial := ThresholdSha256.Fulfillment{
+		Threshold:     80,
+		SubConditions: thrFul.SubConditions,
+		SubFulfillments: ThresholdSha256.WeightedStrings{
+			ThresholdSha256.WeightedString{
+				Weight: 60,
+				String: shaFulString,
+			},
+		},
+	}
+	partialCond, err := partial.Condition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if partialCond.Serialize() != thrCondString {
+		t.Fatal(errors.New("partial fulfillment has a different condition"))
+	}
+	if err := entry.Validate(partial.Serialize(), nil); err == nil {
+		t.Fatal(errors.New("validated without enough fulfillments"))
+	}
+
+	// Subfulfillments must belong to a subcondition
+	stray := ThresholdSha256.Fulfillment{
+		Threshold:       1,
+		SubConditions:   ThresholdSha256.WeightedStrings{{Weight: 1, String: edCondString}},
+		SubFulfillments: ThresholdSha256.WeightedStrings{{Weight: 1, String: shaFulString}},
+	}
+	if _, err := stray.Condition(); err == nil {
+		t.Fatal(errors.New("derived condition with a stray subfulfillment"))
+	}
 }
 
-// Extra keys
-// &[197 198 13 156 213 181 160 15 105 7 66 222 66 15 212 8 172 55 20 47 34 182 117 106 213 203 6 172 119 66 87 170] &[244 9 180 60 13 13 60 215 158 30 236 128 111 107 44 54 75 151 209 13 20 19 58 42 162 147 207 0 189 188 4 136 197 198 13 156 213 181 160 15 105 7 66 222 66 15 212 8 172 55 20 47 34 182 117 106 213 203 6 172 119 66 87 170]
-// &[236 129 33 67 119 101 27 246 101 161 109 184 246 50 2 214 184 162 40 197 194 196 212 210 163 136 39 229 123 204 82 25] &[97 111 164 221 195 25 249 6 17 161 159 191 252 118 241 114 92 113 7 100 234 111 160 131 230 22 181 67 197 183 9 99 236 129 33 67 119 101 27 246 101 161 109 184 246 50 2 214 184 162 40 197 194 196 212 210 163 136 39 229 123 204 82 25]
-// &[118 97 30 186 23 231 51 77 244 88 148 216 9 177 104 120 183 209 212 48 44 133 220 62 24 92 165 7 153 68 194 83] &[117 54 222 53 77 11 219 41 154 161 185 104 208 248 30 59 132 230 116 108 150 60 215 9 221 101 210 53 150 159 129 174 118 97 30 186 23 231 51 77 244 88 148 216 9 177 104 120 183 209 212 48 44 133 220 62 24 92 165 7 153 68 194 83]
+func TestThresholdSha256Partial(t *testing.T) {
+	shaFul := &Sha256.Fulfillment{
+		Preimage: []byte{42},
+	}
+	shaCond := shaFul.Condition()
+
+	edFul := &Ed25519Sha256.Fulfillment{
+		PublicKey:    pubkey1,
+		FixedMessage: []byte{42},
+	}
+	edFul.Sign(privkey1)
+	edCond := edFul.Condition()
+
+	badFul := &Ed25519Sha256.Fulfillment{
+		PublicKey:    pubkey1,
+		FixedMessage: []byte{43},
+	}
+	badFul.Sign(privkey1)
+	badFul.Signature[0] ^= 1
+	badCond := badFul.Condition()
+
+	unfulfilled := &Sha256.Fulfillment{
+		Preimage: []byte{43},
+	}
+	unfulfilledCond := unfulfilled.Condition()
+
+	conditions := ThresholdSha256.WeightedStrings{
+		{Weight: 1, String: shaCond.Serialize()},
+		{Weight: 2, String: edCond.Serialize()},
+		{Weight: 2, String: badCond.Serialize()},
+		{Weight: 1, String: unfulfilledCond.Serialize()},
+	}
+
+	full := &ThresholdSha256.Fulfillment{
+		Threshold:     3,
+		SubConditions: conditions,
+		SubFulfillments: ThresholdSha256.WeightedStrings{
+			{Weight: 1, String: shaFul.Serialize()},
+			{Weight: 2, String: edFul.Serialize()},
+			{Weight: 2, String: badFul.Serialize()},
+			{Weight: 1, String: unfulfilled.Serialize()},
+		},
+	}
+	fullCond, err := full.Condition()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The preimage and the valid signature make up the threshold, despite
+	// the bad signature
+	partial := &ThresholdSha256.Fulfillment{
+		Threshold:     3,
+		SubConditions: conditions,
+		SubFulfillments: ThresholdSha256.WeightedStrings{
+			{Weight: 1, String: shaFul.Serialize()},
+			{Weight: 2, String: edFul.Serialize()},
+			{Weight: 2, String: badFul.Serialize()},
+		},
+	}
+	partialString := partial.Serialize()
+
+	condString, err := entry.FulfillmentToCondition(partialString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if condString != fullCond.Serialize() {
+		t.Fatal("partial fulfillment has a different condition", condString)
+	}
+
+	if err := entry.ValidateFulfillment(condString, partialString, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the preimage, the valid weight is 2
+	partial.SubFulfillments = partial.SubFulfillments[1:]
+
+	var thresholdErr *conderr.ThresholdError
+	err = entry.Validate(partial.Serialize(), nil)
+	if !errors.As(err, &thresholdErr) || !errors.Is(err, conderr.ErrThresholdNotMet) || !errors.Is(err, conderr.ErrInvalidSignature) {
+		t.Fatal("unmet threshold not detected", err)
+	}
+	if thresholdErr.Threshold != 3 || thresholdErr.Weight != 2 || len(thresholdErr.Failed) != 1 {
+		t.Fatal("wrong threshold error", thresholdErr)
+	}
+}
+
+func TestThresholdSha256Concurrent(t *testing.T) {
+	ful := &ThresholdSha256.Fulfillment{Threshold: 5}
+	for i := 0; i < 8; i++ {
+		sf := &Ed25519Sha256.Fulfillment{
+			PublicKey:    pubkey1,
+			FixedMessage: []byte{byte(i)},
+		}
+		sf.Sign(privkey1)
+		if i%2 == 1 {
+			sf.Signature[0] ^= 1
+		}
+
+		cond := sf.Condition()
+		ful.SubFulfillments = append(ful.SubFulfillments, ThresholdSha256.WeightedString{Weight: 1, String: sf.Serialize()})
+		ful.SubConditions = append(ful.SubConditions, ThresholdSha256.WeightedString{Weight: 1, String: cond.Serialize()})
+	}
+
+	_, payload, err := registry.SplitFulfillment(ful.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only 4 signatures are valid, and the same failures are reported
+	// however many workers there are
+	var expected error
+	for workers := 0; workers <= 8; workers++ {
+		err := ThresholdSha256.ValidateConcurrent(context.Background(), payload, nil, workers)
+		if !errors.Is(err, conderr.ErrThresholdNotMet) || !errors.Is(err, conderr.ErrInvalidSignature) {
+			t.Fatal("unmet threshold not detected", err)
+		}
+		if expected == nil {
+			expected = err
+		} else if !reflect.DeepEqual(err, expected) {
+			t.Fatal("errors depend on the number of workers", err, expected)
+		}
+	}
+
+	ful.Threshold = 4
+	_, payload, err = registry.SplitFulfillment(ful.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for workers := 0; workers <= 8; workers++ {
+		if err := ThresholdSha256.ValidateConcurrent(context.Background(), payload, nil, workers); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ThresholdSha256.ValidateConcurrent(ctx, payload, nil, 2); !errors.Is(err, conderr.ErrCanceled) || !errors.Is(err, context.Canceled) {
+		t.Fatal("cancelled validation not stopped", err)
+	}
+}
+
+// Validations of the busy type in progress, and the most seen at a time
+var busy, mostBusy int32
+var registerBusy sync.Once
+
+// Fulfillment of a type that takes a while to validate, and is valid if its
+// payload is zero
+func busyFulfillment(valid bool) string {
+	payload := []byte{0}
+	if !valid {
+		payload[0] = 1
+	}
+	return "cf:1:7f02:" + base64.URLEncoding.EncodeToString(payload)
+}
+
+func TestThresholdSha256Workers(t *testing.T) {
+	registerBusy.Do(func() {
+		registry.Register(&registry.Type{
+			ID:   0x7f02,
+			Name: "Busy",
+			ParseFulfillment: func(payload []byte) (registry.Fulfillment, error) {
+				return &customFulfillment{payload}, nil
+			},
+			FulfillmentToCondition: func(payload []byte) (string, error) {
+				return "cc:1:7f02:" + base64.URLEncoding.EncodeToString(payload) + ":0", nil
+			},
+			Validate: func(payload []byte, message []byte) error {
+				n := atomic.AddInt32(&busy, 1)
+				defer atomic.AddInt32(&busy, -1)
+				for {
+					most := atomic.LoadInt32(&mostBusy)
+					if n <= most || atomic.CompareAndSwapInt32(&mostBusy, most, n) {
+						break
+					}
+				}
+
+				time.Sleep(time.Millisecond)
+				if payload[0] != 0 {
+					return errors.New("busy fulfillment not valid")
+				}
+				return nil
+			},
+			Cost: func(payload []byte) (uint64, error) {
+				return 1, nil
+			},
+		})
+	})
+
+	// All of three thresholds, each needing two of four busy fulfillments
+	tree := func(valid int) string {
+		outer := &ThresholdSha256.Fulfillment{Threshold: 3}
+		for i := 0; i < 3; i++ {
+			inner := &ThresholdSha256.Fulfillment{Threshold: 2}
+			for j := 0; j < 4; j++ {
+				// The last inner threshold has the given number of valid
+				// subfulfillments, the others have two
+				v := j < 2
+				if i == 2 {
+					v = j < valid
+				}
+				inner.SubFulfillments = append(inner.SubFulfillments, ThresholdSha256.WeightedString{Weight: 1, String: busyFulfillment(v)})
+			}
+			outer.SubFulfillments = append(outer.SubFulfillments, ThresholdSha256.WeightedString{Weight: 1, String: inner.Serialize()})
+		}
+		return outer.Serialize()
+	}
+
+	for _, valid := range []int{1, 2} {
+		ful := tree(valid)
+		expected := entry.Validate(ful, nil)
+		if (expected == nil) != (valid == 2) {
+			t.Fatal("sequential validation incorrect", valid, expected)
+		}
+
+		for workers := 1; workers <= 6; workers++ {
+			atomic.StoreInt32(&mostBusy, 0)
+			err := entry.ValidateWithOptions(context.Background(), ful, nil, &conderr.ValidationOptions{Workers: workers})
+			if !reflect.DeepEqual(err, expected) {
+				t.Fatal("errors depend on the number of workers", workers, err, expected)
+			}
+			if most := atomic.LoadInt32(&mostBusy); most > int32(workers) {
+				t.Fatal("more validations at a time than workers", workers, most)
+			}
+			if workers > 1 && atomic.LoadInt32(&mostBusy) < 2 {
+				t.Fatal("nested thresholds not validated concurrently", workers)
+			}
+		}
+	}
+}
+
+func TestThresholdSha256Evaluate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaFul := &RsaSha256.Fulfillment{}
+	if err := rsaFul.Sign(rsaKey, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	edFul := &Ed25519Sha256.Fulfillment{PublicKey: pubkey1}
+	edFul.Sign(privkey1)
+	edFul.Signature[0] ^= 1
+
+	shaFul := &Sha256.Fulfillment{Preimage: []byte{42}}
+
+	ful := &ThresholdSha256.Fulfillment{}
+	for _, sf := range []string{edFul.Serialize(), rsaFul.Serialize(), shaFul.Serialize()} {
+		cond, err := registry.FulfillmentToCondition(sf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ful.SubFulfillments = append(ful.SubFulfillments, ThresholdSha256.WeightedString{Weight: 1, String: sf})
+		ful.SubConditions = append(ful.SubConditions, ThresholdSha256.WeightedString{Weight: 1, String: cond})
+	}
+
+	entries, err := ful.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := map[string]int{}
+	for i, entry := range entries {
+		index[entry.String] = i
+	}
+	sha, rsa, ed := index[shaFul.Serialize()], index[rsaFul.Serialize()], index[edFul.Serialize()]
+
+	// The cheapest subfulfillments are checked first, and only until the
+	// outcome is known
+	tests := []struct {
+		threshold uint32
+		checked   []int
+		valid     bool
+	}{
+		{0, []int{}, true},
+		{1, []int{sha}, true},
+		{2, []int{sha, rsa}, true},
+		{3, []int{sha, rsa, ed}, false},
+		{4, []int{}, false},
+	}
+
+	for _, test := range tests {
+		ful.Threshold = test.threshold
+		_, payload, err := registry.SplitFulfillment(ful.Serialize())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checked, err := ThresholdSha256.Evaluate(payload, []byte("hello"))
+		if !reflect.DeepEqual(checked, test.checked) {
+			t.Fatal("checked subfulfillments incorrect", test.threshold, checked)
+		}
+		if (err == nil) != test.valid {
+			t.Fatal("validation incorrect", test.threshold, err)
+		}
+		if !reflect.DeepEqual(err, ThresholdSha256.Validate(payload, []byte("hello"))) {
+			t.Fatal("Validate returned a different error", test.threshold, err)
+		}
+	}
+}
+
+func TestThresholdSha256Minimize(t *testing.T) {
+	bigFul := &Sha256.Fulfillment{
+		Preimage: bytes.Repeat([]byte{42}, 200),
+	}
+
+	edFuls := []string{}
+	for _, message := range []string{"a", "b"} {
+		edFul := &Ed25519Sha256.Fulfillment{
+			PublicKey:    pubkey1,
+			FixedMessage: []byte(message),
+		}
+		edFul.Sign(privkey1)
+		edFuls = append(edFuls, edFul.Serialize())
+	}
+
+	available := ThresholdSha256.WeightedStrings{
+		{Weight: 2, String: bigFul.Serialize()},
+		{Weight: 2, String: edFuls[0]},
+		{Weight: 2, String: edFuls[1]},
+	}
+
+	full := &ThresholdSha256.Fulfillment{
+		Threshold:       2,
+		SubFulfillments: available,
+	}
+	if err := full.Minimize(ThresholdSha256.MinCost); err != nil {
+		t.Fatal(err)
+	}
+	fullCond, err := full.Condition()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The preimage is the cheapest to validate, and a signature the shortest
+	for metric, want := range map[ThresholdSha256.Metric]string{
+		ThresholdSha256.MinCost: bigFul.Serialize(),
+		ThresholdSha256.MinSize: edFuls[0],
+	} {
+		serialized := []string{}
+
+		// Every order of the subfulfillments gives the same fulfillment
+		for _, order := range [][]int{{0, 1, 2}, {2, 1, 0}, {1, 2, 0}} {
+			ful := &ThresholdSha256.Fulfillment{
+				Threshold: 2,
+			}
+			for _, i := range order {
+				ful.SubFulfillments = append(ful.SubFulfillments, available[i])
+			}
+
+			if err := ful.Minimize(metric); err != nil {
+				t.Fatal(err)
+			}
+			if len(ful.SubFulfillments) != 1 || len(ful.SubConditions) != 3 {
+				t.Fatal("wrong subset", metric, ful.SubFulfillments)
+			}
+
+			serialized = append(serialized, ful.Serialize())
+		}
+
+		if serialized[0] != serialized[1] || serialized[0] != serialized[2] {
+			t.Fatal("subset depends on the order of the subfulfillments", metric)
+		}
+
+		parsed, err := ThresholdSha256.ParseFulfillment(serialized[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.SubFulfillments[0].String != want {
+			t.Fatal("wrong subfulfillment chosen", metric)
+		}
+
+		if err := entry.ValidateFulfillment(fullCond.Serialize(), serialized[0], nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The subfulfillments can't make up the threshold
+	short := &ThresholdSha256.Fulfillment{
+		Threshold:       7,
+		SubFulfillments: available,
+	}
+	if err := short.Minimize(ThresholdSha256.MinCost); !errors.Is(err, conderr.ErrThresholdNotMet) {
+		t.Fatal("unreachable threshold not detected", err)
+	}
+}
+
+func TestPrefixSha256Fulfillment(t *testing.T) {
+	shaFul := &Sha256.Fulfillment{
+		Preimage: []byte{42},
+	}
+
+	ful := &PrefixSha256.Fulfillment{
+		Prefix:           []byte("transfer:"),
+		MaxMessageLength: 16,
+		SubFulfillment:   shaFul.Serialize(),
+	}
+
+	serialized := ful.Serialize()
+	if !strings.HasPrefix(serialized, "cf:1:2:") {
+		t.Fatal("serialization incorrect", serialized)
+	}
+
+	parsed, err := PrefixSha256.ParseFulfillment(serialized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ful, parsed) {
+		t.Fatal(errors.New("parsed fulfillment doesn't match"))
+	}
+
+	cond, err := ful.Condition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	condString, err := entry.FulfillmentToCondition(serialized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if condString != cond.Serialize() || !strings.HasPrefix(condString, "cc:1:2:") {
+		t.Fatal(errors.New("serialized condition doesn't match"), condString)
+	}
+	if cond.MaxFulfillmentLength < uint64(len(serialized)) {
+		t.Fatal("maximum fulfillment length is too small", cond.MaxFulfillmentLength)
+	}
+
+	// A different prefix is a different condition
+	other := *ful
+	other.Prefix = []byte("other:")
+	otherCond, err := other.Condition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherCond.Serialize() == condString {
+		t.Fatal(errors.New("condition doesn't depend on the prefix"))
+	}
+
+	if err := entry.Validate(serialized, []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Validate(serialized, make([]byte, 17)); err == nil {
+		t.Fatal(errors.New("validated a message longer than the maximum"))
+	}
+}
+
+func TestPrefixSha256Ed25519(t *testing.T) {
+	edFul := &Ed25519Sha256.Fulfillment{PublicKey: pubkey1, FixedMessage: []byte("signed")}
+	edFul.Sign(privkey1)
+
+	// The signature covers the fixed message, not the prefixed message
+	for _, prefix := range []string{"transfer:", "other:"} {
+		ful := &PrefixSha256.Fulfillment{
+			Prefix:           []byte(prefix),
+			MaxMessageLength: 4,
+			SubFulfillment:   edFul.Serialize(),
+		}
+
+		for _, message := range []string{"", "1234", "abcd"} {
+			if err := entry.Validate(ful.Serialize(), []byte(message)); err != nil {
+				t.Fatal(prefix, message, err)
+			}
+		}
+
+		// Only the length of the message is checked
+		if err := entry.Validate(ful.Serialize(), []byte("12345")); !errors.Is(err, conderr.ErrMessageTooLong) {
+			t.Fatal("validated a message longer than the maximum", err)
+		}
+	}
+}
+
+func TestRsaSha256Fulfillment(t *testing.T) {
+	privkey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ful := &RsaSha256.Fulfillment{}
+	if err := ful.Sign(privkey, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	serialized := ful.Serialize()
+	if !strings.HasPrefix(serialized, "cf:1:10:") {
+		t.Fatal("serialization incorrect", serialized)
+	}
+
+	parsed, err := RsaSha256.ParseFulfillment(serialized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ful, parsed) {
+		t.Fatal(errors.New("parsed fulfillment doesn't match"))
+	}
+
+	if ful.Cost() != 128*128 {
+		t.Fatal("cost incorrect", ful.Cost())
+	}
+
+	cond := ful.Condition()
+	if cond.MaxFulfillmentLength != uint64(len(serialized)) {
+		t.Fatal("maximum fulfillment length incorrect", cond.MaxFulfillmentLength)
+	}
+
+	// Validation goes through the top-level dispatch, in both formats
+	if err := entry.Validate(serialized, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Validate(serialized, []byte("goodbye")); err == nil {
+		t.Fatal(errors.New("validated with the wrong message"))
+	}
+
+	_, payload, err := registry.SplitFulfillment(serialized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := bytes.Join([][]byte{
+		encoding.MakeUvarint(RsaSha256.TypeID),
+		encoding.MakeVarbyte(payload),
+	}, []byte{})
+	if err := CryptoConditions.Validate(binary, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The DER form has the cost of the modulus size
+	derCond, err := ful.DER().Condition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if derCond.Cost != 128*128 || !strings.Contains(derCond.URI(), "fpt=rsa-sha-256&cost=16384") {
+		t.Fatal("DER condition incorrect", derCond.URI())
+	}
+
+	small, err := rsa.GenerateKey(rand.Reader, 512)
+	if err == nil {
+		if err := ful.Sign(small, []byte("hello")); err == nil {
+			t.Fatal(errors.New("signed with a modulus below 128 bytes"))
+		}
+	}
+}
+
+func TestCost(t *testing.T) {
+	shaFul := &Sha256.Fulfillment{
+		Preimage: []byte{1, 2, 3},
+	}
+	shaFulString := shaFul.Serialize()
+
+	edFul := &Ed25519Sha256.Fulfillment{
+		PublicKey:    pubkey1,
+		FixedMessage: []byte{42},
+	}
+	edFul.Sign(privkey1)
+	edFulString := edFul.Serialize()
+	edCond := edFul.Condition()
+	shaCond := shaFul.Condition()
+
+	thrFul := &ThresholdSha256.Fulfillment{
+		Threshold: 1,
+		SubConditions: ThresholdSha256.WeightedStrings{
+			{Weight: 1, String: edCond.Serialize()},
+			{Weight: 1, String: shaCond.Serialize()},
+		},
+		SubFulfillments: ThresholdSha256.WeightedStrings{
+			{Weight: 1, String: edFulString},
+		},
+	}
+	thrFulString := thrFul.Serialize()
+
+	prefixFul := &PrefixSha256.Fulfillment{
+		Prefix:           []byte{1, 2},
+		MaxMessageLength: 10,
+		SubFulfillment:   shaFulString,
+	}
+	prefixFulString := prefixFul.Serialize()
+
+	// Only the most expensive subfulfillments that make up the threshold
+	// count, as in the DER format
+	weightedFul := &ThresholdSha256.Fulfillment{Threshold: 3}
+	for i, weight := range []uint32{1, 2, 1} {
+		sf := &Sha256.Fulfillment{Preimage: make([]byte, 10*(i+1))}
+		sfCond := sf.Condition()
+		weightedFul.SubConditions = append(weightedFul.SubConditions, ThresholdSha256.WeightedString{Weight: weight, String: sfCond.Serialize()})
+		weightedFul.SubFulfillments = append(weightedFul.SubFulfillments, ThresholdSha256.WeightedString{Weight: weight, String: sf.Serialize()})
+	}
+
+	costs := []struct {
+		ful  string
+		cost uint64
+	}{
+		{shaFulString, 3},
+		{edFulString, der.Ed25519Cost},
+		{thrFulString, der.Ed25519Cost + 2*der.ThresholdSubconditionCost},
+		{prefixFulString, 2 + 10 + 3 + der.PrefixCost},
+		{weightedFul.Serialize(), 30 + 20 + 3*der.ThresholdSubconditionCost},
+	}
+
+	for _, c := range costs {
+		cost, err := entry.Cost(c.ful)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cost != c.cost {
+			t.Fatal("cost incorrect", c.ful, cost, c.cost)
+		}
+
+		if err := entry.ValidateWithMaxCost(c.ful, nil, c.cost); err != nil {
+			t.Fatal(err)
+		}
+		if err := entry.ValidateWithMaxCost(c.ful, nil, c.cost-1); err == nil {
+			t.Fatal("validated above the maximum cost", c.ful)
+		}
+	}
+
+	thrCost, err := thrFul.Cost()
+	if err != nil || thrCost != costs[2].cost {
+		t.Fatal("threshold cost incorrect", thrCost, err)
+	}
+	thrCost, err = weightedFul.Cost()
+	if err != nil || thrCost != costs[4].cost {
+		t.Fatal("threshold cost incorrect", thrCost, err)
+	}
+
+	// Costs saturate instead of overflowing past the maximum
+	huge := &PrefixSha256.Fulfillment{
+		Prefix:           []byte{1},
+		MaxMessageLength: 1<<64 - 1,
+		SubFulfillment:   shaFulString,
+	}
+	if err := entry.ValidateWithMaxCost(huge.Serialize(), nil, 1<<63); err == nil {
+		t.Fatal("cost overflowed")
+	}
+
+	// The cost is checked before the signature
+	edFul.Signature[0] ^= 1
+	if err := entry.ValidateWithMaxCost(edFul.Serialize(), nil, 1); !errors.Is(err, conderr.ErrCostExceeded) {
+		t.Fatal("signature checked before the cost", err)
+	}
+
+	// DER fulfillments are checked against the cost of their condition
+	derFul, err := (&der.ThresholdSha256{
+		SubFulfillments: []der.Fulfillment{shaFul.DER()},
+	}).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := der.ValidateWithMaxCost(derFul, nil, 3+der.ThresholdSubconditionCost); err != nil {
+		t.Fatal(err)
+	}
+	if err := der.ValidateWithMaxCost(derFul, nil, 3+der.ThresholdSubconditionCost-1); err == nil {
+		t.Fatal("validated above the maximum cost")
+	}
+}
+
+func TestValidateFulfillment(t *testing.T) {
+	shaFul := &Sha256.Fulfillment{
+		Preimage: []byte{42},
+	}
+	shaFulString := shaFul.Serialize()
+	shaCond := shaFul.Condition()
+	shaCondString := shaCond.Serialize()
+
+	if err := entry.ValidateFulfillment(shaCondString, shaFulString, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	mismatches := []struct {
+		cond  string
+		field string
+	}{
+		// Different preimage
+		{"cc:1:1:" + base64.URLEncoding.EncodeToString(make([]byte, 32)) + ":11", conderr.FieldFingerprint},
+		// Different type
+		{"cc:1:8:" + base64.URLEncoding.EncodeToString(shaCond.Hash[:]) + ":11", conderr.FieldType},
+		// Fulfillment is longer than allowed
+		{"cc:1:1:" + base64.URLEncoding.EncodeToString(shaCond.Hash[:]) + ":10", conderr.FieldLength},
+	}
+
+	for _, m := range mismatches {
+		err := entry.ValidateFulfillment(m.cond, shaFulString, nil)
+		if !errors.Is(err, conderr.ErrConditionMismatch) {
+			t.Fatal("mismatch not detected", m.cond, err)
+		}
+
+		var mismatch *conderr.MismatchError
+		if !errors.As(err, &mismatch) || mismatch.Field != m.field {
+			t.Fatal("wrong mismatch", m.cond, err)
+		}
+	}
+
+	// Binary formats
+	cond, err := registry.ParseCondition(shaCondString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := bytes.Join([][]byte{
+		encoding.MakeUvarint(Sha256.TypeID),
+		encoding.MakeVarbyte(shaFul.Preimage),
+	}, []byte{})
+	if err := CryptoConditions.ValidateFulfillment(cond.Binary(), binary, nil); err != nil {
+		t.Fatal(err)
+	}
+	cond.Fingerprint[0] ^= 1
+	if err := CryptoConditions.ValidateFulfillment(cond.Binary(), binary, nil); !errors.Is(err, conderr.ErrConditionMismatch) {
+		t.Fatal("mismatch not detected", err)
+	}
+
+	// DER conditions also carry subtypes and cost
+	edFul := &der.Ed25519Sha256{}
+	edFul.Sign(privkey1, []byte("hello"))
+	thrFul := &der.ThresholdSha256{
+		SubFulfillments: []der.Fulfillment{edFul},
+	}
+	thrCond, err := thrFul.Condition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := thrFul.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := der.ValidateFulfillment(thrCond, encoded, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := der.ValidateFulfillment(thrCond, encoded, []byte("goodbye")); err == nil || errors.Is(err, conderr.ErrConditionMismatch) {
+		t.Fatal("bad signature not detected", err)
+	}
+
+	derMismatches := []struct {
+		change func(*der.Condition)
+		field  string
+	}{
+		{func(c *der.Condition) { c.Type = der.TypePrefixSha256 }, conderr.FieldType},
+		{func(c *der.Condition) { c.Fingerprint = make([]byte, 32) }, conderr.FieldFingerprint},
+		{func(c *der.Condition) { c.Subtypes = 1 << der.TypePreimageSha256 }, conderr.FieldSubtypes},
+		{func(c *der.Condition) { c.Cost++ }, conderr.FieldCost},
+	}
+
+	for _, m := range derMismatches {
+		c := *thrCond
+		m.change(&c)
+
+		var mismatch *conderr.MismatchError
+		err := der.ValidateFulfillment(&c, encoded, []byte("hello"))
+		if !errors.As(err, &mismatch) || mismatch.Field != m.field {
+			t.Fatal("wrong mismatch", m.field, err)
+		}
+	}
+}
+
+// Context that is cancelled after Err has been called n times
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (ctx *countdownContext) Err() error {
+	if ctx.n == 0 {
+		return context.Canceled
+	}
+	ctx.n--
+	return nil
+}
+
+func TestValidateContext(t *testing.T) {
+	threshold := &ThresholdSha256.Fulfillment{Threshold: 2}
+	for i := 0; i < 3; i++ {
+		sf := &Ed25519Sha256.Fulfillment{
+			PublicKey:    pubkey1,
+			FixedMessage: []byte{byte(i)},
+		}
+		sf.Sign(privkey1)
+
+		cond := sf.Condition()
+		threshold.SubFulfillments = append(threshold.SubFulfillments, ThresholdSha256.WeightedString{Weight: 1, String: sf.Serialize()})
+		threshold.SubConditions = append(threshold.SubConditions, ThresholdSha256.WeightedString{Weight: 1, String: cond.Serialize()})
+	}
+	ful := (&PrefixSha256.Fulfillment{
+		Prefix:           []byte("prefix"),
+		MaxMessageLength: 10,
+		SubFulfillment:   threshold.Serialize(),
+	}).Serialize()
+
+	if err := entry.ValidateContext(context.Background(), ful, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	if err := entry.ValidateContext(cancelled, ful, nil); !errors.Is(err, conderr.ErrCanceled) || !errors.Is(err, context.Canceled) {
+		t.Fatal("cancelled validation not stopped", err)
+	}
+	if err := entry.ValidateContext(expired, ful, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expired validation not stopped", err)
+	}
+	if _, err := entry.ParseFullfillmentContext(cancelled, ful); !errors.Is(err, conderr.ErrCanceled) {
+		t.Fatal("cancelled parsing not stopped", err)
+	}
+
+	// Cancellation is checked between subfulfillments, and isn't reported as
+	// the failure of one, wherever it happens
+	for n := 1; ; n++ {
+		err := entry.ValidateContext(&countdownContext{Context: context.Background(), n: n}, ful, nil)
+		if err == nil {
+			if n < 5 {
+				t.Fatal("cancellation checked too rarely", n)
+			}
+			break
+		}
+		var thresholdErr *conderr.ThresholdError
+		var validationErr *conderr.ValidationError
+		if !errors.Is(err, conderr.ErrCanceled) || errors.As(err, &thresholdErr) || errors.As(err, &validationErr) {
+			t.Fatal("cancellation not detected", n, err)
+		}
+	}
+
+	// DER fulfillments
+	derFul := &der.ThresholdSha256{}
+	for i := 0; i < 3; i++ {
+		sf := &der.Ed25519Sha256{}
+		sf.Sign(privkey1, []byte("hello"))
+		derFul.SubFulfillments = append(derFul.SubFulfillments, sf)
+	}
+	b, err := derFul.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := der.ValidateContext(context.Background(), b, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := der.ParseFulfillmentContext(&countdownContext{Context: context.Background(), n: 2}, b); !errors.Is(err, conderr.ErrCanceled) {
+		t.Fatal("cancelled parsing not stopped", err)
+	}
+	if err := der.ValidateContext(expired, b, []byte("hello")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expired validation not stopped", err)
+	}
+
+	payload := append(append([]byte{}, pubkey1[:]...), ed25519.Sign(&privkey1, []byte("hello"))[:]...)
+	if err := CryptoConditions.Ed25519ValidateContext(context.Background(), payload, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := CryptoConditions.Ed25519ValidateContext(cancelled, payload, []byte("hello")); !errors.Is(err, conderr.ErrCanceled) {
+		t.Fatal("cancelled validation not stopped", err)
+	}
+}
+
+// Each level of nested thresholds derives the conditions of its entries once,
+// so the work grows with the depth instead of doubling at every level
+func TestNestedWork(t *testing.T) {
+	nested := func(n int) string {
+		ful := (&Sha256.Fulfillment{Preimage: []byte{42}}).Serialize()
+		for i := 0; i < n; i++ {
+			other := (&Sha256.Fulfillment{Preimage: []byte{byte(i)}}).Condition()
+			threshold := &ThresholdSha256.Fulfillment{
+				Threshold:       1,
+				SubFulfillments: ThresholdSha256.WeightedStrings{{Weight: 1, String: ful}},
+				SubConditions:   ThresholdSha256.WeightedStrings{{Weight: 1, String: other.Serialize()}},
+			}
+			if err := threshold.Minimize(ThresholdSha256.MinCost); err != nil {
+				t.Fatal(err)
+			}
+			ful = threshold.Serialize()
+		}
+		return ful
+	}
+
+	// Number of cancellation checks made by f
+	checks := func(f func(context.Context) error) int {
+		ctx := &countdownContext{Context: context.Background(), n: 1 << 30}
+		if err := f(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return 1<<30 - ctx.n
+	}
+
+	for _, test := range []struct {
+		name string
+		f    func(ctx context.Context, ful string) error
+		// Most the checks may grow by when the depth doubles
+		growth int
+	}{
+		{"derive", func(ctx context.Context, ful string) error {
+			_, err := entry.FulfillmentToConditionContext(ctx, ful)
+			return err
+		}, 3},
+		{"validate", func(ctx context.Context, ful string) error {
+			return entry.ValidateContext(ctx, ful, nil)
+		}, 5},
+	} {
+		shallow, deep := nested(8), nested(16)
+		a := checks(func(ctx context.Context) error { return test.f(ctx, shallow) })
+		b := checks(func(ctx context.Context) error { return test.f(ctx, deep) })
+		if b > test.growth*a {
+			t.Fatal("work grows too fast with the depth", test.name, a, b)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	edFul := &Ed25519Sha256.Fulfillment{
+		PublicKey:               pubkey1,
+		MessageId:               []byte{2, 2, 2, 2, 2},
+		FixedMessage:            []byte{42},
+		DynamicMessage:          []byte{90},
+		MaxDynamicMessageLength: 99999,
+	}
+	edFul.Sign(privkey1)
+
+	_, payload, err := registry.SplitFulfillment(edFul.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Malformed header
+	var syntaxErr *conderr.SyntaxError
+	err = entry.Validate("cf:1:8", nil)
+	if !errors.Is(err, conderr.ErrMalformed) || !errors.As(err, &syntaxErr) {
+		t.Fatal("malformed header not detected", err)
+	}
+
+	// Unsupported type
+	var parseErr *conderr.ParseError
+	err = entry.Validate("cf:1:7f01:", nil)
+	if !errors.Is(err, conderr.ErrUnsupportedType) || !errors.As(err, &parseErr) || parseErr.Type != 0x7f01 {
+		t.Fatal("unsupported type not detected", err)
+	}
+
+	// Truncated signature, whose length is at byte 46 of the payload
+	truncated := "cf:1:8:" + base64.URLEncoding.EncodeToString(payload[:56])
+	err = entry.Validate(truncated, nil)
+	if !errors.Is(err, conderr.ErrMalformed) || !errors.As(err, &parseErr) {
+		t.Fatal("truncated payload not detected", err)
+	}
+	if parseErr.Type != Ed25519Sha256.TypeID || parseErr.Offset != 47 || len(parseErr.Path) != 0 {
+		t.Fatal("wrong parse error", parseErr.Type, parseErr.Offset, parseErr.Path)
+	}
+
+	// Bad signature
+	var validationErr *conderr.ValidationError
+	edFul.Signature[0] ^= 1
+	badEdString := edFul.Serialize()
+	err = entry.Validate(badEdString, nil)
+	if !errors.Is(err, conderr.ErrInvalidSignature) || errors.Is(err, conderr.ErrMalformed) || !errors.As(err, &validationErr) {
+		t.Fatal("bad signature not detected", err)
+	}
+	if validationErr.Type != Ed25519Sha256.TypeID {
+		t.Fatal("wrong type", validationErr.Type)
+	}
+
+	// Bad signature nested in a prefix in a threshold
+	prefixFul := &PrefixSha256.Fulfillment{
+		SubFulfillment: badEdString,
+	}
+	shaFul := &Sha256.Fulfillment{
+		Preimage: []byte{42},
+	}
+	thrFul := &ThresholdSha256.Fulfillment{
+		Threshold: 2,
+		SubFulfillments: ThresholdSha256.WeightedStrings{
+			{Weight: 1, String: shaFul.Serialize()},
+			{Weight: 1, String: prefixFul.Serialize()},
+		},
+	}
+	var thresholdErr *conderr.ThresholdError
+	err = entry.Validate(thrFul.Serialize(), nil)
+	if !errors.Is(err, conderr.ErrInvalidSignature) || !errors.As(err, &thresholdErr) || len(thresholdErr.Failed) != 1 {
+		t.Fatal("nested bad signature not detected", err)
+	}
+	// The Sha256 fulfillment is shorter, so it is the first entry
+	if !errors.As(thresholdErr.Failed[0], &validationErr) || validationErr.Type != Ed25519Sha256.TypeID || !reflect.DeepEqual(validationErr.Path, []int{1, 0}) {
+		t.Fatal("wrong validation error", thresholdErr.Failed[0])
+	}
+
+	// Not enough weight
+	thrFul = &ThresholdSha256.Fulfillment{
+		Threshold: 2,
+		SubFulfillments: ThresholdSha256.WeightedStrings{
+			{Weight: 1, String: shaFul.Serialize()},
+		},
+	}
+	err = entry.Validate(thrFul.Serialize(), nil)
+	if !errors.Is(err, conderr.ErrThresholdNotMet) || !errors.As(err, &validationErr) || validationErr.Type != ThresholdSha256.TypeID {
+		t.Fatal("unmet threshold not detected", err)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	shaFul := &Sha256.Fulfillment{
+		Preimage: []byte{42},
+	}
+
+	badFul := &Ed25519Sha256.Fulfillment{
+		PublicKey:    pubkey1,
+		FixedMessage: []byte{43},
+	}
+	badFul.Sign(privkey1)
+	badFul.Signature[0] ^= 1
+
+	unfulfilled := &Sha256.Fulfillment{
+		Preimage: []byte{43},
+	}
+	unfulfilledCond := unfulfilled.Condition()
+
+	thrFul := &ThresholdSha256.Fulfillment{
+		Threshold: 1,
+		SubConditions: ThresholdSha256.WeightedStrings{
+			{Weight: 2, String: unfulfilledCond.Serialize()},
+		},
+		SubFulfillments: ThresholdSha256.WeightedStrings{
+			{Weight: 1, String: shaFul.Serialize()},
+			{Weight: 1, String: badFul.Serialize()},
+		},
+	}
+	thrString := thrFul.Serialize()
+
+	node, err := entry.ExplainValidation(thrString, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if node.Type != "ThresholdSha256" || !node.Fulfilled || !node.Validated || node.Err != nil {
+		t.Fatal("threshold not explained", node)
+	}
+
+	cost, err := entry.Cost(thrString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Cost != cost {
+		t.Fatal("cost not explained", node.Cost, cost)
+	}
+
+	if len(node.Children) != 3 {
+		t.Fatal("threshold entries not explained", len(node.Children))
+	}
+
+	valid, invalid, conditions := 0, 0, 0
+	for _, child := range node.Children {
+		switch {
+		case !child.Fulfilled:
+			conditions++
+			if child.Weight != 2 || !bytes.Equal(child.Fingerprint, unfulfilledCond.Hash[:]) {
+				t.Fatal("subcondition not explained", child)
+			}
+		case child.Err == nil:
+			valid++
+			if child.Type != "Sha256" || child.Weight != 1 {
+				t.Fatal("preimage not explained", child)
+			}
+		default:
+			invalid++
+			if !errors.Is(child.Err, conderr.ErrInvalidSignature) {
+				t.Fatal("bad signature not explained", child.Err)
+			}
+		}
+	}
+	if valid != 1 || invalid != 1 || conditions != 1 {
+		t.Fatal("children not validated", valid, invalid, conditions)
+	}
+
+	dump := node.String()
+	if !strings.Contains(dump, ": valid\n") || !strings.Contains(dump, ": invalid: ") ||
+		!strings.Contains(dump, "public key: "+fmt.Sprintf("%x", pubkey1[:])) {
+		t.Fatal("tree not dumped", dump)
+	}
+
+	// Without a message nothing is validated
+	node, err = entry.Explain(thrString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Validated || strings.Contains(node.String(), "valid") {
+		t.Fatal("tree validated", node)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	shaFul := &Sha256.Fulfillment{
+		Preimage: []byte{42},
+	}
+
+	edFul := &Ed25519Sha256.Fulfillment{
+		PublicKey:    pubkey1,
+		MessageId:    []byte("id"),
+		FixedMessage: []byte{42},
+	}
+	edFul.Sign(privkey1)
+
+	privkey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaFul := &RsaSha256.Fulfillment{}
+	err = rsaFul.Sign(privkey, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefixFul := &PrefixSha256.Fulfillment{
+		Prefix:           []byte("hello "),
+		MaxMessageLength: 10,
+		SubFulfillment:   shaFul.Serialize(),
+	}
+
+	unfulfilled := &Sha256.Fulfillment{
+		Preimage: []byte{43},
+	}
+	unfulfilledCond := unfulfilled.Condition()
+	edCond := edFul.Condition()
+
+	thrFul := &ThresholdSha256.Fulfillment{
+		Threshold: 2,
+		SubConditions: ThresholdSha256.WeightedStrings{
+			{Weight: 1, String: edCond.Serialize()},
+			{Weight: 1, String: unfulfilledCond.Serialize()},
+		},
+		SubFulfillments: ThresholdSha256.WeightedStrings{
+			{Weight: 1, String: edFul.Serialize()},
+			{Weight: 1, String: prefixFul.Serialize()},
+		},
+	}
+
+	fulfillments := []registry.Fulfillment{shaFul, edFul, rsaFul, prefixFul, thrFul}
+	for _, ful := range fulfillments {
+		b, err := json.Marshal(ful)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := entry.FromJSON(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != ful.Serialize() {
+			t.Fatal("JSON doesn't convert to the fulfillment string", string(b), s)
+		}
+
+		converted, err := entry.ToJSON(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(converted, b) {
+			t.Fatal("fulfillment string doesn't convert to the JSON", string(converted), string(b))
+		}
+
+		decoded := reflect.New(reflect.TypeOf(ful).Elem()).Interface().(registry.Fulfillment)
+		err = json.Unmarshal(b, decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Serialize() != ful.Serialize() {
+			t.Fatal("JSON doesn't round trip", string(b))
+		}
+	}
+
+	b, err := json.Marshal(thrFul)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `{"weight":1,"condition":{"type":"Sha256","fingerprint":"`) ||
+		!strings.Contains(string(b), `{"weight":1,"fulfillment":{"type":"PrefixSha256","prefix":"aGVsbG8g","maxMessageLength":10,"subfulfillment":{"type":"Sha256","preimage":"Kg=="}}}`) {
+		t.Fatal("unexpected threshold JSON", string(b))
+	}
+
+	// Conditions
+	condString := unfulfilledCond.Serialize()
+	b, err = entry.ToJSON(condString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := entry.FromJSON(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != condString {
+		t.Fatal("condition doesn't round trip", string(b), s)
+	}
+
+	shaCond := Sha256.Condition{}
+	err = json.Unmarshal(b, &shaCond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shaCond != unfulfilledCond {
+		t.Fatal("Sha256 condition doesn't read the JSON", shaCond)
+	}
+
+	// Conditions made of the fields their fingerprint is derived from are
+	// represented like any other, along with those fields, so that they can
+	// be read by entry.FromJSON
+	rsaCond := rsaFul.Condition()
+	for _, cond := range []interface {
+		Serialize() string
+	}{&edCond, &rsaCond} {
+		b, err = json.Marshal(cond)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded := reflect.New(reflect.TypeOf(cond).Elem()).Interface()
+		err = json.Unmarshal(b, decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, cond) {
+			t.Fatal("condition doesn't round trip", string(b))
+		}
+
+		s, err = entry.FromJSON(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != cond.Serialize() {
+			t.Fatal("condition JSON doesn't convert to the condition string", string(b), s)
+		}
+
+		// The fingerprint alone doesn't give those fields, and is read on its
+		// own
+		b, err = entry.ToJSON(cond.Serialize())
+		if err != nil {
+			t.Fatal(err)
+		}
+		fingerprintOnly := reflect.New(reflect.TypeOf(cond).Elem()).Interface().(interface{ Serialize() string })
+		err = json.Unmarshal(b, fingerprintOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fingerprintOnly.Serialize() != cond.Serialize() {
+			t.Fatal("fingerprint read incorrectly", string(b), fingerprintOnly.Serialize())
+		}
+		again, err := json.Marshal(fingerprintOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(again, b) {
+			t.Fatal("fingerprint doesn't round trip", string(again), string(b))
+		}
+	}
+
+	if err := json.Unmarshal([]byte(`{"type":"Ed25519Sha256","fingerprint":"Kg","maxFulfillmentLength":0}`), &Ed25519Sha256.Condition{}); !errors.Is(err, conderr.ErrMalformed) {
+		t.Fatal("short fingerprint not detected", err)
+	}
+
+	tampered := edCond
+	tampered.FixedMessage = []byte("other")
+	b, err = json.Marshal(&tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = bytes.Replace(b, []byte(`"fixedMessage":"b3RoZXI="`), []byte(`"fixedMessage":null`), 1)
+	if err := json.Unmarshal(b, &Ed25519Sha256.Condition{}); !errors.Is(err, conderr.ErrMalformed) {
+		t.Fatal("mismatched fingerprint not detected", string(b), err)
+	}
+
+	// Errors
+	_, err = entry.FromJSON([]byte(`{"type":"Nope","preimage":"Kg"}`))
+	if !errors.Is(err, conderr.ErrUnsupportedType) {
+		t.Fatal("unknown type not detected", err)
+	}
+
+	err = json.Unmarshal([]byte(`{"type":"Sha256","preimage":"Kg"}`), &Ed25519Sha256.Fulfillment{})
+	if !errors.Is(err, conderr.ErrUnsupportedType) {
+		t.Fatal("wrong type not detected", err)
+	}
+
+	_, err = entry.FromJSON([]byte(`{"type":"Ed25519Sha256","publicKey":"Kg"}`))
+	if !errors.Is(err, conderr.ErrMalformed) {
+		t.Fatal("short public key not detected", err)
+	}
+
+	_, err = entry.FromJSON([]byte(`{"type":"Sha256","preimage":"*"}`))
+	if !errors.Is(err, conderr.ErrMalformed) {
+		t.Fatal("bad base64 not detected", err)
+	}
+
+	var parseErr *conderr.ParseError
+	_, err = entry.FromJSON([]byte(`{"type":"ThresholdSha256","threshold":1,"subfulfillments":[{"weight":1,"fulfillment":{"type":"Sha256","preimage":"Kg"}},{"weight":1}]}`))
+	if !errors.As(err, &parseErr) || !reflect.DeepEqual(parseErr.Path, []int{1}) {
+		t.Fatal("empty entry not detected", err)
+	}
+}
+
+func TestBinary(t *testing.T) {
+	shaFul := &Sha256.Fulfillment{
+		Preimage: []byte{42},
+	}
+
+	edFul := &Ed25519Sha256.Fulfillment{
+		PublicKey:    pubkey1,
+		MessageId:    []byte("id"),
+		FixedMessage: []byte{42},
+	}
+	edFul.Sign(privkey1)
+
+	privkey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaFul := &RsaSha256.Fulfillment{}
+	err = rsaFul.Sign(privkey, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefixFul := &PrefixSha256.Fulfillment{
+		Prefix:           []byte("hello "),
+		MaxMessageLength: 10,
+		SubFulfillment:   shaFul.Serialize(),
+	}
+
+	edCond := edFul.Condition()
+	prefixCond, err := prefixFul.Condition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	thrFul := &ThresholdSha256.Fulfillment{
+		Threshold: 1,
+		SubConditions: ThresholdSha256.WeightedStrings{
+			{Weight: 1, String: edCond.Serialize()},
+			{Weight: 1, String: prefixCond.Serialize()},
+		},
+		SubFulfillments: ThresholdSha256.WeightedStrings{
+			{Weight: 1, String: prefixFul.Serialize()},
+		},
+	}
+
+	fulfillments := []registry.Fulfillment{shaFul, edFul, rsaFul, prefixFul, thrFul}
+	for _, ful := range fulfillments {
+		b, err := ful.(encodingpkg.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := entry.BinaryToFulfillment(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != ful.Serialize() {
+			t.Fatal("binary doesn't convert to the fulfillment string", s)
+		}
+
+		converted, err := entry.FulfillmentToBinary(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(converted, b) {
+			t.Fatal("fulfillment string doesn't convert to the binary", converted, b)
+		}
+
+		decoded := reflect.New(reflect.TypeOf(ful).Elem()).Interface().(registry.Fulfillment)
+		err = decoded.(encodingpkg.BinaryUnmarshaler).UnmarshalBinary(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Serialize() != ful.Serialize() {
+			t.Fatal("binary doesn't round trip", b)
+		}
+	}
+
+	b, err := shaFul.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = (&Ed25519Sha256.Fulfillment{}).UnmarshalBinary(b)
+	if !errors.Is(err, conderr.ErrUnsupportedType) {
+		t.Fatal("wrong type not detected", err)
+	}
+
+	// Conditions
+	shaCond := shaFul.Condition()
+	rsaCond := rsaFul.Condition()
+	thrCond, err := thrFul.Condition()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conditions := []interface {
+		encodingpkg.BinaryMarshaler
+		Serialize() string
+	}{&shaCond, &edCond, &rsaCond, &prefixCond, &thrCond}
+	for _, cond := range conditions {
+		b, err := cond.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Every type writes the same binary condition format
+		generic, err := registry.ParseBinaryCondition(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if generic.Serialize() != cond.Serialize() {
+			t.Fatal("binary condition doesn't match the string format", generic.Serialize(), cond.Serialize())
+		}
+
+		root := &CryptoConditions.Condition{}
+		err = root.UnmarshalBinary(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if root.Type != generic.Type || !bytes.Equal(root.FeatureBitmask, registry.FeatureBitmask(generic.Type)) || !bytes.Equal(root.Fingerprint, generic.Fingerprint) {
+			t.Fatal("binary condition doesn't match the root layout", root)
+		}
+
+		// Every type reads it back. Conditions made of the fields their
+		// fingerprint is derived from are read into the fingerprint alone.
+		decoded := reflect.New(reflect.TypeOf(cond).Elem()).Interface().(interface {
+			encodingpkg.BinaryMarshaler
+			encodingpkg.BinaryUnmarshaler
+			Serialize() string
+		})
+		err = decoded.UnmarshalBinary(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Serialize() != cond.Serialize() {
+			t.Fatal("condition doesn't round trip", decoded, cond)
+		}
+		again, err := decoded.MarshalBinary()
+		if err != nil || !bytes.Equal(again, b) {
+			t.Fatal("binary condition doesn't round trip", again, b, err)
+		}
+		switch cond.(type) {
+		case *Ed25519Sha256.Condition, *RsaSha256.Condition:
+		default:
+			if !reflect.DeepEqual(decoded, cond) {
+				t.Fatal("condition doesn't round trip", decoded, cond)
+			}
+		}
+	}
+
+	b, err = shaCond.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Ed25519Sha256.Condition{}).UnmarshalBinary(b); !errors.Is(err, conderr.ErrUnsupportedType) {
+		t.Fatal("wrong condition type not detected", err)
+	}
+	if err := (&RsaSha256.Condition{}).UnmarshalBinary(b); !errors.Is(err, conderr.ErrUnsupportedType) {
+		t.Fatal("wrong condition type not detected", err)
+	}
+
+	b, err = shaCond.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[2] = byte(registry.FeatureSha256)
+	if _, err := registry.ParseBinaryCondition(b); !errors.Is(err, conderr.ErrMalformed) {
+		t.Fatal("wrong feature bitmask not detected", err)
+	}
+
+	rootCond := &CryptoConditions.Condition{
+		Type:                 4,
+		FeatureBitmask:       []byte{0x20},
+		Fingerprint:          pubkey1[:],
+		MaxFulfillmentLength: 96,
+	}
+	b, err = rootCond.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedRoot := &CryptoConditions.Condition{}
+	err = decodedRoot.UnmarshalBinary(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decodedRoot, rootCond) {
+		t.Fatal("root condition doesn't round trip", decodedRoot)
+	}
+
+	rootFul := &CryptoConditions.Ed25519Fulfillment{
+		PublicKey: pubkey1,
+		Signature: *ed25519.Sign(&privkey1, []byte("hello")),
+	}
+	b, err = rootFul.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CryptoConditions.Ed25519Validate(b, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var validationErr *conderr.ValidationError
+	err = CryptoConditions.Ed25519Validate(b, []byte("goodbye"))
+	if !errors.As(err, &validationErr) || validationErr.Type != 4 || !errors.Is(err, conderr.ErrInvalidSignature) {
+		t.Fatal("invalid signature not reported", err)
+	}
+
+	// Type 4 was the raw Ed25519 fulfillment before it numbered thresholds
+	err = CryptoConditions.Validate(registry.MakeBinaryFulfillment(4, b), []byte("hello"))
+	if !errors.Is(err, conderr.ErrMalformed) || !strings.Contains(err.Error(), "old type 4") {
+		t.Fatal("raw Ed25519 fulfillment of the old numbering not reported", err)
+	}
+}
+
+type customFulfillment struct {
+	payload []byte
+}
+
+func (ful *customFulfillment) Serialize() string {
+	return "cf:1:" + registry.FormatType(0x7f00) + ":" + base64.URLEncoding.EncodeToString(ful.payload)
+}
+
+var registerCustom sync.Once
+
+func TestRegistry(t *testing.T) {
+	registerCustom.Do(func() {
+		registry.Register(&registry.Type{
+			ID:   0x7f00,
+			Name: "Custom",
+			ParseFulfillment: func(payload []byte) (registry.Fulfillment, error) {
+				return &customFulfillment{payload}, nil
+			},
+			FulfillmentToCondition: func(payload []byte) (string, error) {
+				return "cc:1:7f00:" + base64.URLEncoding.EncodeToString(payload) + ":0", nil
+			},
+			Validate: func(payload []byte, message []byte) error {
+				if !bytes.Equal(payload, message) {
+					return errors.New("message doesn't match")
+				}
+				return nil
+			},
+			Cost: func(payload []byte) (uint64, error) {
+				return uint64(len(payload)), nil
+			},
+		})
+	})
+
+	custom := &customFulfillment{[]byte{1, 2, 3}}
+	customString := custom.Serialize()
+
+	parsed, err := entry.ParseFullfillment(customString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Serialize() != customString {
+		t.Fatal("serialization incorrect", parsed.Serialize())
+	}
+
+	condString, err := entry.FulfillmentToCondition(customString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if condString != "cc:1:7f00:AQID:0" {
+		t.Fatal("condition incorrect", condString)
+	}
+
+	if err := entry.Validate(customString, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Validate(customString, []byte{4}); err == nil {
+		t.Fatal("validated with the wrong message")
+	}
+
+	// Binary format dispatches through the same registry
+	binary := bytes.Join([][]byte{
+		encoding.MakeUvarint(0x7f00),
+		encoding.MakeVarbyte([]byte{1, 2, 3}),
+	}, []byte{})
+	if err := entry.ValidateBinary(binary, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Built-in types are registered by importing entry
+	shaFul := &Sha256.Fulfillment{
+		Preimage: []byte{42},
+	}
+	condString, err = entry.FulfillmentToCondition(shaFul.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if condString != "cc:1:1:EqD2XLJXOMMlHy3fq3Ep-4DeD38F4-EFzKwvK3EHbp0=:11" {
+		t.Fatal("condition incorrect", condString)
+	}
+
+	if _, err := entry.ParseFullfillment("cf:1:7f01:AQID"); err == nil {
+		t.Fatal("parsed unregistered type")
+	}
+}
+
+// Extra keys
+// &[197 198 13 156 213 181 160 15 105 7 66 222 66 15 212 8 172 55 20 47 34 182 117 106 213 203 6 172 119 66 87 170] &[244 9 180 60 13 13 60 215 158 30 236 128 111 107 44 54 75 151 209 13 20 19 58 42 162 147 207 0 189 188 4 136 197 198 13 156 213 181 160 15 105 7 66 222 66 15 212 8 172 55 20 47 34 182 117 106 213 203 6 172 119 66 87 170]
+// &[236 129 33 67 119 101 27 246 101 161 109 184 246 50 2 214 184 162 40 197 194 196 212 210 163 136 39 229 123 204 82 25] &[97 111 164 221 195 25 249 6 17 161 159 191 252 118 241 114 92 113 7 100 234 111 160 131 230 22 181 67 197 183 9 99 236 129 33 67 119 101 27 246 101 161 109 184 246 50 2 214 184 162 40 197 194 196 212 210 163 136 39 229 123 204 82 25]
+// &[118 97 30 186 23 231 51 77 244 88 148 216 9 177 104 120 183 209 212 48 44 133 220 62 24 92 165 7 153 68 194 83] &[117 54 222 53 77 11 219 41 154 161 185 104 208 248 30 59 132 230 116 108 150 60 215 9 221 101 210 53 150 159 129 174 118 97 30 186 23 231 51 77 244 88 148 216 9 177 104 120 183 209 212 48 44 133 220 62 24 92 165 7 153 68 194 83]
+
+func TestValidateLimits(t *testing.T) {
+	// Prefixes nested n levels above a preimage
+	nested := func(n int) string {
+		ful := (&Sha256.Fulfillment{Preimage: []byte{42}}).Serialize()
+		for i := 0; i < n; i++ {
+			ful = (&PrefixSha256.Fulfillment{SubFulfillment: ful}).Serialize()
+		}
+		return ful
+	}
+
+	limit := func(err error, name string) {
+		var e *conderr.LimitError
+		if !errors.Is(err, conderr.ErrLimitExceeded) || !errors.As(err, &e) || e.Limit != name {
+			t.Fatal("limit not enforced", name, err)
+		}
+	}
+
+	if err := entry.Validate(nested(conderr.DefaultMaxDepth), nil); err != nil {
+		t.Fatal(err)
+	}
+	limit(entry.Validate(nested(conderr.DefaultMaxDepth+1), nil), conderr.LimitDepth)
+	_, err := entry.ParseFullfillment(nested(conderr.DefaultMaxDepth + 1))
+	limit(err, conderr.LimitDepth)
+	// Deep trees are rejected before their cost is computed
+	limit(entry.ValidateWithMaxCost(nested(conderr.DefaultMaxDepth+1), nil, 1<<62), conderr.LimitDepth)
+
+	opts := &conderr.ValidationOptions{MaxDepth: 3}
+	if err := entry.ValidateWithOptions(context.Background(), nested(3), nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	limit(entry.ValidateWithOptions(context.Background(), nested(4), nil, opts), conderr.LimitDepth)
+
+	thrFul := &ThresholdSha256.Fulfillment{Threshold: 1}
+	for i := 0; i < 3; i++ {
+		sf := &Sha256.Fulfillment{Preimage: []byte{byte(i)}}
+		thrFul.SubFulfillments = append(thrFul.SubFulfillments, ThresholdSha256.WeightedString{Weight: 1, String: sf.Serialize()})
+	}
+	ful := thrFul.Serialize()
+
+	if err := entry.ValidateWithOptions(context.Background(), ful, nil, &conderr.ValidationOptions{MaxChildren: 3}); err != nil {
+		t.Fatal(err)
+	}
+	limit(entry.ValidateWithOptions(context.Background(), ful, nil, &conderr.ValidationOptions{MaxChildren: 2}), conderr.LimitChildren)
+	_, payload, err := registry.SplitFulfillment(ful)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := registry.WithOptions(context.Background(), &conderr.ValidationOptions{MaxChildren: 2})
+	limit(ThresholdSha256.ValidateConcurrent(ctx, payload, nil, 2), conderr.LimitChildren)
+
+	limit(entry.ValidateWithOptions(context.Background(), ful, nil, &conderr.ValidationOptions{MaxDecodedBytes: 8}), conderr.LimitDecodedBytes)
+
+	// Payloads too long for the limit aren't decoded, padding aside
+	small := registry.WithOptions(context.Background(), &conderr.ValidationOptions{MaxDecodedBytes: 1})
+	if _, payload, err := registry.SplitFulfillmentContext(small, "cf:1:1:Kg=="); err != nil || len(payload) != 1 {
+		t.Fatal("payload within the limit rejected", err)
+	}
+	_, _, err = registry.SplitFulfillmentContext(small, "cf:1:1:KgE=")
+	limit(err, conderr.LimitDecodedBytes)
+	_, _, err = registry.SplitFulfillment("cf:1:1:" + strings.Repeat("!", conderr.DefaultMaxDecodedBytes/3*4+4))
+	limit(err, conderr.LimitDecodedBytes)
+
+	binary, err := entry.FulfillmentToBinary(nested(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit(CryptoConditions.ValidateWithOptions(context.Background(), binary, nil, opts), conderr.LimitDepth)
+
+	// Costs and the functions without a context are limited too, counting
+	// the payload they are given
+	_, err = entry.Cost(nested(conderr.DefaultMaxDepth + 1))
+	limit(err, conderr.LimitDepth)
+	_, err = registry.CostContext(ctx, ful)
+	limit(err, conderr.LimitChildren)
+	binary, err = entry.FulfillmentToBinary(nested(conderr.DefaultMaxDepth + 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = entry.BinaryToFulfillment(binary)
+	limit(err, conderr.LimitDepth)
+	_, payload, err = registry.SplitFulfillment(nested(conderr.DefaultMaxDepth + 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = PrefixSha256.ParsePayload(payload)
+	limit(err, conderr.LimitDepth)
+	limit(PrefixSha256.Validate(payload, nil), conderr.LimitDepth)
+
+	// Each payload counts once, however often it is decoded
+	total := 0
+	for s := nested(4); ; {
+		_, payload, err := registry.SplitFulfillment(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += len(payload)
+
+		parsed, err := entry.ParseFullfillment(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefix, ok := parsed.(*PrefixSha256.Fulfillment)
+		if !ok {
+			break
+		}
+		s = prefix.SubFulfillment
+	}
+	if err := entry.ValidateWithOptions(context.Background(), nested(4), nil, &conderr.ValidationOptions{MaxDecodedBytes: total}); err != nil {
+		t.Fatal(err)
+	}
+	limit(entry.ValidateWithOptions(context.Background(), nested(4), nil, &conderr.ValidationOptions{MaxDecodedBytes: total - 1}), conderr.LimitDecodedBytes)
+
+	// Large fulfillments nested well within the depth limit are accepted
+	deep := (&Sha256.Fulfillment{Preimage: make([]byte, 5000)}).Serialize()
+	for i := 0; i < 17; i++ {
+		other := (&Sha256.Fulfillment{Preimage: []byte{byte(i)}}).Condition()
+		deep = (&ThresholdSha256.Fulfillment{
+			Threshold:       1,
+			SubFulfillments: ThresholdSha256.WeightedStrings{{Weight: 1, String: deep}},
+			SubConditions:   ThresholdSha256.WeightedStrings{{Weight: 1, String: other.Serialize()}},
+		}).Serialize()
+	}
+	if err := entry.Validate(deep, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.ValidateWithMaxCost(deep, nil, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,663 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jtremback/crypto-conditions/ThresholdSha256"
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/der"
+	"github.com/jtremback/crypto-conditions/ed25519sha256"
+	"github.com/jtremback/crypto-conditions/encoding"
+	"github.com/jtremback/crypto-conditions/entry"
+	"github.com/jtremback/crypto-conditions/prefixsha256"
+	"github.com/jtremback/crypto-conditions/rsasha256"
+	"github.com/jtremback/crypto-conditions/sha256"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Published crypto-conditions test vectors
+var derVectors = []struct {
+	name        string
+	fulfillment string
+	condition   string
+	uri         string
+	message     string
+}{
+	{
+		name:        "minimal preimage",
+		fulfillment: "A0028000",
+		condition:   "A0258020E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855810100",
+		uri:         "ni:///sha-256;47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU?fpt=preimage-sha-256&cost=0",
+	},
+	{
+		name:        "minimal prefix",
+		fulfillment: "A10B8000810100A204A0028000",
+		condition:   "A12A8020BB1AC5260C0141B7E54B26EC2330637C5597BF811951AC09E744AD20FF77E2878102040082020780",
+		uri:         "ni:///sha-256;uxrFJgwBQbflSybsIzBjfFWXv4EZUawJ50StIP934oc?fpt=prefix-sha-256&cost=1024&subtypes=preimage-sha-256",
+	},
+	{
+		name:        "minimal threshold",
+		fulfillment: "A208A004A0028000A100",
+		condition:   "A22A8020B4B84136DF48A71D73F4985C04C6767A778ECB65BA7023B4506823BEEE7631B98102040082020780",
+		uri:         "ni:///sha-256;tLhBNt9Ipx1z9JhcBMZ2eneOy2W6cCO0UGgjvu52Mbk?fpt=threshold-sha-256&cost=1024&subtypes=preimage-sha-256",
+	},
+	{
+		name:        "minimal ed25519",
+		fulfillment: "A4648020D75A980182B10AB7D54BFED3C964073A0EE172F3DAA62325AF021A68F707511A8140E5564300C360AC729086E2CC806E828A84877F1EB8E5D974D873E065224901555FB8821590A33BACC61E39701CF9B46BD25BF5F0595BBE24655141438E7A100B",
+		condition:   "A4278020799239ABA8FC4FF7EABFBC4C44E69E8BDFED993324E12ED64792ABE289CF1D5F8103020000",
+		uri:         "ni:///sha-256;eZI5q6j8T_fqv7xMROaei9_tmTMk4S7WR5Kr4onPHV8?fpt=ed25519-sha-256&cost=131072",
+	},
+}
+
+func TestDERVectors(t *testing.T) {
+	for _, v := range derVectors {
+		ful, err := der.ParseFulfillment(unhex(t, v.fulfillment))
+		if err != nil {
+			t.Fatal(v.name, err)
+		}
+
+		encoded, err := ful.Encode()
+		if err != nil {
+			t.Fatal(v.name, err)
+		}
+		if !bytes.Equal(encoded, unhex(t, v.fulfillment)) {
+			t.Fatal(v.name, "fulfillment encoding incorrect", hex.EncodeToString(encoded))
+		}
+
+		cond, err := ful.Condition()
+		if err != nil {
+			t.Fatal(v.name, err)
+		}
+		if !bytes.Equal(cond.Encode(), unhex(t, v.condition)) {
+			t.Fatal(v.name, "condition encoding incorrect", hex.EncodeToString(cond.Encode()))
+		}
+
+		parsed, err := der.ParseCondition(unhex(t, v.condition))
+		if err != nil {
+			t.Fatal(v.name, err)
+		}
+		if !bytes.Equal(parsed.Encode(), cond.Encode()) {
+			t.Fatal(v.name, "parsed condition doesn't match")
+		}
+
+		if cond.URI() != v.uri {
+			t.Fatal(v.name, "condition URI incorrect", cond.URI())
+		}
+
+		fromURI, err := der.ParseURI(v.uri)
+		if err != nil {
+			t.Fatal(v.name, err)
+		}
+		if !bytes.Equal(fromURI.Encode(), cond.Encode()) {
+			t.Fatal(v.name, "condition parsed from URI doesn't match")
+		}
+
+		if err := ful.Validate([]byte(v.message)); err != nil {
+			t.Fatal(v.name, err)
+		}
+	}
+
+	if err := der.Validate(unhex(t, derVectors[3].fulfillment), []byte("wrong")); err == nil {
+		t.Fatal("validated with the wrong message")
+	}
+}
+
+func TestDERThreshold(t *testing.T) {
+	edFul := &der.Ed25519Sha256{}
+	edFul.Sign(privkey1, []byte("hello"))
+
+	preFul := &der.PreimageSha256{Preimage: []byte{42}}
+	preCond, err := preFul.Condition()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1 of 2, the preimage isn't revealed
+	thrFul := &der.ThresholdSha256{
+		SubFulfillments: []der.Fulfillment{edFul},
+		SubConditions:   []*der.Condition{preCond},
+	}
+
+	encoded, err := thrFul.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := der.ParseFulfillment(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parsed.Validate([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	cond, err := parsed.Condition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cond.Cost != der.Ed25519Cost+2*der.ThresholdSubconditionCost {
+		t.Fatal("cost incorrect", cond.Cost)
+	}
+	if cond.Subtypes != 1<<der.TypePreimageSha256|1<<der.TypeEd25519Sha256 {
+		t.Fatal("subtypes incorrect", cond.Subtypes)
+	}
+
+	// Revealing the preimage instead gives the same condition
+	other := &der.ThresholdSha256{
+		SubFulfillments: []der.Fulfillment{preFul},
+		SubConditions:   []*der.Condition{},
+	}
+	edCond, err := edFul.Condition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.SubConditions = append(other.SubConditions, edCond)
+	otherCond, err := other.Condition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(otherCond.Fingerprint, cond.Fingerprint) {
+		t.Fatal("fingerprint depends on which subcondition is fulfilled")
+	}
+
+	// Truncated input is an error, not a panic
+	for i := range encoded {
+		if _, err := der.ParseFulfillment(encoded[:i]); err == nil {
+			t.Fatal("parsed truncated fulfillment", i)
+		}
+	}
+}
+
+func TestDERPrefix(t *testing.T) {
+	edFul := &der.Ed25519Sha256{}
+	edFul.Sign(privkey1, []byte("transfer:1234"))
+
+	ful := &der.PrefixSha256{
+		Prefix:           []byte("transfer:"),
+		MaxMessageLength: 4,
+		SubFulfillment:   edFul,
+	}
+
+	encoded, err := ful.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := der.Validate(encoded, []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	if err := der.Validate(encoded, []byte("12345")); err == nil {
+		t.Fatal("validated a message longer than the maximum")
+	}
+	if err := der.Validate(encoded, []byte("4321")); err == nil {
+		t.Fatal("validated with the wrong message")
+	}
+
+	cond, err := ful.Condition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cond.Cost != 9+4+der.Ed25519Cost+der.PrefixCost {
+		t.Fatal("cost incorrect", cond.Cost)
+	}
+	if cond.Subtypes != 1<<der.TypeEd25519Sha256 {
+		t.Fatal("subtypes incorrect", cond.Subtypes)
+	}
+}
+
+func TestDERConversion(t *testing.T) {
+	shaFul := &Sha256.Fulfillment{Preimage: []byte{}}
+	encoded, err := shaFul.DER().Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, unhex(t, "A0028000")) {
+		t.Fatal("preimage conversion incorrect", hex.EncodeToString(encoded))
+	}
+
+	edFul := &Ed25519Sha256.Fulfillment{
+		PublicKey:    pubkey1,
+		FixedMessage: []byte{42},
+	}
+	edFul.Sign(privkey1)
+	if err := edFul.DER().Validate([]byte{42}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDEREncoding(t *testing.T) {
+	for _, n := range []uint64{0, 1, 127, 128, 255, 256, 131072, 1<<63 + 5} {
+		got, err := encoding.GetDERUint(encoding.MakeDERUint(n))
+		if err != nil || got != n {
+			t.Fatal("integer round trip failed", n, got, err)
+		}
+	}
+
+	if !bytes.Equal(encoding.MakeDERBitString(1<<der.TypePreimageSha256), []byte{7, 0x80}) {
+		t.Fatal("bit string incorrect")
+	}
+	for _, bits := range []uint32{0, 1, 0x1f, 0x100, 0x80000000} {
+		got, err := encoding.GetDERBitString(encoding.MakeDERBitString(bits))
+		if err != nil || got != bits {
+			t.Fatal("bit string round trip failed", bits, got, err)
+		}
+	}
+
+	long := encoding.MakeDER(encoding.DERContext, make([]byte, 300))
+	if !bytes.Equal(long[:4], []byte{0x80, 0x82, 0x01, 0x2c}) {
+		t.Fatal("long length incorrect", long[:4])
+	}
+	if _, content, rest, err := encoding.GetDER(long); err != nil || len(content) != 300 || len(rest) != 0 {
+		t.Fatal("long length round trip failed", err)
+	}
+
+	// Non-minimal lengths are not DER
+	if _, _, _, err := encoding.GetDER([]byte{0x80, 0x81, 0x01, 0}); err == nil {
+		t.Fatal("parsed non-minimal length")
+	}
+
+	// Lengths past the input are malformed, including those that don't fit
+	// in an int on 32-bit platforms
+	for _, header := range [][]byte{{0x80, 0x82, 0x01, 0x2c}, {0x80, 0x84, 0xff, 0xff, 0xff, 0xff}} {
+		var syntaxErr *conderr.SyntaxError
+		_, _, rest, err := encoding.GetDER(append(header, 0))
+		if !errors.As(err, &syntaxErr) || syntaxErr.Offset != 1 || len(rest) != len(header)+1 {
+			t.Fatal("length past the input not detected", header, err)
+		}
+
+		// Inside a fulfillment it is reported with the fulfillment's type
+		var parseErr *conderr.ParseError
+		preimage := append([]byte{0xa0, byte(len(header) + 1)}, append(header, 0)...)
+		if _, err := der.ParseFulfillment(preimage); !errors.As(err, &parseErr) || parseErr.Type != der.TypePreimageSha256 {
+			t.Fatal("length past the input not reported as a parse error", header, err)
+		}
+	}
+}
+
+// Binary format of a condition
+func mustBinary(t *testing.T, cond interface{ MarshalBinary() ([]byte, error) }) []byte {
+	b, err := cond.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestURI(t *testing.T) {
+	shaFul := &Sha256.Fulfillment{
+		Preimage: []byte{42},
+	}
+
+	uri, err := entry.FulfillmentToURI(shaFul.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri != "ni:///sha-256;aEiIwOuxfzdCmLZe4oB1JsBmCUxwG8x-u-HBCV9JT8E?fpt=preimage-sha-256&cost=1" {
+		t.Fatal("URI incorrect", uri)
+	}
+
+	// The fingerprint hashes the DER encoding, not the legacy one
+	shaCond := shaFul.Condition()
+	if strings.Contains(uri, base64.RawURLEncoding.EncodeToString(shaCond.Hash[:])) {
+		t.Fatal("URI carries the legacy fingerprint")
+	}
+
+	// Compound fulfillments convert along with their subfulfillments
+	prefixFul := &PrefixSha256.Fulfillment{
+		Prefix:           []byte("prefix"),
+		MaxMessageLength: 16,
+		SubFulfillment:   shaFul.Serialize(),
+	}
+	thrFul := &ThresholdSha256.Fulfillment{
+		Threshold: 1,
+		SubFulfillments: ThresholdSha256.WeightedStrings{
+			{Weight: 1, String: shaFul.Serialize()},
+			{Weight: 1, String: prefixFul.Serialize()},
+		},
+	}
+	for _, ful := range []string{prefixFul.Serialize(), thrFul.Serialize()} {
+		converted, err := entry.FulfillmentToDER(ful)
+		if err != nil {
+			t.Fatal(ful, err)
+		}
+		if err := converted.Validate([]byte("message")); err != nil {
+			t.Fatal("converted fulfillment not valid", ful, err)
+		}
+		cond, err := converted.Condition()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uri, err := entry.FulfillmentToURI(ful); err != nil || uri != cond.URI() {
+			t.Fatal("URI doesn't match the converted fulfillment", uri, err)
+		}
+	}
+
+	// Weights and subconditions have no equivalent in the final draft
+	weighted := &ThresholdSha256.Fulfillment{
+		Threshold: 2,
+		SubFulfillments: ThresholdSha256.WeightedStrings{
+			{Weight: 2, String: shaFul.Serialize()},
+		},
+	}
+	otherCond := (&Sha256.Fulfillment{Preimage: []byte{43}}).Condition()
+	partial := &ThresholdSha256.Fulfillment{
+		Threshold: 1,
+		SubFulfillments: ThresholdSha256.WeightedStrings{
+			{Weight: 1, String: shaFul.Serialize()},
+		},
+		SubConditions: ThresholdSha256.WeightedStrings{
+			{Weight: 1, String: otherCond.Serialize()},
+		},
+	}
+	for _, ful := range []string{weighted.Serialize(), partial.Serialize()} {
+		if _, err := entry.FulfillmentToURI(ful); !errors.Is(err, conderr.ErrNotConvertible) {
+			t.Fatal("converted fulfillment without an equivalent", ful, err)
+		}
+	}
+
+	// Signature conditions convert if their keys are known
+	edFul := &Ed25519Sha256.Fulfillment{PublicKey: pubkey1, MessageId: []byte{1}}
+	edFul.Sign(privkey1)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaFul := &RsaSha256.Fulfillment{}
+	if err := rsaFul.Sign(rsaKey, []byte("message")); err != nil {
+		t.Fatal(err)
+	}
+	edCond, rsaCond := edFul.Condition(), rsaFul.Condition()
+	conds := map[string]interface{}{edFul.Serialize(): &edCond, rsaFul.Serialize(): &rsaCond}
+	for ful, cond := range conds {
+		uri, err := entry.ConditionToURI(cond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, err := entry.FulfillmentToURI(ful); err != nil || uri != expected {
+			t.Fatal("URI doesn't match the converted fulfillment", uri, expected, err)
+		}
+	}
+
+	// Fingerprints alone don't convert
+	edOnly, rsaOnly := &Ed25519Sha256.Condition{}, &RsaSha256.Condition{}
+	if err := edOnly.UnmarshalBinary(mustBinary(t, &edCond)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rsaOnly.UnmarshalBinary(mustBinary(t, &rsaCond)); err != nil {
+		t.Fatal(err)
+	}
+	for _, cond := range []interface{}{edOnly, rsaOnly, &shaCond} {
+		if _, err := entry.ConditionToURI(cond); !errors.Is(err, conderr.ErrNotConvertible) {
+			t.Fatal("converted a condition without an equivalent", cond, err)
+		}
+	}
+
+	invalid := []string{
+		"ni:///sha-256;47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU",
+		"ni:///sha-256;47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU=?fpt=preimage-sha-256&cost=0",
+		"ni:///sha-256;47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU?fpt=sha-256&cost=0",
+		"ni:///sha-256;47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU?fpt=preimage-sha-256&cost=-1",
+		"ni:///sha-256;47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU?fpt=preimage-sha-256&cost=0&subtypes=preimage-sha-256",
+		"ni:///md5;47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU?fpt=preimage-sha-256&cost=0",
+	}
+	for _, s := range invalid {
+		if _, err := der.ParseURI(s); err == nil {
+			t.Fatal("parsed invalid URI", s)
+		}
+	}
+}
+
+func TestDERErrors(t *testing.T) {
+	edFul := &der.Ed25519Sha256{}
+	edFul.Sign(privkey1, []byte("hello"))
+	ful := &der.PrefixSha256{
+		Prefix:           []byte("hel"),
+		MaxMessageLength: 2,
+		SubFulfillment: &der.ThresholdSha256{
+			SubFulfillments: []der.Fulfillment{edFul},
+		},
+	}
+
+	encoded, err := ful.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = der.Validate(encoded, []byte("lo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var validationErr *conderr.ValidationError
+	err = der.Validate(encoded, []byte("ls"))
+	if !errors.Is(err, conderr.ErrInvalidSignature) || !errors.As(err, &validationErr) {
+		t.Fatal("bad signature not detected", err)
+	}
+	if validationErr.Type != der.TypeEd25519Sha256 || !reflect.DeepEqual(validationErr.Path, []int{0, 0}) {
+		t.Fatal("wrong validation error", validationErr.Type, validationErr.Path)
+	}
+
+	err = der.Validate(encoded, []byte("low"))
+	if !errors.Is(err, conderr.ErrMessageTooLong) {
+		t.Fatal("long message not detected", err)
+	}
+
+	// Truncated encoding
+	err = der.Validate(encoded[:len(encoded)-1], []byte("lo"))
+	if !errors.Is(err, conderr.ErrMalformed) {
+		t.Fatal("truncated encoding not detected", err)
+	}
+
+	// Short signature in the nested Ed25519 fulfillment
+	var parseErr *conderr.ParseError
+	short := append([]byte{}, encoded...)
+	i := bytes.Index(short, append([]byte{0x81, 64}, edFul.Signature[:]...))
+	short[i+1] = 63
+	err = der.Validate(short, []byte("lo"))
+	if !errors.Is(err, conderr.ErrMalformed) || !errors.As(err, &parseErr) {
+		t.Fatal("short signature not detected", err)
+	}
+	if parseErr.Type != der.TypeEd25519Sha256 || !reflect.DeepEqual(parseErr.Path, []int{0, 0}) {
+		t.Fatal("wrong parse error", parseErr.Type, parseErr.Path)
+	}
+
+	// Unsupported type
+	err = der.Validate([]byte{0xa5, 0x00}, nil)
+	if !errors.Is(err, conderr.ErrUnsupportedType) || !errors.As(err, &parseErr) || parseErr.Type != 5 {
+		t.Fatal("unsupported type not detected", err)
+	}
+}
+
+func TestDERStream(t *testing.T) {
+	for _, v := range derVectors {
+		// Nothing after the fulfillment is read
+		r := bytes.NewReader(append(unhex(t, v.fulfillment), 0xff))
+		if err := der.ValidateReader(r, bytes.NewReader(unhex(t, v.message)), der.DefaultMaxFulfillmentLength, der.DefaultMaxMessageLength); err != nil {
+			t.Fatal(v.name, err)
+		}
+		if r.Len() != 1 {
+			t.Fatal(v.name, "read past the fulfillment")
+		}
+	}
+
+	privkey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := bytes.Repeat([]byte("0123456789abcdef"), 1<<18)
+	rsaFul := &der.RsaSha256{}
+	if err := rsaFul.Sign(privkey, append([]byte("doc:"), message...)); err != nil {
+		t.Fatal(err)
+	}
+
+	ful := &der.ThresholdSha256{
+		SubFulfillments: []der.Fulfillment{
+			&der.PreimageSha256{Preimage: []byte{42}},
+			&der.PrefixSha256{
+				Prefix:           []byte("doc:"),
+				MaxMessageLength: uint64(len(message)) + 4,
+				SubFulfillment:   rsaFul,
+			},
+		},
+	}
+
+	encoded, err := ful.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cond, err := ful.Condition()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := der.ValidateFulfillmentReader(cond, bytes.NewReader(encoded), bytes.NewReader(message), len(encoded), int64(len(message))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := der.ValidateFulfillmentReader(cond, bytes.NewReader(encoded), bytes.NewReader(message[1:]), len(encoded), int64(len(message))); !errors.Is(err, conderr.ErrInvalidSignature) {
+		t.Fatal("validated with the wrong message", err)
+	}
+
+	if err := der.ValidateFulfillmentReader(cond, bytes.NewReader(encoded), bytes.NewReader(message), len(encoded), int64(len(message))-1); !errors.Is(err, conderr.ErrMessageTooLong) {
+		t.Fatal("read a message longer than the limit", err)
+	}
+
+	if err := der.ValidateFulfillmentReader(cond, bytes.NewReader(encoded), bytes.NewReader(message), len(encoded)-1, int64(len(message))); !errors.Is(err, conderr.ErrMalformed) {
+		t.Fatal("read a fulfillment longer than the limit", err)
+	}
+
+	if err := der.ValidateFulfillmentReader(cond, bytes.NewReader(encoded[:len(encoded)-1]), bytes.NewReader(message), len(encoded), int64(len(message))); !errors.Is(err, conderr.ErrMalformed) {
+		t.Fatal("read a truncated fulfillment", err)
+	}
+
+	preCond, err := ful.SubFulfillments[0].Condition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := der.ValidateFulfillmentReader(preCond, bytes.NewReader(encoded), bytes.NewReader(message), len(encoded), int64(len(message))); !errors.Is(err, conderr.ErrConditionMismatch) {
+		t.Fatal("validated against the wrong condition", err)
+	}
+
+	// Ed25519 signatures are checked against the whole message
+	edFul := &der.Ed25519Sha256{}
+	edFul.Sign(privkey1, []byte("transfer:1234"))
+	prefixFul := &der.PrefixSha256{
+		Prefix:           []byte("transfer:"),
+		MaxMessageLength: 4,
+		SubFulfillment:   edFul,
+	}
+
+	if err := der.ValidateMessage(prefixFul, strings.NewReader("1234"), 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := der.ValidateMessage(prefixFul, strings.NewReader("4321"), 4); !errors.Is(err, conderr.ErrInvalidSignature) {
+		t.Fatal("validated with the wrong message", err)
+	}
+	if err := der.ValidateMessage(prefixFul, strings.NewReader("12345"), 5); !errors.Is(err, conderr.ErrMessageTooLong) {
+		t.Fatal("validated a message longer than the maximum", err)
+	}
+
+	// Types defined elsewhere get the whole message, not an empty one
+	msgFul := &der.PrefixSha256{
+		Prefix:           []byte("transfer:"),
+		MaxMessageLength: 4,
+		SubFulfillment:   &messageFulfillment{Fulfillment: edFul, message: "transfer:1234"},
+	}
+	if err := der.ValidateMessage(msgFul, strings.NewReader("1234"), 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := der.ValidateMessage(msgFul, strings.NewReader("4321"), 4); !errors.Is(err, conderr.ErrInvalidSignature) {
+		t.Fatal("validated with the wrong message", err)
+	}
+}
+
+// A fulfillment of a type der doesn't know, valid only against one message
+type messageFulfillment struct {
+	der.Fulfillment
+	message string
+}
+
+func (ful *messageFulfillment) Validate(message []byte) error {
+	if string(message) != ful.message {
+		return conderr.ErrInvalidSignature
+	}
+	return nil
+}
+
+func TestDERLimits(t *testing.T) {
+	// Prefixes nested n levels above a preimage
+	nested := func(n int) []byte {
+		var ful der.Fulfillment = &der.PreimageSha256{Preimage: []byte{42}}
+		for i := 0; i < n; i++ {
+			ful = &der.PrefixSha256{SubFulfillment: ful}
+		}
+		encoded, err := ful.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encoded
+	}
+
+	limit := func(err error, name string) {
+		var e *conderr.LimitError
+		if !errors.Is(err, conderr.ErrLimitExceeded) || !errors.As(err, &e) || e.Limit != name {
+			t.Fatal("limit not enforced", name, err)
+		}
+	}
+
+	if err := der.Validate(nested(conderr.DefaultMaxDepth), nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err := der.ParseFulfillment(nested(conderr.DefaultMaxDepth + 1))
+	limit(err, conderr.LimitDepth)
+
+	opts := &conderr.ValidationOptions{MaxDepth: 3}
+	if err := der.ValidateWithOptions(context.Background(), nested(3), nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	limit(der.ValidateWithOptions(context.Background(), nested(4), nil, opts), conderr.LimitDepth)
+
+	thrFul := &der.ThresholdSha256{
+		SubFulfillments: []der.Fulfillment{&der.PreimageSha256{Preimage: []byte{42}}},
+	}
+	for i := 0; i < 2; i++ {
+		cond, err := (&der.PreimageSha256{Preimage: []byte{byte(i)}}).Condition()
+		if err != nil {
+			t.Fatal(err)
+		}
+		thrFul.SubConditions = append(thrFul.SubConditions, cond)
+	}
+	encoded, err := thrFul.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := der.ParseFulfillmentWithOptions(context.Background(), encoded, &conderr.ValidationOptions{MaxChildren: 3}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = der.ParseFulfillmentWithOptions(context.Background(), encoded, &conderr.ValidationOptions{MaxChildren: 2})
+	limit(err, conderr.LimitChildren)
+
+	if _, err := der.ParseFulfillmentWithOptions(context.Background(), encoded, &conderr.ValidationOptions{MaxDecodedBytes: len(encoded)}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = der.ParseFulfillmentWithOptions(context.Background(), encoded, &conderr.ValidationOptions{MaxDecodedBytes: len(encoded) - 1})
+	limit(err, conderr.LimitDecodedBytes)
+}
@@ -0,0 +1,434 @@
+package test
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/agl/ed25519"
+	"github.com/agl/ed25519/edwards25519"
+	CryptoConditions "github.com/jtremback/crypto-conditions"
+	"github.com/jtremback/crypto-conditions/ThresholdSha256"
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/der"
+	"github.com/jtremback/crypto-conditions/ed25519batch"
+	"github.com/jtremback/crypto-conditions/ed25519sha256"
+	"github.com/jtremback/crypto-conditions/registry"
+)
+
+// Signs a message with each of n new keys
+func batchSignatures(t testing.TB, n int) ([]*der.Ed25519Sha256, [][]byte) {
+	fulfillments := []*der.Ed25519Sha256{}
+	messages := [][]byte{}
+	for i := 0; i < n; i++ {
+		_, privkey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		message := []byte("message " + strconv.Itoa(i))
+		ful := &der.Ed25519Sha256{}
+		ful.Sign(*privkey, message)
+
+		fulfillments = append(fulfillments, ful)
+		messages = append(messages, message)
+	}
+
+	return fulfillments, messages
+}
+
+func TestEd25519Batch(t *testing.T) {
+	if valid := (&ed25519batch.Verifier{}).Verify(); len(valid) != 0 {
+		t.Fatal("empty batch has results", valid)
+	}
+
+	fulfillments, messages := batchSignatures(t, 40)
+
+	v := &ed25519batch.Verifier{}
+	for i, ful := range fulfillments {
+		v.Add(&ful.PublicKey, messages[i], &ful.Signature)
+	}
+
+	// Checks the batch equation, then each signature
+	if !v.VerifyAll() {
+		t.Fatal("valid batch rejected")
+	}
+	for i, ok := range v.Verify() {
+		if !ok {
+			t.Fatal("valid signature rejected", i)
+		}
+	}
+
+	// Failures are told apart, and agree with ed25519.Verify
+	bad := map[int]bool{3: true, 17: true, 39: true}
+	v = &ed25519batch.Verifier{}
+	for i, ful := range fulfillments {
+		sig := ful.Signature
+		switch i {
+		case 3:
+			sig[0] ^= 1
+		case 17:
+			sig[40] ^= 1
+		case 39:
+			// Not reduced enough
+			sig[63] |= 0x80
+		}
+		v.Add(&ful.PublicKey, messages[i], &sig)
+	}
+
+	if v.VerifyAll() {
+		t.Fatal("invalid batch accepted")
+	}
+	for i, ok := range v.Verify() {
+		if ok == bad[i] {
+			t.Fatal("batch result incorrect", i, ok)
+		}
+	}
+
+	// A single signature
+	v = &ed25519batch.Verifier{}
+	v.Add(&fulfillments[0].PublicKey, messages[0], &fulfillments[0].Signature)
+	if !v.VerifyAll() {
+		t.Fatal("valid signature rejected")
+	}
+	v.Add(&fulfillments[0].PublicKey, messages[1], &fulfillments[0].Signature)
+	if valid := v.Verify(); !reflect.DeepEqual(valid, []bool{true, false}) {
+		t.Fatal("results incorrect", valid)
+	}
+}
+
+// Counts the validations of a fulfillment
+type countedFulfillment struct {
+	der.Fulfillment
+	validated int
+}
+
+func (ful *countedFulfillment) Validate(message []byte) error {
+	ful.validated++
+	return ful.Fulfillment.Validate(message)
+}
+
+func TestDERValidateBatch(t *testing.T) {
+	signers := []*der.Ed25519Sha256{}
+	for i := 0; i < 30; i++ {
+		_, privkey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ful := &der.Ed25519Sha256{}
+		ful.Sign(*privkey, []byte("transfer:1234"))
+		signers = append(signers, ful)
+	}
+
+	threshold := &der.ThresholdSha256{}
+	for _, ful := range signers {
+		threshold.SubFulfillments = append(threshold.SubFulfillments, ful)
+	}
+
+	prefixed := &der.PrefixSha256{
+		Prefix:           []byte("transfer:"),
+		MaxMessageLength: 4,
+		SubFulfillment:   threshold,
+	}
+
+	others, messages := batchSignatures(t, 2)
+
+	fulfillments := []der.Fulfillment{prefixed, others[0], prefixed, others[1], prefixed}
+	batchMessages := [][]byte{[]byte("1234"), messages[0], []byte("4321"), messages[0], []byte("12345")}
+
+	errs := der.ValidateBatch(fulfillments, batchMessages)
+	for i, ful := range fulfillments {
+		if !reflect.DeepEqual(errs[i], ful.Validate(batchMessages[i])) {
+			t.Fatal("batch error incorrect", i, errs[i])
+		}
+	}
+
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatal(errs[0], errs[1])
+	}
+	if !errors.Is(errs[2], conderr.ErrInvalidSignature) || !errors.Is(errs[3], conderr.ErrInvalidSignature) {
+		t.Fatal("validated with the wrong message", errs[2], errs[3])
+	}
+	if !errors.Is(errs[4], conderr.ErrMessageTooLong) {
+		t.Fatal("validated a message longer than the maximum", errs[4])
+	}
+
+	// Fulfillments are validated once, and their signatures verified once
+	counted := &countedFulfillment{Fulfillment: others[0]}
+	errs = der.ValidateBatch([]der.Fulfillment{&der.ThresholdSha256{
+		SubFulfillments: []der.Fulfillment{counted, others[1]},
+	}}, [][]byte{messages[0]})
+	if !errors.Is(errs[0], conderr.ErrInvalidSignature) || counted.validated != 1 {
+		t.Fatal("fulfillment not validated once", errs[0], counted.validated)
+	}
+
+	errs = der.ValidateBatch(fulfillments, batchMessages[:2])
+	for _, err := range errs {
+		if !errors.Is(err, conderr.ErrMalformed) {
+			t.Fatal("missing messages not detected", errs)
+		}
+	}
+
+	payloads := [][]byte{}
+	for _, ful := range others {
+		payloads = append(payloads, append(ful.PublicKey[:], ful.Signature[:]...))
+	}
+	payloads = append(payloads, []byte{1, 2, 3})
+
+	var validationErr *conderr.ValidationError
+	errs = CryptoConditions.Ed25519ValidateBatch(payloads, [][]byte{messages[0], messages[0], nil})
+	if errs[0] != nil || !errors.Is(errs[1], conderr.ErrInvalidSignature) || !errors.Is(errs[2], conderr.ErrMalformed) {
+		t.Fatal("batch errors incorrect", errs)
+	}
+	if !errors.As(errs[1], &validationErr) || validationErr.Type != 4 {
+		t.Fatal("invalid signature not typed", errs[1])
+	}
+
+	errs = CryptoConditions.Ed25519ValidateBatch(payloads, [][]byte{messages[0]})
+	for _, err := range errs {
+		if !errors.Is(err, conderr.ErrMalformed) {
+			t.Fatal("missing messages not detected", errs)
+		}
+	}
+}
+
+// Signatures for public keys of small order, without a private key
+func smallOrderSignatures(t *testing.T) ([][32]byte, [][]byte, [][64]byte) {
+	// The identity, and the point of order 2
+	identity := [32]byte{1}
+	order2 := [32]byte{0xec}
+	for i := 1; i < 31; i++ {
+		order2[i] = 0xff
+	}
+	order2[31] = 0x7f
+
+	publicKeys := [][32]byte{}
+	messages := [][]byte{}
+	signatures := [][64]byte{}
+	for i := 0; i < 8; i++ {
+		var s [32]byte
+		if _, err := rand.Read(s[:31]); err != nil {
+			t.Fatal(err)
+		}
+
+		// R = s B, or s B plus the point of order 2
+		var r edwards25519.ExtendedGroupElement
+		edwards25519.GeScalarMultBase(&r, &s)
+		if i%2 == 0 {
+			edwards25519.FeNeg(&r.X, &r.X)
+			edwards25519.FeNeg(&r.Y, &r.Y)
+		}
+		var rBytes [32]byte
+		r.ToBytes(&rBytes)
+
+		var sig [64]byte
+		copy(sig[:], rBytes[:])
+		copy(sig[32:], s[:])
+
+		publicKey := identity
+		if i%4 >= 2 {
+			publicKey = order2
+		}
+
+		publicKeys = append(publicKeys, publicKey)
+		messages = append(messages, []byte("message "+strconv.Itoa(i)))
+		signatures = append(signatures, sig)
+	}
+
+	return publicKeys, messages, signatures
+}
+
+func TestEd25519BatchSmallOrder(t *testing.T) {
+	fulfillments, validMessages := batchSignatures(t, 4)
+
+	// Batches agree with ed25519.Verify every time, whatever the random
+	// coefficients
+	for n := 0; n < 32; n++ {
+		publicKeys, messages, signatures := smallOrderSignatures(t)
+		for i, ful := range fulfillments {
+			publicKeys = append(publicKeys, ful.PublicKey)
+			messages = append(messages, validMessages[i])
+			signatures = append(signatures, ful.Signature)
+		}
+
+		v := &ed25519batch.Verifier{}
+		all := true
+		for i := range publicKeys {
+			v.Add(&publicKeys[i], messages[i], &signatures[i])
+			all = all && ed25519.Verify(&publicKeys[i], messages[i], &signatures[i])
+		}
+
+		for i, ok := range v.Verify() {
+			if ok != ed25519.Verify(&publicKeys[i], messages[i], &signatures[i]) {
+				t.Fatal("batch disagrees with ed25519.Verify", i, ok)
+			}
+		}
+		if v.VerifyAll() != all {
+			t.Fatal("batch disagrees with ed25519.Verify")
+		}
+
+		// The signatures ed25519.Verify accepts are accepted together
+		v = &ed25519batch.Verifier{}
+		for i := range publicKeys {
+			if ed25519.Verify(&publicKeys[i], messages[i], &signatures[i]) {
+				v.Add(&publicKeys[i], messages[i], &signatures[i])
+			}
+		}
+		if !v.VerifyAll() {
+			t.Fatal("valid signatures rejected together")
+		}
+	}
+}
+
+func BenchmarkEd25519Verify(b *testing.B) {
+	fulfillments, messages := batchSignatures(b, 64)
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		for i, ful := range fulfillments {
+			ed25519.Verify(&ful.PublicKey, messages[i], &ful.Signature)
+		}
+	}
+}
+
+func BenchmarkEd25519Batch(b *testing.B) {
+	fulfillments, messages := batchSignatures(b, 64)
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		v := &ed25519batch.Verifier{}
+		for i, ful := range fulfillments {
+			v.Add(&ful.PublicKey, messages[i], &ful.Signature)
+		}
+		v.Verify()
+	}
+}
+
+// Batches with an invalid signature are rejected without verifying each
+func BenchmarkEd25519BatchInvalid(b *testing.B) {
+	fulfillments, messages := batchSignatures(b, 64)
+	fulfillments[63].Signature[0] ^= 1
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		v := &ed25519batch.Verifier{}
+		for i, ful := range fulfillments {
+			v.Add(&ful.PublicKey, messages[i], &ful.Signature)
+		}
+		v.VerifyAll()
+	}
+}
+
+// Signs the message with a new key, adding the point of order 2 to R. The
+// batch equation holds for such signatures, while ed25519.Verify rejects
+// them.
+func torsionedSignature(t *testing.T, message []byte) ([32]byte, [64]byte) {
+	var seed [32]byte
+	var random [64]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rand.Read(random[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha512.Sum512(seed[:])
+	var a [32]byte
+	copy(a[:], digest[:32])
+	a[0] &= 248
+	a[31] &= 127
+	a[31] |= 64
+
+	var publicKey [32]byte
+	var point edwards25519.ExtendedGroupElement
+	edwards25519.GeScalarMultBase(&point, &a)
+	point.ToBytes(&publicKey)
+
+	// R = r B plus the point of order 2, which negates both coordinates
+	var r [32]byte
+	edwards25519.ScReduce(&r, &random)
+	edwards25519.GeScalarMultBase(&point, &r)
+	edwards25519.FeNeg(&point.X, &point.X)
+	edwards25519.FeNeg(&point.Y, &point.Y)
+	var rBytes [32]byte
+	point.ToBytes(&rBytes)
+
+	h := sha512.New()
+	h.Write(rBytes[:])
+	h.Write(publicKey[:])
+	h.Write(message)
+	var k [32]byte
+	var hram [64]byte
+	h.Sum(hram[:0])
+	edwards25519.ScReduce(&k, &hram)
+
+	var s [32]byte
+	edwards25519.ScMulAdd(&s, &k, &a, &r)
+
+	var sig [64]byte
+	copy(sig[:], rBytes[:])
+	copy(sig[32:], s[:])
+
+	if ed25519.Verify(&publicKey, message, &sig) {
+		t.Fatal("torsioned signature accepted by ed25519.Verify")
+	}
+	return publicKey, sig
+}
+
+func TestEd25519BatchTorsion(t *testing.T) {
+	message := []byte("torsioned")
+	_, privkey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := &der.Ed25519Sha256{}
+	good.Sign(*privkey, message)
+
+	goodFul := &Ed25519Sha256.Fulfillment{PublicKey: good.PublicKey, FixedMessage: message, Signature: good.Signature}
+	goodCond := goodFul.Condition()
+
+	// Whatever the random coefficients, the torsioned signature is rejected
+	// by the verifier, by a legacy threshold and by a DER one
+	for n := 0; n < 64; n++ {
+		publicKey, sig := torsionedSignature(t, message)
+
+		v := &ed25519batch.Verifier{}
+		v.Add(&good.PublicKey, message, &good.Signature)
+		v.Add(&publicKey, message, &sig)
+		if valid := v.Verify(); !reflect.DeepEqual(valid, []bool{true, false}) {
+			t.Fatal("torsioned signature accepted", valid)
+		}
+		if v.VerifyAll() {
+			t.Fatal("torsioned batch accepted")
+		}
+
+		badFul := &Ed25519Sha256.Fulfillment{PublicKey: publicKey, FixedMessage: message, Signature: sig}
+		badCond := badFul.Condition()
+		thrFul := &ThresholdSha256.Fulfillment{
+			Threshold: 2,
+			SubConditions: ThresholdSha256.WeightedStrings{
+				{Weight: 1, String: goodCond.Serialize()},
+				{Weight: 1, String: badCond.Serialize()},
+			},
+			SubFulfillments: ThresholdSha256.WeightedStrings{
+				{Weight: 1, String: goodFul.Serialize()},
+				{Weight: 1, String: badFul.Serialize()},
+			},
+		}
+		if err := registry.Validate(thrFul.Serialize(), nil); !errors.Is(err, conderr.ErrInvalidSignature) {
+			t.Fatal("torsioned threshold accepted", err)
+		}
+
+		derFul := &der.ThresholdSha256{SubFulfillments: []der.Fulfillment{
+			good,
+			&der.Ed25519Sha256{PublicKey: publicKey, Signature: sig},
+		}}
+		errs := der.ValidateBatch([]der.Fulfillment{derFul}, [][]byte{message})
+		if !errors.Is(errs[0], conderr.ErrInvalidSignature) {
+			t.Fatal("torsioned DER threshold accepted", errs[0])
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	CryptoConditions "github.com/jtremback/crypto-conditions"
+	"github.com/jtremback/crypto-conditions/ThresholdSha256"
+	"github.com/jtremback/crypto-conditions/der"
+	"github.com/jtremback/crypto-conditions/ed25519sha256"
+	"github.com/jtremback/crypto-conditions/encoding"
+	"github.com/jtremback/crypto-conditions/entry"
+	"github.com/jtremback/crypto-conditions/prefixsha256"
+	"github.com/jtremback/crypto-conditions/registry"
+	"github.com/jtremback/crypto-conditions/rsasha256"
+	"github.com/jtremback/crypto-conditions/sha256"
+)
+
+// Fulfillments and conditions of the other tests, on top of the corpus in
+// testdata/fuzz
+var fuzzFulfillments = []string{
+	"cf:1:1:Kg==",
+	"cf:1:8:IMXGDZzVtaAPaQdC3kIP1AisNxQvIrZ1atXLBqx3QleqBQICAgICASqfjQYBWkA2xX6p4XT02llNku672lV8FravSFvXd8-d1U35qBgkGDFsWSOh5AmIlePfSOLpe9lYjjRKamenZopT2FvtJTsN",
+}
+
+// Threshold fulfillments, whose entries seed the fuzzing of the deprecated
+// root parser
+var fuzzThresholds = []string{
+	"cf:1:4:AhEBD2NmOjE6MTphR1ZzYkc4PTgCNmNjOjE6MTpZcVhyMFR4Z2lkaEFta1dFd1FjWU80eFNGTXRiQ21KTmQ5WlZqNzNyeWN3PToxMd4BAdsBY2Y6MToyOkJtaGxiR3h2SUFxVEFXTm1PakU2T0RwSlRWaEhSRnA2Vm5SaFFWQmhVV1JETTJ0SlVERkJhWE5PZUZGMlNYSmFNV0YwV0V4Q2NYZ3pVV3hsY1VGdGJHdEJVMjlCUVVWRWNVdDRUVmhNTlcxbmFWZFVXa1ZqY0hadlJWTTBSVFZWUWpKS1UwaE1WbmRKVFhWSU1FTjZkVE5MVjFOZk1XbFpiMWxLYzNCMlMyZEpiRFJuUzJWVU5GVnNlREJPVUZGQ2JHbFBRbWRRUW5ac1FrMXZTQT09",
+}
+
+var fuzzConditions = []string{
+	"cc:1:1:EqD2XLJXOMMlHy3fq3Ep-4DeD38F4-EFzKwvK3EHbp0=:11",
+	"cc:1:7f00:AQID:0",
+}
+
+func FuzzVarray(f *testing.F) {
+	f.Add(encoding.MakeVarray([][]byte{{1, 1, 1, 1, 1}, {2, 2, 2}, {3, 3, 3, 3}}))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		items, err := encoding.ParseVarray(b)
+		if err != nil {
+			return
+		}
+
+		// Uvarints are minimal, so the encoding is unique
+		if !bytes.Equal(encoding.MakeVarray(items), b) {
+			t.Fatal("varray doesn't round trip", b)
+		}
+	})
+}
+
+func FuzzParseFulfillment(f *testing.F) {
+	for _, s := range fuzzFulfillments {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		registry.FulfillmentToCondition(s)
+		registry.Cost(s)
+		registry.Validate(s, nil)
+
+		ful, err := registry.ParseFulfillment(s)
+		if err != nil {
+			return
+		}
+
+		serialized := ful.Serialize()
+		reparsed, err := registry.ParseFulfillment(serialized)
+		if err != nil {
+			t.Fatal("serialized fulfillment doesn't parse", s, serialized, err)
+		}
+		if reparsed.Serialize() != serialized {
+			t.Fatal("fulfillment doesn't round trip", s, serialized)
+		}
+	})
+}
+
+func FuzzParseCondition(f *testing.F) {
+	for _, s := range fuzzConditions {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		cond, err := registry.ParseCondition(s)
+		if err != nil {
+			return
+		}
+
+		reparsed, err := registry.ParseCondition(cond.Serialize())
+		if err != nil {
+			t.Fatal("serialized condition doesn't parse", s, err)
+		}
+		if !reflect.DeepEqual(reparsed, cond) {
+			t.Fatal("condition doesn't round trip", s)
+		}
+	})
+}
+
+// Parses the payload of a fulfillment of the given type, and checks that
+// it serializes to a payload that parses the same. Payloads of types with a
+// unique encoding must serialize to themselves.
+func fuzzPayload(t *testing.T, id uint16, payload []byte, unique bool) {
+	typ, err := registry.Lookup(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	typ.FulfillmentToCondition(payload)
+	typ.Cost(payload)
+	typ.Validate(payload, nil)
+
+	ful, err := typ.ParseFulfillment(payload)
+	if err != nil {
+		return
+	}
+
+	serialized := ful.Serialize()
+	_, reserialized, err := registry.SplitFulfillment(serialized)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if unique && !bytes.Equal(reserialized, payload) {
+		t.Fatal("payload doesn't round trip", hex.EncodeToString(payload), serialized)
+	}
+
+	reparsed, err := typ.ParseFulfillment(reserialized)
+	if err != nil {
+		t.Fatal("serialized payload doesn't parse", hex.EncodeToString(payload), serialized, err)
+	}
+	if reparsed.Serialize() != serialized {
+		t.Fatal("payload doesn't round trip", hex.EncodeToString(payload), serialized)
+	}
+}
+
+func FuzzSha256(f *testing.F) {
+	f.Add([]byte{42})
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		fuzzPayload(t, Sha256.TypeID, payload, true)
+	})
+}
+
+func FuzzEd25519Sha256(f *testing.F) {
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		fuzzPayload(t, Ed25519Sha256.TypeID, payload, true)
+	})
+}
+
+func FuzzRsaSha256(f *testing.F) {
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		fuzzPayload(t, RsaSha256.TypeID, payload, true)
+	})
+}
+
+func FuzzPrefixSha256(f *testing.F) {
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		fuzzPayload(t, PrefixSha256.TypeID, payload, true)
+	})
+}
+
+// Threshold entries are sorted when serializing, so only the serialized
+// form round trips
+func FuzzThresholdSha256(f *testing.F) {
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		fuzzPayload(t, ThresholdSha256.TypeID, payload, false)
+	})
+}
+
+func FuzzWeightedStrings(f *testing.F) {
+	for _, s := range fuzzThresholds {
+		_, payload, err := registry.SplitFulfillment(s)
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		// The entries follow the threshold
+		_, entries, err := encoding.GetUvarint(payload)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(entries)
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		ws, err := CryptoConditions.ParseWeightedStrings(b)
+		if err != nil {
+			return
+		}
+
+		// Uvarints are minimal, so the encoding is unique
+		items := [][]byte{}
+		for _, w := range ws {
+			items = append(items, append(encoding.MakeUvarint(uint64(w.Weight)), encoding.MakeVarbyte(w.String)...))
+		}
+		if !bytes.Equal(encoding.MakeVarray(items), b) {
+			t.Fatal("weighted strings don't round trip", hex.EncodeToString(b))
+		}
+	})
+}
+
+func FuzzBinaryFulfillment(f *testing.F) {
+	for _, s := range fuzzFulfillments {
+		b, err := entry.FulfillmentToBinary(s)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		registry.ValidateBinary(b, nil)
+
+		s, err := entry.BinaryToFulfillment(b)
+		if err != nil {
+			return
+		}
+
+		converted, err := entry.FulfillmentToBinary(s)
+		if err != nil {
+			t.Fatal("converted fulfillment doesn't convert back", s, err)
+		}
+		if !bytes.Equal(converted, b) {
+			t.Fatal("binary fulfillment doesn't round trip", hex.EncodeToString(b), s)
+		}
+	})
+}
+
+func FuzzJSON(f *testing.F) {
+	for _, s := range append(fuzzFulfillments, fuzzConditions...) {
+		b, err := entry.ToJSON(s)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		s, err := entry.FromJSON(b)
+		if err != nil {
+			return
+		}
+
+		converted, err := entry.ToJSON(s)
+		if err != nil {
+			t.Fatal("converted string doesn't convert back", s, err)
+		}
+
+		reconverted, err := entry.FromJSON(converted)
+		if err != nil || reconverted != s {
+			t.Fatal("JSON doesn't round trip", string(b), s, err)
+		}
+	})
+}
+
+func FuzzDERFulfillment(f *testing.F) {
+	for _, v := range derVectors {
+		b, err := hex.DecodeString(v.fulfillment)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		der.Validate(b, nil)
+
+		ful, err := der.ParseFulfillment(b)
+		if err != nil {
+			return
+		}
+
+		encoded, err := ful.Encode()
+		if err != nil {
+			return
+		}
+
+		reparsed, err := der.ParseFulfillment(encoded)
+		if err != nil {
+			t.Fatal("encoded fulfillment doesn't parse", hex.EncodeToString(b), err)
+		}
+
+		reencoded, err := reparsed.Encode()
+		if err != nil || !bytes.Equal(reencoded, encoded) {
+			t.Fatal("DER fulfillment doesn't round trip", hex.EncodeToString(b), err)
+		}
+	})
+}
+
+func FuzzDERCondition(f *testing.F) {
+	for _, v := range derVectors {
+		b, err := hex.DecodeString(v.condition)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		cond, err := der.ParseCondition(b)
+		if err != nil {
+			return
+		}
+
+		reparsed, err := der.ParseCondition(cond.Encode())
+		if err != nil || !reflect.DeepEqual(reparsed, cond) {
+			t.Fatal("DER condition doesn't round trip", hex.EncodeToString(b), err)
+		}
+	})
+}
+
+func FuzzURI(f *testing.F) {
+	for _, v := range derVectors {
+		f.Add(v.uri)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		cond, err := der.ParseURI(s)
+		if err != nil {
+			return
+		}
+
+		reparsed, err := der.ParseURI(cond.URI())
+		if err != nil || !reflect.DeepEqual(reparsed, cond) {
+			t.Fatal("URI doesn't round trip", s, cond.URI(), err)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/der"
+	"github.com/jtremback/crypto-conditions/ed25519sha256"
+	"github.com/jtremback/crypto-conditions/signer"
+)
+
+// Mock of a signer whose key is held by a remote daemon, reached over a
+// channel. The daemon can be made to return bad signatures.
+type remoteSigner struct {
+	public   ed25519.PublicKey
+	requests chan *signRequest
+}
+
+type signRequest struct {
+	message []byte
+	reply   chan []byte
+}
+
+func newRemoteSigner(key ed25519.PrivateKey, corrupt bool) *remoteSigner {
+	s := &remoteSigner{
+		public:   key.Public().(ed25519.PublicKey),
+		requests: make(chan *signRequest),
+	}
+
+	go func() {
+		for req := range s.requests {
+			sig := ed25519.Sign(key, req.message)
+			if corrupt {
+				sig[0] ^= 1
+			}
+			req.reply <- sig
+		}
+	}()
+
+	return s
+}
+
+func (s *remoteSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *remoteSigner) Sign(rand io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != 0 {
+		return nil, errors.New("Ed25519 signs messages, not digests")
+	}
+
+	req := &signRequest{message: message, reply: make(chan []byte)}
+	s.requests <- req
+	return <-req.reply, nil
+}
+
+func TestSigner(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(privkey1[:32])
+
+	remote := newRemoteSigner(key, false)
+	defer close(remote.requests)
+
+	// The signature doesn't depend on where the key is kept
+	ful := &Ed25519Sha256.Fulfillment{FixedMessage: []byte("hello")}
+	if err := ful.SignWith(remote); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Ed25519Sha256.Fulfillment{PublicKey: pubkey1, FixedMessage: []byte("hello")}
+	expected.Sign(privkey1)
+	if ful.Serialize() != expected.Serialize() {
+		t.Fatal("remote signature incorrect", ful.Serialize())
+	}
+
+	// Bad signatures are caught when signing
+	corrupt := newRemoteSigner(key, true)
+	defer close(corrupt.requests)
+
+	derFul := &der.Ed25519Sha256{}
+	if err := derFul.SignWith(corrupt, []byte("hello")); !errors.Is(err, conderr.ErrInvalidSignature) {
+		t.Fatal("accepted a bad signature", err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := derFul.SignWith(rsaKey, []byte("hello")); err != signer.ErrNotEd25519 {
+		t.Fatal("signed with an RSA key", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := signer.WriteKeyFile(path, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.WriteKeyFile(path, key); err == nil {
+		t.Fatal("overwrote a key file")
+	}
+
+	file, err := signer.NewFileSigner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := derFul.SignWith(file, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if derFul.PublicKey != pubkey1 {
+		t.Fatal("public key incorrect")
+	}
+	if err := derFul.Validate([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := signer.NewFileSigner(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Fatal("loaded a missing key file")
+	}
+}
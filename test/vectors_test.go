@@ -0,0 +1,170 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/der"
+)
+
+// Test vectors in the JSON format of the published crypto-conditions test
+// suite. Those in testdata/vectors are published vectors. Those in
+// testdata/golden were computed from the spec independently of this
+// library, and only guard against regressions. Valid vectors must parse,
+// encode and validate exactly as written. Invalid vectors must be rejected
+// for their condition with the error named by "error".
+type vector struct {
+	Fulfillment         string   `json:"fulfillment"`
+	ConditionBinary     string   `json:"conditionBinary"`
+	ConditionURI        string   `json:"conditionUri"`
+	Cost                uint64   `json:"cost"`
+	Subtypes            []string `json:"subtypes"`
+	FingerprintContents string   `json:"fingerprintContents"`
+	Message             string   `json:"message"`
+	Error               string   `json:"error"`
+}
+
+var vectorErrors = map[string]error{
+	"malformed":         conderr.ErrMalformed,
+	"invalid signature": conderr.ErrInvalidSignature,
+	"threshold not met": conderr.ErrThresholdNotMet,
+	"message too long":  conderr.ErrMessageTooLong,
+	"mismatch":          conderr.ErrConditionMismatch,
+}
+
+func loadVectors(t *testing.T, dir string) map[string]*vector {
+	paths, err := filepath.Glob(filepath.Join("testdata", dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no vectors in", dir)
+	}
+
+	vectors := map[string]*vector{}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		v := &vector{}
+		if err := json.Unmarshal(b, v); err != nil {
+			t.Fatal(path, err)
+		}
+		vectors[strings.TrimSuffix(filepath.Base(path), ".json")] = v
+	}
+
+	return vectors
+}
+
+func TestVectors(t *testing.T) {
+	for name, v := range loadVectors(t, "vectors/valid") {
+		t.Run(name, func(t *testing.T) {
+			testValidVector(t, v)
+		})
+	}
+}
+
+func TestGolden(t *testing.T) {
+	for name, v := range loadVectors(t, "golden/valid") {
+		t.Run(name, func(t *testing.T) {
+			testValidVector(t, v)
+		})
+	}
+
+	for name, v := range loadVectors(t, "golden/invalid") {
+		t.Run(name, func(t *testing.T) {
+			testInvalidVector(t, v)
+		})
+	}
+}
+
+// Checks a valid vector exactly as written
+func testValidVector(t *testing.T, v *vector) {
+	b := unhex(t, v.Fulfillment)
+	message := unhex(t, v.Message)
+
+	ful, err := der.ParseFulfillment(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := ful.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, b) {
+		t.Fatal("fulfillment encoding incorrect", hex.EncodeToString(encoded))
+	}
+
+	cond, err := ful.Condition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cond.Encode(), unhex(t, v.ConditionBinary)) {
+		t.Fatal("condition encoding incorrect", hex.EncodeToString(cond.Encode()))
+	}
+	if cond.URI() != v.ConditionURI {
+		t.Fatal("condition URI incorrect", cond.URI())
+	}
+	if cond.Cost != v.Cost {
+		t.Fatal("cost incorrect", cond.Cost)
+	}
+
+	subtypes := []string{}
+	for typ, name := range der.TypeNames {
+		if cond.Subtypes&(1<<uint(typ)) != 0 {
+			subtypes = append(subtypes, name)
+		}
+	}
+	if !reflect.DeepEqual(subtypes, v.Subtypes) {
+		t.Fatal("subtypes incorrect", subtypes)
+	}
+
+	fingerprint := sha256.Sum256(unhex(t, v.FingerprintContents))
+	if !bytes.Equal(cond.Fingerprint, fingerprint[:]) {
+		t.Fatal("fingerprint incorrect", hex.EncodeToString(cond.Fingerprint))
+	}
+
+	parsed, err := der.ParseCondition(unhex(t, v.ConditionBinary))
+	if err != nil || !reflect.DeepEqual(parsed, cond) {
+		t.Fatal("condition doesn't parse", err)
+	}
+
+	parsed, err = der.ParseURI(v.ConditionURI)
+	if err != nil || !reflect.DeepEqual(parsed, cond) {
+		t.Fatal("condition URI doesn't parse", err)
+	}
+
+	if err := der.ValidateFulfillment(cond, b, message); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Checks that an invalid vector is rejected with the error it names
+func testInvalidVector(t *testing.T, v *vector) {
+	expected, ok := vectorErrors[v.Error]
+	if !ok {
+		t.Fatal("unknown error", v.Error)
+	}
+
+	cond, err := der.ParseCondition(unhex(t, v.ConditionBinary))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = der.ValidateFulfillment(cond, unhex(t, v.Fulfillment), unhex(t, v.Message))
+	if !errors.Is(err, expected) {
+		t.Fatal("expected", v.Error, "got", err)
+	}
+}
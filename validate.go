@@ -0,0 +1,82 @@
+package CryptoConditions
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jtremback/crypto-conditions/conderr"
+	"github.com/jtremback/crypto-conditions/entry"
+	"github.com/jtremback/crypto-conditions/registry"
+)
+
+// Type of raw Ed25519 fulfillments in the binary format before the
+// registry, which now numbers types like the string format, where 4 is
+// ThresholdSha256. Thresholds were type 2, now PrefixSha256.
+const legacyEd25519Type = 4
+
+// Reports raw Ed25519 fulfillments of the old numbering, which fail to
+// parse as thresholds, instead of the threshold parse error. Thresholds of
+// the old numbering can't be told apart from malformed prefixes.
+func checkLegacy(fulfillment []byte, err error) error {
+	var parseErr *conderr.ParseError
+	if !errors.As(err, &parseErr) || len(parseErr.Path) != 0 || !errors.Is(err, conderr.ErrMalformed) {
+		return err
+	}
+
+	typ, payload, splitErr := registry.SplitBinaryFulfillment(fulfillment)
+	if splitErr != nil || typ != legacyEd25519Type || len(payload) != 96 {
+		return err
+	}
+
+	return &conderr.ParseError{Type: typ, Err: &conderr.SyntaxError{Msg: "raw Ed25519 fulfillments of the old type 4 are no longer supported, use Ed25519Validate or Ed25519Sha256"}}
+}
+
+// Reads the type and the payload out of a binary fulfillment.
+func ParseFulfillment(b []byte) (uint16, []byte, error) {
+	return registry.SplitBinaryFulfillment(b)
+}
+
+// Validates a binary fulfillment of any registered type against a message.
+// Types are numbered as in the string format, so raw Ed25519 fulfillments
+// of the old type 4 are rejected with conderr.ErrMalformed.
+func Validate(fulfillment []byte, message []byte) error {
+	return checkLegacy(fulfillment, entry.ValidateBinary(fulfillment, message))
+}
+
+// Validates like Validate, stopping with a *conderr.CanceledError once ctx is
+// done.
+func ValidateContext(ctx context.Context, fulfillment []byte, message []byte) error {
+	return checkLegacy(fulfillment, entry.ValidateBinaryContext(ctx, fulfillment, message))
+}
+
+// Validates like ValidateContext, rejecting fulfillments that exceed the
+// limits of opts with a *conderr.LimitError.
+func ValidateWithOptions(ctx context.Context, fulfillment []byte, message []byte, opts *conderr.ValidationOptions) error {
+	return checkLegacy(fulfillment, entry.ValidateBinaryWithOptions(ctx, fulfillment, message, opts))
+}
+
+// Validates a binary fulfillment of any registered type against a binary
+// condition and a message. The condition derived from the fulfillment must
+// match the given one, otherwise a *conderr.MismatchError describes which
+// part doesn't.
+func ValidateFulfillment(condition []byte, fulfillment []byte, message []byte) error {
+	return checkLegacy(fulfillment, entry.ValidateBinaryFulfillment(condition, fulfillment, message))
+}
+
+// Validates like ValidateFulfillment, stopping with a *conderr.CanceledError
+// once ctx is done.
+func ValidateFulfillmentContext(ctx context.Context, condition []byte, fulfillment []byte, message []byte) error {
+	return checkLegacy(fulfillment, entry.ValidateBinaryFulfillmentContext(ctx, condition, fulfillment, message))
+}
+
+// Validates a binary fulfillment of any registered type against a message,
+// rejecting it without checking signatures if it costs more than maxCost.
+func ValidateWithMaxCost(fulfillment []byte, message []byte, maxCost uint64) error {
+	return checkLegacy(fulfillment, entry.ValidateBinaryWithMaxCost(fulfillment, message, maxCost))
+}
+
+// Validates like ValidateWithMaxCost, stopping with a *conderr.CanceledError
+// once ctx is done.
+func ValidateWithMaxCostContext(ctx context.Context, fulfillment []byte, message []byte, maxCost uint64) error {
+	return checkLegacy(fulfillment, entry.ValidateBinaryWithMaxCostContext(ctx, fulfillment, message, maxCost))
+}